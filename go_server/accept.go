@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// acceptBackoffMin and acceptBackoffMax bound the pause after a failed Accept. The pause doubles with
+// each failure in a row, so that running out of file descriptors doesn't spin the accept loop.
+const (
+	acceptBackoffMin = 5 * time.Millisecond
+	acceptBackoffMax = time.Second
+)
+
+// acceptThrottle limits the rate connections are accepted at, across every listener. Connections beyond
+// the rate wait in the listen backlog until they can be accepted.
+type acceptThrottle struct {
+	mutex  sync.Mutex   // mutex protects access to bucket
+	bucket *tokenBucket // bucket limits the rate of accepted connections
+}
+
+// newAcceptThrottle creates the throttle for the configured accept rate, or nil if it is unlimited.
+func newAcceptThrottle(config Config) *acceptThrottle {
+
+	if config.AcceptRate <= 0 {
+		return nil
+	}
+	return &acceptThrottle{bucket: newTokenBucket(config.AcceptRate, max(config.AcceptBurst, 1))}
+}
+
+// wait blocks until another connection may be accepted. A nil throttle never blocks.
+func (throttle *acceptThrottle) wait() {
+
+	if throttle == nil {
+		return
+	}
+
+	for {
+		throttle.mutex.Lock()
+		allowed, delay := throttle.bucket.take()
+		throttle.mutex.Unlock()
+
+		if allowed {
+			return
+		}
+		time.Sleep(delay)
+	}
+}
+
+// acceptBackoff is the exponential backoff of one accept loop after failed Accepts.
+type acceptBackoff struct {
+	delay   time.Duration // delay is the pause after the latest failure, or 0 after a success
+	failing *atomic.Int32 // failing counts the server's accept loops whose latest Accept failed, for readiness checks
+}
+
+// failed returns how long to pause after another failed Accept.
+func (backoff *acceptBackoff) failed() time.Duration {
+
+	if backoff.delay == 0 {
+		backoff.failing.Add(1)
+	}
+	backoff.delay = min(max(backoff.delay*2, acceptBackoffMin), acceptBackoffMax)
+	return backoff.delay
+}
+
+// succeeded resets the backoff after a successful Accept.
+func (backoff *acceptBackoff) succeeded() {
+
+	if backoff.delay > 0 {
+		backoff.failing.Add(-1)
+	}
+	backoff.delay = 0
+}
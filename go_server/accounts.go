@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+)
+
+// minPasswordLength is the shortest password accepted by /REGISTER.
+const minPasswordLength = 8
+
+// handleRegisterCommand creates an account for the user's current nickname, reserving the nickname
+// for whoever knows the password, and logs the user in to it.
+func (server *ChatServer) handleRegisterCommand(conn net.Conn, password string) {
+
+	if len(password) < minPasswordLength {
+		sendReplyf(conn, errInvalidArgument, "Passwords must be at least %d characters long", minPasswordLength)
+		return
+	}
+
+	var nickname string
+	var registered bool
+	server.onHub(func() {
+		nickname, registered = server.users.lookup(conn)
+	})
+
+	if !registered {
+		sendReply(conn, errNotRegistered, "You must register a nickname before you can create an account")
+		return
+	}
+
+	err := server.accounts.Create(nickname, password)
+	if errors.Is(err, ErrAccountExists) {
+		sendReplyf(conn, errAlreadyExists, "%s is already a registered nickname", nickname)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to create account", "account", nickname, "err", err)
+		sendReply(conn, errUnavailable, "Registration failed; please try again")
+		return
+	}
+
+	server.onHub(func() {
+		client, connected := server.clients[conn]
+		if !connected {
+			return
+		}
+		client.account = nickname
+
+		server.clientLog(conn).Info("Registered account", "account", nickname)
+		sendReplyf(conn, rplLoggedIn, "Registered %s; you are now logged in", nickname)
+	})
+}
+
+// handleLoginCommand logs a user in to an account, switching them to the account's nickname if it is free.
+func (server *ChatServer) handleLoginCommand(conn net.Conn, nickname string, password string) {
+
+	err := server.accounts.Authenticate(nickname, password)
+	if errors.Is(err, ErrNoSuchAccount) {
+		sendReplyf(conn, errNoSuchAccount, "%s is not a registered nickname", nickname)
+		return
+	}
+	if errors.Is(err, ErrAuthUnavailable) {
+		sendReply(conn, errUnavailable, "Logins are unavailable right now; try again later")
+		return
+	}
+
+	// The account's nickname is only claimed once the password has been checked
+	claim := nicknameClaim{nickname: nickname}
+	if err == nil {
+		claim = server.claimNickname(nickname)
+	}
+
+	server.onHubWithClaim(claim, func() {
+		client, connected := server.clients[conn]
+		if !connected {
+			return
+		}
+
+		if err != nil {
+			server.clientLog(conn).Warn("Failed /LOGIN attempt", "account", nickname)
+			sendReplyf(conn, errPasswordMismatch, "Incorrect password for %s", nickname)
+			server.recordFailure(conn, "a failed /LOGIN attempt")
+			return
+		}
+
+		client.account = nickname
+		server.clientLog(conn).Info("Logged in", "account", nickname)
+		sendReplyf(conn, rplLoggedIn, "You are now logged in as %s", nickname)
+		server.deliverOfflineMessages(conn, nickname)
+
+		if holder, inUse := server.findUserByNickname(nickname); inUse && holder != conn {
+			if server.clients[holder].account == nickname {
+				server.attachDevice(conn, holder)
+				return
+			}
+			sendReplyf(conn, errNicknameInUse, "%s is in use by another session; use %s %s <password> to disconnect it", nickname, GHOST, nickname)
+			return
+		}
+
+		if server.users.nickname(conn) != nickname {
+			server.setNickname(conn, claim)
+		}
+	})
+}
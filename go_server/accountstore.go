@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors returned by AccountStore implementations.
+var (
+	ErrAccountExists = errors.New("account already exists")
+	ErrNoSuchAccount = errors.New("no such account")
+	ErrWrongPassword = errors.New("incorrect password")
+
+	// ErrAuthUnavailable is returned when an external authentication service can't be reached
+	ErrAuthUnavailable = errors.New("authentication service unavailable")
+)
+
+// Account is a registered nickname. Password material never leaves the AccountStore.
+type Account struct {
+	Nickname         string
+	RegisteredAt     time.Time
+	Role             Role     // Role is the account's rank on the server
+	CertFingerprints []string // CertFingerprints identify the account's TLS client certificates
+}
+
+// AccountStore stores registered accounts and checks their passwords, so that backends such as SQL
+// or LDAP can replace the default file store. Implementations must be safe for concurrent use.
+type AccountStore interface {
+	// Create registers a new account with the given password, returning ErrAccountExists if the nickname is taken.
+	Create(nickname string, password string) error
+
+	// Authenticate checks a password, returning ErrNoSuchAccount or ErrWrongPassword when it doesn't match.
+	Authenticate(nickname string, password string) error
+
+	// Get returns the account registered for a nickname.
+	Get(nickname string) (Account, bool)
+
+	// Delete removes an account, returning ErrNoSuchAccount if it doesn't exist.
+	Delete(nickname string) error
+
+	// SetRole changes an account's role.
+	SetRole(nickname string, role Role) error
+
+	// SetCertFingerprints replaces the client certificates bound to an account.
+	SetCertFingerprints(nickname string, fingerprints []string) error
+
+	// FindByCertFingerprint returns the account a client certificate is bound to.
+	FindByCertFingerprint(fingerprint string) (Account, bool)
+}
+
+// accountRecord is the on-disk representation of an account in a fileAccountStore.
+type accountRecord struct {
+	Nickname         string    `json:"nickname"`
+	PasswordHash     string    `json:"password_hash"` // PasswordHash is the bcrypt hash of the account's password
+	RegisteredAt     time.Time `json:"registered_at"`
+	Role             string    `json:"role,omitempty"` // Role is the name of the account's role; empty means user
+	CertFingerprints []string  `json:"cert_fingerprints,omitempty"`
+}
+
+// fileAccountStore is the default AccountStore, keeping accounts in a JSON file with bcrypt-hashed passwords.
+// An empty path keeps accounts in memory only.
+type fileAccountStore struct {
+	path string
+
+	mutex   sync.Mutex                // mutex protects access to records
+	records map[string]*accountRecord // records maps nicknames to their accounts
+}
+
+// newFileAccountStore creates an account store backed by the file at path, loading any accounts already saved there.
+// A missing file is not an error.
+func newFileAccountStore(path string) (*fileAccountStore, error) {
+
+	store := &fileAccountStore{path: path, records: make(map[string]*accountRecord)}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*accountRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		store.records[record.Nickname] = record
+	}
+
+	slog.Info("Loaded accounts", "count", len(records), "path", path)
+	return store, nil
+}
+
+// save writes every account to the store's file. The caller must hold store.mutex.
+func (store *fileAccountStore) save() error {
+
+	if store.path == "" {
+		return nil
+	}
+
+	records := make([]*accountRecord, 0, len(store.records))
+	for _, record := range store.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Nickname < records[j].Nickname })
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.path, data)
+}
+
+// Create implements AccountStore.
+func (store *fileAccountStore) Create(nickname string, password string) error {
+
+	// Hashing is deliberately slow, so it is done without holding the store lock
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, exists := store.records[nickname]; exists {
+		return ErrAccountExists
+	}
+
+	store.records[nickname] = &accountRecord{Nickname: nickname, PasswordHash: string(hash), RegisteredAt: time.Now()}
+	return store.save()
+}
+
+// Authenticate implements AccountStore.
+func (store *fileAccountStore) Authenticate(nickname string, password string) error {
+
+	store.mutex.Lock()
+	record, exists := store.records[nickname]
+	var passwordHash string
+	if exists {
+		passwordHash = record.PasswordHash
+	}
+	store.mutex.Unlock()
+
+	if !exists {
+		return ErrNoSuchAccount
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// Get implements AccountStore.
+func (store *fileAccountStore) Get(nickname string) (Account, bool) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	record, exists := store.records[nickname]
+	if !exists {
+		return Account{}, false
+	}
+	return record.account(), true
+}
+
+// Delete implements AccountStore.
+func (store *fileAccountStore) Delete(nickname string) error {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, exists := store.records[nickname]; !exists {
+		return ErrNoSuchAccount
+	}
+
+	delete(store.records, nickname)
+	return store.save()
+}
+
+// SetRole implements AccountStore.
+func (store *fileAccountStore) SetRole(nickname string, role Role) error {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	record, exists := store.records[nickname]
+	if !exists {
+		return ErrNoSuchAccount
+	}
+
+	record.Role = role.String()
+	return store.save()
+}
+
+// SetCertFingerprints implements AccountStore. A certificate identifies only one account,
+// so binding it here removes it from any other account.
+func (store *fileAccountStore) SetCertFingerprints(nickname string, fingerprints []string) error {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	record, exists := store.records[nickname]
+	if !exists {
+		return ErrNoSuchAccount
+	}
+
+	for _, other := range store.records {
+		other.CertFingerprints = slices.DeleteFunc(other.CertFingerprints, func(bound string) bool {
+			return slices.Contains(fingerprints, bound)
+		})
+	}
+	record.CertFingerprints = slices.Clone(fingerprints)
+
+	return store.save()
+}
+
+// FindByCertFingerprint implements AccountStore.
+func (store *fileAccountStore) FindByCertFingerprint(fingerprint string) (Account, bool) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, record := range store.records {
+		if slices.Contains(record.CertFingerprints, fingerprint) {
+			return record.account(), true
+		}
+	}
+	return Account{}, false
+}
+
+// snapshot returns a copy of every account record, sorted by nickname, for state snapshots.
+func (store *fileAccountStore) snapshot() []accountRecord {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	records := make([]accountRecord, 0, len(store.records))
+	for _, record := range store.records {
+		copied := *record
+		copied.CertFingerprints = slices.Clone(record.CertFingerprints)
+		records = append(records, copied)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Nickname < records[j].Nickname })
+	return records
+}
+
+// restore adds account records recovered from a state snapshot, keeping any account already in the store.
+func (store *fileAccountStore) restore(records []accountRecord) error {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, record := range records {
+		if _, exists := store.records[record.Nickname]; !exists {
+			restored := record
+			store.records[record.Nickname] = &restored
+		}
+	}
+	return store.save()
+}
+
+// account returns the public view of a record.
+func (record *accountRecord) account() Account {
+
+	role, valid := parseRole(record.Role)
+	if !valid || role == RoleGuest {
+		role = RoleUser
+	}
+
+	return Account{
+		Nickname:         record.Nickname,
+		RegisteredAt:     record.RegisteredAt,
+		Role:             role,
+		CertFingerprints: slices.Clone(record.CertFingerprints),
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// NACK codes tell a client why a message it sent to a target was refused.
+const (
+	nackNotRegistered  = "NOT_REGISTERED"   // nackNotRegistered refuses messages from clients without a nickname
+	nackForbidden      = "FORBIDDEN"        // nackForbidden refuses messages from tokens without the send scope
+	nackMuted          = "MUTED"            // nackMuted refuses messages from muted users
+	nackBlockedLink    = "BLOCKED_LINK"     // nackBlockedLink refuses messages linking to a blocked domain
+	nackRepeated       = "REPEATED"         // nackRepeated refuses a message repeated too many times
+	nackNotInChannel   = "NOT_IN_CHANNEL"   // nackNotInChannel refuses messages to a channel the sender is not in
+	nackModerated      = "MODERATED"        // nackModerated refuses messages to a moderated channel from members without voice
+	nackSlowMode       = "SLOW_MODE"        // nackSlowMode refuses messages sent before a channel's slow mode allows
+	nackNoSuchNick     = "NO_SUCH_NICK"     // nackNoSuchNick refuses messages to a nickname that is offline and can't hold messages
+	nackQueueFull      = "QUEUE_FULL"       // nackQueueFull refuses messages to an offline nickname with too many messages waiting
+	nackInvalidDedupID = "INVALID_DEDUP_ID" // nackInvalidDedupID refuses messages with a dedup ID that is too long
+)
+
+// acknowledgment is the ID an accepted message was given for a target.
+type acknowledgment struct {
+	id     int64
+	target string
+}
+
+// nextMessageID assigns the ID of a newly accepted message. IDs are shared with the storage, so a message
+// can be found again by the ID its sender was given. It must run on the hub goroutine.
+func (server *ChatServer) nextMessageID() int64 {
+
+	server.lastMessageID++
+	server.messageRate.record(time.Now())
+	return server.lastMessageID
+}
+
+// acknowledgeMessage tells a client with the message-ids capability that its message to target, a channel, "*", or comma-separated nicknames,
+// was accepted with an ID.
+func acknowledgeMessage(conn net.Conn, id int64, target string) {
+
+	if hasCapability(conn, capMessageIDs) {
+		fmt.Fprintf(conn, "ACK %d %s\n", id, target)
+	}
+}
+
+// refuseMessage tells a client with the message-ids capability that its message to target was refused, with a NACK code giving the reason.
+// The human-readable reason is sent separately, as it always has been.
+func refuseMessage(conn net.Conn, code string, target string) {
+
+	if hasCapability(conn, capMessageIDs) {
+		fmt.Fprintf(conn, "NACK %s %s\n", code, strings.TrimSpace(target))
+	}
+}
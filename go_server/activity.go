@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+// activityDateFormat keys the daily message counters
+const activityDateFormat = "2006-01-02"
+
+// activityRetentionDays is how many days of message counters are kept, enough to cover a week
+const activityRetentionDays = 7
+
+// activityFlushInterval is how often the message counters are written to disk
+const activityFlushInterval = time.Minute
+
+// loadActivity reads the per-user daily message counters from the configured file. A missing file is not an error.
+func (server *ChatServer) loadActivity() error {
+
+	if server.config.ActivityFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(server.config.ActivityFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	server.onHub(func() {
+		err = json.Unmarshal(data, &server.activity)
+	})
+	return err
+}
+
+// flushActivity periodically writes the message counters to disk when they have changed.
+// It runs for the lifetime of the server.
+func (server *ChatServer) flushActivity() {
+
+	ticker := time.NewTicker(activityFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var data []byte
+		var err error
+		server.onHub(func() {
+			if server.activityChanged {
+				data, err = json.Marshal(server.activity)
+				server.activityChanged = false
+			}
+		})
+
+		if data == nil && err == nil {
+			continue
+		}
+		if err != nil {
+			slog.Error("Failed to encode activity counters", "err", err)
+			continue
+		}
+		if err := writeFileAtomic(server.config.ActivityFile, data); err != nil {
+			slog.Error("Failed to save activity counters", "err", err)
+		}
+	}
+}
+
+// recordActivity counts a message sent by a nickname towards today's total and discards
+// counters older than the retention period.
+func (server *ChatServer) recordActivity(nickname string) {
+
+	today := time.Now().Format(activityDateFormat)
+	oldest := time.Now().AddDate(0, 0, -activityRetentionDays).Format(activityDateFormat)
+
+	server.onHub(func() {
+		days, exists := server.activity[nickname]
+		if !exists {
+			days = make(map[string]int)
+			server.activity[nickname] = days
+		}
+		days[today]++
+
+		for day := range days {
+			if day <= oldest {
+				delete(days, day)
+			}
+		}
+
+		server.activityChanged = true
+	})
+}
+
+// userActivity is a user's message count over a period.
+type userActivity struct {
+	nickname string
+	messages int
+}
+
+// mostActive returns at most limit users who sent the most messages over the last number of days, most active first.
+// It must run on the hub goroutine.
+func (server *ChatServer) mostActive(days int, limit int) []userActivity {
+
+	oldest := time.Now().AddDate(0, 0, -days).Format(activityDateFormat)
+
+	var ranking []userActivity
+	for nickname, counts := range server.activity {
+		total := 0
+		for day, count := range counts {
+			if day > oldest {
+				total += count
+			}
+		}
+		if total > 0 {
+			ranking = append(ranking, userActivity{nickname, total})
+		}
+	}
+
+	sort.Slice(ranking, func(i, j int) bool {
+		if ranking[i].messages != ranking[j].messages {
+			return ranking[i].messages > ranking[j].messages
+		}
+		return ranking[i].nickname < ranking[j].nickname
+	})
+	if len(ranking) > limit {
+		ranking = ranking[:limit]
+	}
+	return ranking
+}
+
+// handleTopCommand sends the requesting client the ten most active users over the last day or week.
+func (server *ChatServer) handleTopCommand(conn net.Conn, period string) {
+
+	var days int
+	switch period {
+
+		case "", "DAY":
+			period, days = "day", 1
+
+		case "WEEK":
+			period, days = "week", 7
+
+		default:
+			sendReply(conn, errUsage, "Usage: /TOP [DAY|WEEK]")
+			return
+	}
+
+	var ranking []userActivity
+	server.onHub(func() {
+		ranking = server.mostActive(days, 10)
+	})
+
+	if len(ranking) == 0 {
+		sendReplyf(conn, rplTop, "No activity in the last %s", period)
+		return
+	}
+
+	sendReplyf(conn, rplTop, "Most active users in the last %s:", period)
+	for rank, entry := range ranking {
+		sendReplyf(conn, rplTop, "%d. %s (%d messages)", rank+1, entry.nickname, entry.messages)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// apiMessagesPath is the HTTP API endpoint external systems post messages to.
+const apiMessagesPath = "/api/messages"
+
+// apiMaxBodySize is the largest request body the HTTP API accepts.
+const apiMaxBodySize = 64 << 10
+
+// apiMessage is the body of a request to post a message through the HTTP API.
+type apiMessage struct {
+	To   string `json:"to"`   // To is the channel or nickname the message is sent to
+	Text string `json:"text"` // Text is the message, which must be a single line
+}
+
+// apiMessageAccepted is the response to a message posted through the HTTP API.
+type apiMessageAccepted struct {
+	ID int64 `json:"id"` // ID is the message's ID, the same as in the message history
+}
+
+// errNoRecipient is returned when an API message is sent to a channel that does not exist or a user who is not online.
+var errNoRecipient = errors.New("no such channel or user online")
+
+// authenticateAPIRequest returns the bot token a request carries as a bearer token, if it is valid
+// and grants the scope.
+func (server *ChatServer) authenticateAPIRequest(request *http.Request, scope string) (*authToken, int, string) {
+
+	token, hasBearer := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if !hasBearer || token == "" {
+		return nil, http.StatusUnauthorized, "missing bearer token"
+	}
+
+	var matched *authToken
+	server.onHub(func() {
+		matched = server.findToken(token)
+	})
+
+	if matched == nil {
+		slog.Warn("Failed HTTP API authentication", "remote", request.RemoteAddr)
+		return nil, http.StatusUnauthorized, "invalid token"
+	}
+	if !slices.Contains(matched.Scopes, scope) {
+		return nil, http.StatusForbidden, fmt.Sprintf("token does not have the %s scope", scope)
+	}
+	return matched, http.StatusOK, ""
+}
+
+// handlePostMessage posts a message from an external system to a channel or an online user. Requests
+// authenticate with a bot token that has the send scope, and the message is sent in the token's name.
+func (server *ChatServer) handlePostMessage(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, status, reason := server.authenticateAPIRequest(request, ScopeSend)
+	if token == nil {
+		writer.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(writer, reason, status)
+		return
+	}
+
+	var message apiMessage
+	if err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, apiMaxBodySize)).Decode(&message); err != nil {
+		http.Error(writer, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	message.Text = strings.TrimSpace(message.Text)
+	if message.To == "" || message.Text == "" {
+		http.Error(writer, `"to" and "text" are required`, http.StatusBadRequest)
+		return
+	}
+	if strings.ContainsAny(message.Text, "\r\n") {
+		http.Error(writer, "text must be a single line", http.StatusBadRequest)
+		return
+	}
+
+	id, err := server.postMessage(token.Name, message.To, message.Text)
+	if err != nil {
+		if errors.Is(err, errNoRecipient) {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(writer, err.Error(), http.StatusForbidden)
+		}
+		return
+	}
+
+	slog.Info("Message posted through the HTTP API", "token", token.Name, "to", message.To)
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(apiMessageAccepted{ID: id})
+}
+
+// apiChannelRole returns the role a bot token's nickname holds in a channel through the HTTP API, refusing
+// channels it may not post to or stream. A token acts as its nickname: it may use a channel that a connection with the
+// nickname has joined, or one that nickname could join without an invitation or key. It must run on the hub goroutine.
+func (server *ChatServer) apiChannelRole(nickname string, channel *Channel) (ChannelRole, error) {
+
+	joined := false
+	role := ChannelMember
+	for _, connection := range server.users.connections(nickname) {
+		if memberRole, isMember := channel.members[connection]; isMember {
+			joined = true
+			role = max(role, memberRole)
+		}
+	}
+	if joined {
+		return role, nil
+	}
+
+	switch {
+
+		case channel.isBanned(nickname, ""):
+			return role, fmt.Errorf("%s is banned from %s", nickname, channel.name)
+
+		case channel.inviteOnly && !channel.invited[nickname]:
+			return role, fmt.Errorf("%s is invite only", channel.name)
+
+		case channel.key != "":
+			return role, fmt.Errorf("%s requires a key", channel.name)
+	}
+	return role, nil
+}
+
+// postMessage delivers a message from a sender who is not connected, such as an external system, to the
+// members of a channel or to an online user, presenting it like a message sent over a connection. The
+// message passes the same checks as one sent over a connection. It returns the message's ID.
+func (server *ChatServer) postMessage(senderNickname string, recipient string, message string) (int64, error) {
+
+	var id int64
+	var err error
+	server.onHub(func() {
+		if remainingMute := server.muteRemaining(senderNickname); remainingMute > 0 {
+			err = fmt.Errorf("%s is muted", senderNickname)
+			return
+		}
+
+		if host, allowed := server.screenLinks(nil, senderNickname, recipient, message); !allowed {
+			err = fmt.Errorf("links to %s are not allowed", host)
+			return
+		}
+
+		text := formatMessage(SpeechMessage, senderNickname, message)
+
+		if isChannelName(recipient) {
+			id, err = server.postToChannel(senderNickname, recipient, text)
+			return
+		}
+
+		connections := server.users.connections(recipient)
+		if len(connections) == 0 {
+			err = errNoRecipient
+			return
+		}
+		for _, connection := range connections {
+			if server.canDeliverFrom(senderNickname, connection) {
+				server.deliver(connection, text)
+			}
+		}
+
+		// Private messages are never recorded for /SEEN, and shadow-banned ones are not stored either
+		id = server.nextMessageID()
+		if !server.shadowBans[senderNickname] {
+			server.storeMessage(StoredMessage{ID: id, Sender: senderNickname, Recipients: []string{recipient}, Body: text})
+		}
+	})
+	return id, err
+}
+
+// postToChannel is the channel branch of postMessage, applying the channel's bans, invitation, key,
+// moderation, and slow mode to the token's nickname. It must run on the hub goroutine.
+func (server *ChatServer) postToChannel(senderNickname string, channelName string, text string) (int64, error) {
+
+	channel, exists := server.channels[channelName]
+	if !exists {
+		return 0, errNoRecipient
+	}
+
+	role, err := server.apiChannelRole(senderNickname, channel)
+	if err != nil {
+		return 0, err
+	}
+	if channel.moderated && role == ChannelMember {
+		return 0, fmt.Errorf("%s is moderated and only operators and voiced users may speak", channelName)
+	}
+	if channel.slowMode > 0 && role != ChannelOperator {
+		if wait := channel.slowMode - time.Since(channel.lastSpoke[senderNickname]); wait > 0 {
+			return 0, fmt.Errorf("slow mode is on in %s, wait %d seconds before speaking again", channelName, int(math.Ceil(wait.Seconds())))
+		}
+		channel.lastSpoke[senderNickname] = time.Now()
+	}
+
+	body := text
+	text = fmt.Sprintf("[%s] %s", channelName, text)
+
+	recipients := make([]net.Conn, 0, len(channel.members))
+	for member := range channel.members {
+		if server.canDeliverFrom(senderNickname, member) {
+			recipients = append(recipients, member)
+		}
+	}
+	payload := newMessagePayload(text)
+	server.fanOut(recipients, payload)
+	payload.release()
+
+	id := server.nextMessageID()
+	if server.shadowBans[senderNickname] {
+		return id, nil
+	}
+
+	server.recordChannelHistory(channel, text)
+	server.recordLastMessage(senderNickname, text)
+	server.storeMessage(StoredMessage{ID: id, Sender: senderNickname, Channel: channelName, Body: body})
+	return id, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Archive formats supported by ExportArchive.
+const (
+	ArchiveJSON = "json"
+	ArchiveCSV  = "csv"
+)
+
+// archiveDateFormat is the format of the dates given to /ARCHIVE.
+const archiveDateFormat = "2006-01-02"
+
+// archiveRecord is the exported form of a stored message.
+type archiveRecord struct {
+	ID         int64     `json:"id"`
+	SentAt     time.Time `json:"sent_at"`
+	Sender     string    `json:"sender"`
+	Channel    string    `json:"channel,omitempty"`
+	Recipients []string  `json:"recipients,omitempty"`
+	Body       string    `json:"body"`
+}
+
+// ExportArchive writes the stored messages matching a query to w as JSON or CSV, returning how many
+// messages it wrote. It backs /ARCHIVE and can be used directly by tools working with the same storage.
+func ExportArchive(storage Storage, query MessageQuery, format string, w io.Writer) (int, error) {
+
+	messages, err := storage.LoadMessages(query)
+	if err != nil {
+		return 0, err
+	}
+
+	switch format {
+
+		case ArchiveJSON:
+			records := make([]archiveRecord, 0, len(messages))
+			for _, message := range messages {
+				records = append(records, archiveRecord{
+					ID:         message.ID,
+					SentAt:     message.SentAt,
+					Sender:     message.Sender,
+					Channel:    message.Channel,
+					Recipients: message.Recipients,
+					Body:       message.Body,
+				})
+			}
+
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(records); err != nil {
+				return 0, err
+			}
+
+		case ArchiveCSV:
+			writer := csv.NewWriter(w)
+			writer.Write([]string{"id", "sent_at", "sender", "channel", "recipients", "body"})
+			for _, message := range messages {
+				writer.Write([]string{
+					strconv.FormatInt(message.ID, 10),
+					message.SentAt.Format(time.RFC3339),
+					message.Sender,
+					message.Channel,
+					strings.Join(message.Recipients, " "),
+					message.Body,
+				})
+			}
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				return 0, err
+			}
+
+		default:
+			return 0, fmt.Errorf("unknown archive format %q", format)
+	}
+
+	return len(messages), nil
+}
+
+// handleArchiveCommand lets an admin export a channel's or user's stored messages between two dates,
+// inclusive, to a JSON or CSV file in the archive directory. The arguments are "<from> <to> [json|csv]".
+func (server *ChatServer) handleArchiveCommand(conn net.Conn, target string, archiveArgs string) {
+
+	fields := strings.Fields(archiveArgs)
+	if len(fields) < 2 {
+		sendReplyf(conn, errUsage, "Usage: %s <channel|nickname> <from YYYY-MM-DD> <to YYYY-MM-DD> [json|csv]", ARCHIVE)
+		return
+	}
+
+	from, fromErr := time.ParseInLocation(archiveDateFormat, fields[0], time.Local)
+	to, toErr := time.ParseInLocation(archiveDateFormat, fields[1], time.Local)
+	if fromErr != nil || toErr != nil || to.Before(from) {
+		sendReply(conn, errInvalidArgument, "Dates must be given as YYYY-MM-DD, with the start no later than the end")
+		return
+	}
+
+	format := ArchiveJSON
+	if len(fields) >= 3 {
+		format = strings.ToLower(fields[2])
+	}
+	if format != ArchiveJSON && format != ArchiveCSV {
+		sendReplyf(conn, errInvalidArgument, "Unknown archive format %s (use json or csv)", fields[2])
+		return
+	}
+
+	// The target is checked against the name patterns since it becomes part of the file name
+	query := MessageQuery{From: from, To: to.AddDate(0, 0, 1)}
+	name := "user-" + target
+	if isChannelName(target) {
+		if validName, msg := validateChannelName(target); !validName {
+			sendReply(conn, errInvalidChannel, msg)
+			return
+		}
+		query.Channel = target
+		name = "channel-" + strings.TrimPrefix(target, "#")
+	} else {
+		if validName, msg := validateNickname(target); !validName {
+			sendReply(conn, errInvalidNickname, msg)
+			return
+		}
+		query.Nickname = target
+	}
+
+	var archive bytes.Buffer
+	count, err := ExportArchive(server.storage, query, format, &archive)
+	if err == nil {
+		err = os.MkdirAll(server.config.ArchiveDir, 0o750)
+	}
+
+	path := filepath.Join(server.config.ArchiveDir, fmt.Sprintf("%s_%s_%s.%s", name, fields[0], fields[1], format))
+	if err == nil {
+		err = writeFileAtomic(path, archive.Bytes())
+	}
+
+	if err != nil {
+		server.clientLog(conn).Error("Failed to export archive", "target", target, "err", err)
+		sendReplyf(conn, errUnavailable, "Failed to export the archive of %s", target)
+		return
+	}
+
+	server.clientLog(conn).Info("Exported archive", "target", target, "count", count, "path", path)
+
+	sendReplyf(conn, rplArchived, "Exported %d messages of %s to %s", count, target, path)
+}
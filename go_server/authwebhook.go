@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// authWebhookRequest is the body POSTed to the auth webhook for each login attempt.
+type authWebhookRequest struct {
+	Nickname   string `json:"nickname"`
+	Credential string `json:"credential"`
+}
+
+// authWebhookResponse is the webhook's decision on a login attempt. The role is optional and defaults to user.
+type authWebhookResponse struct {
+	Allow  bool   `json:"allow"`
+	Role   string `json:"role,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// webhookAccountStore is an AccountStore that lets an external HTTP endpoint decide logins, so operators
+// can use an existing user database. A 404 response means the webhook doesn't know the nickname,
+// and the login falls back to the wrapped store; every other operation is handled by the wrapped store.
+type webhookAccountStore struct {
+	AccountStore
+
+	url        string
+	secret     string
+	httpClient *http.Client
+
+	mutex    sync.Mutex         // mutex protects access to external
+	external map[string]Account // external maps nicknames the webhook allowed to their accounts
+}
+
+// newWebhookAccountStore creates an account store that checks logins with the webhook at url before falling back to store.
+func newWebhookAccountStore(store AccountStore, url string, secret string, timeout time.Duration) *webhookAccountStore {
+
+	return &webhookAccountStore{
+		AccountStore: store,
+		url:          url,
+		secret:       secret,
+		httpClient:   &http.Client{Timeout: timeout},
+		external:     make(map[string]Account),
+	}
+}
+
+// Authenticate implements AccountStore by asking the webhook, remembering the role it grants.
+func (store *webhookAccountStore) Authenticate(nickname string, password string) error {
+
+	decision, known, err := store.ask(nickname, password)
+	if err != nil {
+		slog.Error("Auth webhook failed", "nickname", nickname, "err", err)
+		return ErrAuthUnavailable
+	}
+	if !known {
+		return store.AccountStore.Authenticate(nickname, password)
+	}
+
+	if !decision.Allow {
+		if decision.Reason != "" {
+			slog.Info("Auth webhook denied login", "nickname", nickname, "reason", decision.Reason)
+		}
+		return ErrWrongPassword
+	}
+
+	role := RoleUser
+	if decision.Role != "" {
+		parsed, valid := parseRole(decision.Role)
+		if !valid {
+			slog.Warn("Auth webhook returned an unknown role", "nickname", nickname, "role", decision.Role)
+			return ErrAuthUnavailable
+		}
+		role = parsed
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	account, exists := store.external[nickname]
+	if !exists {
+		account = Account{Nickname: nickname, RegisteredAt: time.Now()}
+	}
+	account.Role = role
+	store.external[nickname] = account
+	return nil
+}
+
+// ask POSTs a login attempt to the webhook, reporting whether the webhook knows the nickname.
+func (store *webhookAccountStore) ask(nickname string, credential string) (authWebhookResponse, bool, error) {
+
+	var decision authWebhookResponse
+
+	body, err := json.Marshal(authWebhookRequest{Nickname: nickname, Credential: credential})
+	if err != nil {
+		return decision, false, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, store.url, bytes.NewReader(body))
+	if err != nil {
+		return decision, false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if store.secret != "" {
+		request.Header.Set("Authorization", "Bearer "+store.secret)
+	}
+
+	response, err := store.httpClient.Do(request)
+	if err != nil {
+		return decision, false, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return decision, false, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return decision, false, fmt.Errorf("webhook returned %s", response.Status)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&decision); err != nil {
+		return decision, false, err
+	}
+	return decision, true, nil
+}
+
+// Get implements AccountStore, preferring accounts the webhook has allowed to log in.
+func (store *webhookAccountStore) Get(nickname string) (Account, bool) {
+
+	store.mutex.Lock()
+	account, exists := store.external[nickname]
+	store.mutex.Unlock()
+
+	if exists {
+		return account, true
+	}
+	return store.AccountStore.Get(nickname)
+}
+
+// SetRole implements AccountStore. Roles of webhook accounts last until the webhook next decides a login.
+func (store *webhookAccountStore) SetRole(nickname string, role Role) error {
+
+	store.mutex.Lock()
+	account, exists := store.external[nickname]
+	if exists {
+		account.Role = role
+		store.external[nickname] = account
+	}
+	store.mutex.Unlock()
+
+	if exists {
+		return nil
+	}
+	return store.AccountStore.SetRole(nickname, role)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+)
+
+// autoBannedBy is recorded as the issuer of bans the server places automatically.
+const autoBannedBy = "server"
+
+// recordFailure counts an invalid command or failed password attempt against a connection's IP, banning the IP
+// for the configured duration once too many failures occur within the window. Moderators and above are exempt,
+// as are loopback and operator host addresses, which many users may share and which must never be locked out.
+// It must run on the hub goroutine.
+func (server *ChatServer) recordFailure(conn net.Conn, reason string) {
+
+	if server.config.AutoBanThreshold <= 0 || server.roleOf(conn) >= RoleModerator {
+		return
+	}
+
+	ip := remoteIP(conn)
+	if parsed := net.ParseIP(ip); parsed.IsLoopback() || slices.Contains(server.config.OperatorHosts, ip) {
+		return
+	}
+	now := time.Now()
+
+	// Drop failures that have fallen out of the window
+	recent := server.failures[ip][:0]
+	for _, failedAt := range server.failures[ip] {
+		if now.Sub(failedAt) < server.config.AutoBanWindow {
+			recent = append(recent, failedAt)
+		}
+	}
+	recent = append(recent, now)
+
+	if len(recent) < server.config.AutoBanThreshold {
+		server.failures[ip] = recent
+		return
+	}
+
+	delete(server.failures, ip)
+
+	server.bans[ip] = ipBan{IP: ip, BannedBy: autoBannedBy, ExpiresAt: now.Add(server.config.AutoBanDuration)}
+	server.saveBans()
+
+	slog.Warn("Automatically banned address", "ip", ip, "duration", server.config.AutoBanDuration, "failures", len(recent), "reason", reason)
+	server.disconnectBannedIP(ip, autoBannedBy)
+}
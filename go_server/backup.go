@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupLatest is the -backup-restore value that restores the most recent backup.
+const backupLatest = "latest"
+
+// Names of the message databases in a backup.
+const (
+	backupSQLiteName = "messages.sqlite"
+	backupBoltName   = "messages.bolt"
+)
+
+// databaseBackup is implemented by storages that can copy their database while it is in use, for backups.
+type databaseBackup interface {
+	// copyDatabase writes a consistent copy of the database into dir, returning the copy's file name.
+	copyDatabase(dir string) (string, error)
+}
+
+// backupFiles maps the names of the files in a backup to the configured paths they are read from
+// and restored to. Files whose path is empty are not backed up.
+func backupFiles(config Config) map[string]string {
+
+	return map[string]string{
+		"accounts.json":  config.AccountsFile,
+		"channels.json":  config.ChannelsFile,
+		"seen.json":      config.SeenFile,
+		"activity.json":  config.ActivityFile,
+		"bans.json":      config.BansFile,
+		"tokens.json":    config.TokensFile,
+		"offline.json":   config.OfflineFile,
+		"snapshot.json":  config.SnapshotFile,
+		backupSQLiteName: config.MessageDB,
+		backupBoltName:   config.BoltDB,
+	}
+}
+
+// newBackupClient creates the object store client backups are uploaded to and restored from.
+func newBackupClient(config Config) (*s3Client, error) {
+
+	if config.BackupAccessKey == "" || config.BackupSecretKey == "" {
+		return nil, errors.New("backups require -backup-access-key and -backup-secret-key")
+	}
+	return newS3Client(config.BackupEndpoint, config.BackupBucket, config.BackupRegion, config.BackupAccessKey, config.BackupSecretKey)
+}
+
+// createBackup builds a gzipped tar archive of the server's state files and a copy of its message database.
+func (server *ChatServer) createBackup() ([]byte, error) {
+
+	files := make(map[string]string)
+	for name, path := range backupFiles(server.config) {
+		if path != "" && name != backupSQLiteName && name != backupBoltName {
+			files[name] = path
+		}
+	}
+
+	// The database is copied through the storage, since copying its file directly could catch it mid-write
+	if database, copyable := server.storage.(databaseBackup); copyable {
+		dir, err := os.MkdirTemp("", "chat-backup")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+
+		name, err := database.copyDatabase(dir)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = filepath.Join(dir, name)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var archive bytes.Buffer
+	compressor := gzip.NewWriter(&archive)
+	writer := tar.NewWriter(compressor)
+
+	for _, name := range names {
+		data, err := os.ReadFile(files[name])
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		header := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: time.Now()}
+		if err := writer.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+	return archive.Bytes(), nil
+}
+
+// backUp uploads a new backup and deletes the oldest backups beyond the number configured to keep.
+func (server *ChatServer) backUp() error {
+
+	archive, err := server.createBackup()
+	if err != nil {
+		return err
+	}
+
+	key := server.config.BackupPrefix + "backup-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz"
+	if err := server.backups.putObject(key, archive); err != nil {
+		return err
+	}
+	slog.Info("Uploaded backup", "key", key, "bytes", len(archive))
+
+	if server.config.BackupKeep <= 0 {
+		return nil
+	}
+
+	// Backup keys contain the time they were taken, so sorting them puts the oldest first
+	keys, err := server.backups.listObjects(server.config.BackupPrefix + "backup-")
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+
+	for len(keys) > server.config.BackupKeep {
+		if err := server.backups.deleteObject(keys[0]); err != nil {
+			return err
+		}
+		slog.Info("Deleted old backup", "key", keys[0])
+		keys = keys[1:]
+	}
+	return nil
+}
+
+// backUpPeriodically uploads a backup at the configured interval. It runs for the lifetime of the server.
+func (server *ChatServer) backUpPeriodically() {
+
+	ticker := time.NewTicker(server.config.BackupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := server.backUp(); err != nil {
+			slog.Error("Failed to back up", "err", err)
+		}
+	}
+}
+
+// restoreBackup downloads a backup, or the latest one when key is "latest", and writes each file in it
+// to the path configured for it, replacing what is there. It runs before any state is loaded.
+func restoreBackup(client *s3Client, config Config, key string) error {
+
+	if key == backupLatest {
+		keys, err := client.listObjects(config.BackupPrefix + "backup-")
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return errors.New("there are no backups to restore")
+		}
+		sort.Strings(keys)
+		key = keys[len(keys)-1]
+	}
+
+	archive, err := client.getObject(key)
+	if err != nil {
+		return err
+	}
+
+	decompressor, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	reader := tar.NewReader(decompressor)
+	paths := backupFiles(config)
+
+	var restored []string
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		path := paths[header.Name]
+		if path == "" {
+			slog.Warn("Skipping a file with nowhere configured to go", "file", header.Name, "key", key)
+			continue
+		}
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, data); err != nil {
+			return fmt.Errorf("restoring %s: %w", header.Name, err)
+		}
+		restored = append(restored, path)
+	}
+
+	slog.Info("Restored backup", "key", key, "restored", strings.Join(restored, ", "))
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+// ipBan records a remote IP barred from connecting to the server.
+type ipBan struct {
+	IP        string    `json:"ip"`
+	BannedBy  string    `json:"banned_by"`
+	ExpiresAt time.Time `json:"expires_at"` // ExpiresAt is zero for permanent bans
+}
+
+// expired reports whether a timed ban has run out.
+func (ban ipBan) expired() bool {
+	return !ban.ExpiresAt.IsZero() && time.Now().After(ban.ExpiresAt)
+}
+
+// loadBans reads the IP ban list from the configured file. A missing file is not an error.
+func (server *ChatServer) loadBans() error {
+
+	if server.config.BansFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(server.config.BansFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var bans []ipBan
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return err
+	}
+
+	server.onHub(func() {
+		for _, ban := range bans {
+			if !ban.expired() {
+				server.bans[ban.IP] = ban
+			}
+		}
+	})
+	return nil
+}
+
+// saveBans writes the IP ban list to the configured file. It must run on the hub goroutine.
+func (server *ChatServer) saveBans() {
+
+	if server.config.BansFile == "" {
+		return
+	}
+
+	bans := make([]ipBan, 0, len(server.bans))
+	for _, ban := range server.bans {
+		bans = append(bans, ban)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+
+	data, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		slog.Error("Failed to encode ban list", "err", err)
+		return
+	}
+
+	if err := writeFileAtomic(server.config.BansFile, data); err != nil {
+		slog.Error("Failed to save ban list", "err", err)
+	}
+}
+
+// isIPBanned reports whether a remote IP is currently banned, dropping the ban if it has expired.
+func (server *ChatServer) isIPBanned(ip string) bool {
+
+	banned := false
+	server.onHub(func() {
+		ban, exists := server.bans[ip]
+		if exists && ban.expired() {
+			delete(server.bans, ip)
+			server.saveBans()
+			return
+		}
+		banned = exists
+	})
+	return banned
+}
+
+// handleBanCommand lets an admin ban a nickname's IP address, or an IP address directly,
+// for an optional duration. Every connection from the banned IP is disconnected.
+// With no target it lists the current bans.
+func (server *ChatServer) handleBanCommand(conn net.Conn, target string, duration string) {
+
+	server.onHub(func() {
+		if target == "" {
+			server.listBans(conn)
+			return
+		}
+
+		ip := target
+		if net.ParseIP(target) == nil {
+			targetConn, found := server.findUserByNickname(target)
+			if !found {
+				sendReplyf(conn, errNoSuchNick, "No such user or IP address %s", target)
+				return
+			}
+			if !server.outranks(conn, targetConn, "ban") {
+				return
+			}
+			ip = remoteIP(targetConn)
+		}
+
+		ban := ipBan{IP: ip, BannedBy: server.users.nickname(conn)}
+		if duration != "" {
+			banLength, err := time.ParseDuration(duration)
+			if err != nil || banLength <= 0 {
+				sendReplyf(conn, errInvalidArgument, "Invalid ban duration %s (use a duration such as 30m or 12h)", duration)
+				return
+			}
+			ban.ExpiresAt = time.Now().Add(banLength)
+		}
+
+		server.bans[ip] = ban
+		server.saveBans()
+
+		if ban.ExpiresAt.IsZero() {
+			sendReplyf(conn, rplBan, "Banned %s permanently", ip)
+		} else {
+			sendReplyf(conn, rplBan, "Banned %s until %s", ip, ban.ExpiresAt.Format(time.RFC1123))
+		}
+		server.clientLog(conn).Info("Banned address", "ip", ip)
+
+		server.disconnectBannedIP(ip, server.users.nickname(conn))
+	})
+}
+
+// disconnectBannedIP disconnects every connection from a newly banned IP, announcing the ban
+// to everyone else. It must run on the hub goroutine.
+func (server *ChatServer) disconnectBannedIP(ip string, bannedBy string) {
+
+	for userConn := range server.clients {
+		if remoteIP(userConn) != ip {
+			continue
+		}
+
+		sendReplyf(userConn, errBanned, "You were banned by %s", bannedBy)
+		if nickname, registered := server.users.lookup(userConn); registered {
+			payload := newMessagePayload(fmt.Sprintf("%s was banned by %s", nickname, bannedBy))
+			server.users.each(func(otherConn net.Conn, _ string) bool {
+				if remoteIP(otherConn) != ip {
+					server.deliverPayload(otherConn, payload)
+				}
+				return true
+			})
+			payload.release()
+		}
+		server.disconnectClient(userConn)
+	}
+}
+
+// handleUnbanCommand lets an admin lift the ban on an IP address.
+func (server *ChatServer) handleUnbanCommand(conn net.Conn, ip string) {
+
+	server.onHub(func() {
+		if _, banned := server.bans[ip]; !banned {
+			sendReplyf(conn, errNoChange, "%s is not banned", ip)
+			return
+		}
+
+		delete(server.bans, ip)
+		server.saveBans()
+
+		sendReplyf(conn, rplBan, "Unbanned %s", ip)
+		server.clientLog(conn).Info("Unbanned address", "ip", ip)
+	})
+}
+
+// listBans sends the current, unexpired bans to a client. It must run on the hub goroutine.
+func (server *ChatServer) listBans(conn net.Conn) {
+
+	ips := make([]string, 0, len(server.bans))
+	for ip, ban := range server.bans {
+		if ban.expired() {
+			delete(server.bans, ip)
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	if len(ips) == 0 {
+		sendReply(conn, rplBanList, "No bans")
+		return
+	}
+
+	for _, ip := range ips {
+		ban := server.bans[ip]
+		if ban.ExpiresAt.IsZero() {
+			sendReplyf(conn, rplBanList, "%s banned permanently by %s", ip, ban.BannedBy)
+		} else {
+			sendReplyf(conn, rplBanList, "%s banned by %s until %s", ip, ban.BannedBy, ban.ExpiresAt.Format(time.RFC1123))
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	messageQueueSize     = 1024            // messageQueueSize is the number of messages waiting to be written before new ones are dropped
+	messageBatchSize     = 100             // messageBatchSize is the most messages written in one transaction
+	messageFlushInterval = 1 * time.Second // messageFlushInterval is how long a partial batch waits before it is written
+)
+
+// batchWriter queues messages for a database-backed Storage and writes them in batches from a background
+// goroutine, so saving a message never blocks delivery. It provides the storage's SaveMessage.
+type batchWriter struct {
+	insert  func(batch []StoredMessage) error // insert writes a batch of messages in a single transaction
+	queue   chan StoredMessage
+	flushes chan chan struct{} // flushes carries requests to write the pending batch immediately
+}
+
+// newBatchWriter creates a batch writer that writes with insert, and starts its writer goroutine.
+func newBatchWriter(insert func(batch []StoredMessage) error) *batchWriter {
+
+	writer := &batchWriter{
+		insert:  insert,
+		queue:   make(chan StoredMessage, messageQueueSize),
+		flushes: make(chan chan struct{}),
+	}
+	go writer.writeBatches()
+
+	return writer
+}
+
+// SaveMessage implements Storage by queueing the message to be written. If the writer has fallen behind
+// the message is dropped rather than slowing down the sender.
+func (writer *batchWriter) SaveMessage(message StoredMessage) error {
+
+	select {
+
+		case writer.queue <- message:
+
+		default:
+			slog.Warn("Message storage queue is full; dropped message", "sender", message.Sender)
+	}
+	return nil
+}
+
+// flush waits until every message queued so far has been written.
+func (writer *batchWriter) flush() {
+
+	done := make(chan struct{})
+	writer.flushes <- done
+	<-done
+}
+
+// writeBatches writes queued messages whenever a full batch is ready, the flush interval passes,
+// or a flush is requested. It runs for the lifetime of the server.
+func (writer *batchWriter) writeBatches() {
+
+	ticker := time.NewTicker(messageFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]StoredMessage, 0, messageBatchSize)
+	var done chan struct{}
+
+	for {
+		select {
+
+			case message := <-writer.queue:
+				batch = append(batch, message)
+				if len(batch) < messageBatchSize {
+					continue
+				}
+
+			case <-ticker.C:
+				if len(batch) == 0 {
+					continue
+				}
+
+			case done = <-writer.flushes:
+				// Take everything queued before the flush was requested
+				for len(writer.queue) > 0 {
+					batch = append(batch, <-writer.queue)
+				}
+		}
+
+		if len(batch) > 0 {
+			if err := writer.insert(batch); err != nil {
+				slog.Error("Failed to save messages", "count", len(batch), "err", err)
+			}
+			batch = batch[:0]
+		}
+
+		if done != nil {
+			close(done)
+			done = nil
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Buckets of the Bolt database. Messages are keyed by their big-endian ID, so they are iterated in the
+// order they were sent; users, channels, and accounts are keyed by name. Every value is JSON.
+var (
+	boltMessages = []byte("messages")
+	boltUsers    = []byte("users")
+	boltChannels = []byte("channels")
+	boltAccounts = []byte("accounts")
+)
+
+// boltStorage is a Storage kept in a single Bolt file, written in pure Go. Besides messages and user records
+// it keeps accounts and registered channels, so a deployment needs nothing but the binary and the database.
+// Messages are queued and written in batches like the SQLite storage.
+type boltStorage struct {
+	*batchWriter
+	db *bolt.DB
+}
+
+// openBoltStorage opens, creating if necessary, the Bolt database at path and starts its message writer.
+func openBoltStorage(path string) (*boltStorage, error) {
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltMessages, boltUsers, boltChannels, boltAccounts} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	storage := &boltStorage{db: db}
+	storage.batchWriter = newBatchWriter(storage.insert)
+
+	return storage, nil
+}
+
+// messageKey returns the key of the message with an ID.
+func messageKey(id int64) []byte {
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// insert writes a batch of messages in a single transaction.
+func (storage *boltStorage) insert(batch []StoredMessage) error {
+
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltMessages)
+		for _, message := range batch {
+			if message.ID == 0 {
+				id, err := bucket.NextSequence()
+				if err != nil {
+					return err
+				}
+				message.ID = int64(id)
+			} else if uint64(message.ID) > bucket.Sequence() {
+				if err := bucket.SetSequence(uint64(message.ID)); err != nil {
+					return err
+				}
+			}
+
+			data, err := json.Marshal(message)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(messageKey(message.ID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LastMessageID implements Storage. Messages are written asynchronously, so pending messages are written first.
+func (storage *boltStorage) LastMessageID() (int64, error) {
+
+	storage.flush()
+
+	var id int64
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		id = int64(tx.Bucket(boltMessages).Sequence())
+		return nil
+	})
+	return id, err
+}
+
+// LoadHistory implements Storage.
+func (storage *boltStorage) LoadHistory(channel string, beforeID int64, limit int) ([]StoredMessage, error) {
+
+	return storage.find(beforeID, limit, func(message StoredMessage) bool {
+		return message.Channel == channel
+	})
+}
+
+// LoadConversation implements Storage.
+func (storage *boltStorage) LoadConversation(nickname string, other string, beforeID int64, limit int) ([]StoredMessage, error) {
+
+	return storage.find(beforeID, limit, func(message StoredMessage) bool {
+		return message.Channel == "" && isConversation(message, nickname, other)
+	})
+}
+
+// SearchMessages implements Storage, matching terms as substrings.
+func (storage *boltStorage) SearchMessages(channel string, terms []string, beforeID int64, limit int) ([]StoredMessage, error) {
+
+	return storage.find(beforeID, limit, func(message StoredMessage) bool {
+		return message.Channel == channel && containsTerms(message.Body, terms)
+	})
+}
+
+// LoadMessages implements Storage.
+func (storage *boltStorage) LoadMessages(query MessageQuery) ([]StoredMessage, error) {
+
+	return storage.find(0, math.MaxInt, query.matches)
+}
+
+// find returns up to limit of the latest messages with IDs below beforeID that match, oldest first.
+// Queued messages are written first so that they are included.
+func (storage *boltStorage) find(beforeID int64, limit int, matches func(StoredMessage) bool) ([]StoredMessage, error) {
+
+	storage.flush()
+
+	var found []StoredMessage
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltMessages).Cursor()
+
+		// Seeking lands on beforeID itself, or the first message after it, so the scan starts one before
+		key, value := cursor.Last()
+		if beforeID != 0 {
+			if key, _ = cursor.Seek(messageKey(beforeID)); key == nil {
+				key, value = cursor.Last()
+			} else {
+				key, value = cursor.Prev()
+			}
+		}
+
+		for ; key != nil && len(found) < limit; key, value = cursor.Prev() {
+			var message StoredMessage
+			if err := json.Unmarshal(value, &message); err != nil {
+				return err
+			}
+			if matches(message) {
+				found = append(found, message)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(found)
+	return found, nil
+}
+
+// PruneMessages implements Storage.
+func (storage *boltStorage) PruneMessages(retention Retention) (int, error) {
+
+	storage.flush()
+
+	deleted := 0
+	err := storage.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltMessages)
+
+		// Messages are counted from the newest, so each channel's latest messages are the ones kept
+		var expired [][]byte
+		newer := make(map[string]int)
+		cursor := bucket.Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			var message StoredMessage
+			if err := json.Unmarshal(value, &message); err != nil {
+				return err
+			}
+			if retention.expired(message, newer[message.Channel]) {
+				expired = append(expired, key)
+			}
+			newer[message.Channel]++
+		}
+
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		deleted = len(expired)
+		return nil
+	})
+	return deleted, err
+}
+
+// SaveUser implements Storage.
+func (storage *boltStorage) SaveUser(user UserRecord) error {
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUsers).Put([]byte(user.Nickname), data)
+	})
+}
+
+// LoadUsers implements Storage.
+func (storage *boltStorage) LoadUsers() ([]UserRecord, error) {
+
+	var users []UserRecord
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUsers).ForEach(func(key []byte, value []byte) error {
+			var user UserRecord
+			if err := json.Unmarshal(value, &user); err != nil {
+				return err
+			}
+			user.Nickname = string(key)
+			users = append(users, user)
+			return nil
+		})
+	})
+	return users, err
+}
+
+// SaveChannels implements ChannelStore.
+func (storage *boltStorage) SaveChannels(records []ChannelRecord) error {
+
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltChannels); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucket(boltChannels)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(record.Name), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LoadChannels implements ChannelStore.
+func (storage *boltStorage) LoadChannels() ([]ChannelRecord, error) {
+
+	var records []ChannelRecord
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltChannels).ForEach(func(_ []byte, value []byte) error {
+			var record ChannelRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	return records, err
+}
+
+// loadAccount reads the account record of a nickname within a transaction, returning nil if there is none.
+func loadAccount(tx *bolt.Tx, nickname string) (*accountRecord, error) {
+
+	data := tx.Bucket(boltAccounts).Get([]byte(nickname))
+	if data == nil {
+		return nil, nil
+	}
+
+	var record accountRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// saveAccount writes an account record within a transaction.
+func saveAccount(tx *bolt.Tx, record *accountRecord) error {
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltAccounts).Put([]byte(record.Nickname), data)
+}
+
+// updateAccount applies a change to an existing account in a single transaction.
+func (storage *boltStorage) updateAccount(nickname string, change func(record *accountRecord)) error {
+
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		record, err := loadAccount(tx, nickname)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return ErrNoSuchAccount
+		}
+
+		change(record)
+		return saveAccount(tx, record)
+	})
+}
+
+// Create implements AccountStore.
+func (storage *boltStorage) Create(nickname string, password string) error {
+
+	// Hashing is deliberately slow, so it is done outside the transaction
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltAccounts).Get([]byte(nickname)) != nil {
+			return ErrAccountExists
+		}
+		return saveAccount(tx, &accountRecord{Nickname: nickname, PasswordHash: string(hash), RegisteredAt: time.Now()})
+	})
+}
+
+// Authenticate implements AccountStore.
+func (storage *boltStorage) Authenticate(nickname string, password string) error {
+
+	var record *accountRecord
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = loadAccount(tx, nickname)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if record == nil {
+		return ErrNoSuchAccount
+	}
+	if bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// Get implements AccountStore.
+func (storage *boltStorage) Get(nickname string) (Account, bool) {
+
+	var record *accountRecord
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = loadAccount(tx, nickname)
+		return err
+	})
+	if err != nil || record == nil {
+		return Account{}, false
+	}
+	return record.account(), true
+}
+
+// Delete implements AccountStore.
+func (storage *boltStorage) Delete(nickname string) error {
+
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAccounts)
+		if bucket.Get([]byte(nickname)) == nil {
+			return ErrNoSuchAccount
+		}
+		return bucket.Delete([]byte(nickname))
+	})
+}
+
+// SetRole implements AccountStore.
+func (storage *boltStorage) SetRole(nickname string, role Role) error {
+
+	return storage.updateAccount(nickname, func(record *accountRecord) {
+		record.Role = role.String()
+	})
+}
+
+// SetCertFingerprints implements AccountStore. A certificate identifies only one account,
+// so binding it here removes it from any other account.
+func (storage *boltStorage) SetCertFingerprints(nickname string, fingerprints []string) error {
+
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		record, err := loadAccount(tx, nickname)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return ErrNoSuchAccount
+		}
+
+		// Accounts can't be written while iterating over them, so the ones to change are collected first
+		var others []*accountRecord
+		err = tx.Bucket(boltAccounts).ForEach(func(key []byte, value []byte) error {
+			var other accountRecord
+			if err := json.Unmarshal(value, &other); err != nil {
+				return err
+			}
+			if other.Nickname != nickname && slices.ContainsFunc(other.CertFingerprints, func(bound string) bool {
+				return slices.Contains(fingerprints, bound)
+			}) {
+				others = append(others, &other)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, other := range others {
+			other.CertFingerprints = slices.DeleteFunc(other.CertFingerprints, func(bound string) bool {
+				return slices.Contains(fingerprints, bound)
+			})
+			if err := saveAccount(tx, other); err != nil {
+				return err
+			}
+		}
+
+		record.CertFingerprints = slices.Clone(fingerprints)
+		return saveAccount(tx, record)
+	})
+}
+
+// FindByCertFingerprint implements AccountStore.
+func (storage *boltStorage) FindByCertFingerprint(fingerprint string) (Account, bool) {
+
+	var found *accountRecord
+	storage.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltAccounts).ForEach(func(_ []byte, value []byte) error {
+			var record accountRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if found == nil && slices.Contains(record.CertFingerprints, fingerprint) {
+				found = &record
+			}
+			return nil
+		})
+	})
+
+	if found == nil {
+		return Account{}, false
+	}
+	return found.account(), true
+}
+
+// copyDatabase implements databaseBackup.
+func (storage *boltStorage) copyDatabase(dir string) (string, error) {
+
+	storage.flush()
+
+	return backupBoltName, storage.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(filepath.Join(dir, backupBoltName), 0o600)
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// Capabilities are optional protocol features a client enables for its connection with /CAP REQ, so the protocol
+// can grow without changing what existing clients receive.
+const (
+	capServerTime = "server-time" // capServerTime prefixes delivered messages with an @time= tag holding when they were sent
+	capJSON       = "json"        // capJSON switches a text connection to the JSON wire format
+	capMessageIDs = "message-ids" // capMessageIDs acknowledges each message sent with its ID, or refuses it with a NACK code
+	capTyping     = "typing"      // capTyping delivers the typing notifications other users send with /TYPING
+	capSequence   = "seq"         // capSequence prefixes delivered messages with an @seq= tag holding their sequence number, for /RESUME
+	capDeflate    = "deflate"     // capDeflate compresses the stream in both directions with raw DEFLATE, flushed after each write
+)
+
+// capabilities lists every capability, in the order /CAP LS reports them.
+var capabilities = []string{capServerTime, capJSON, capMessageIDs, capTyping, capSequence, capDeflate}
+
+// serverTimeFormat is the layout of @time= tags: UTC with millisecond precision.
+const serverTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// Typing states a client can send with /TYPING.
+var typingStates = []string{"active", "paused", "done"}
+
+// hasCapability reports whether a client's connection has a capability enabled.
+func hasCapability(conn net.Conn, capability string) bool {
+
+	wire, isWire := conn.(*wireConn)
+	if !isWire {
+		return false
+	}
+
+	wire.writeMutex.Lock()
+	defer wire.writeMutex.Unlock()
+
+	return wire.capabilities[capability]
+}
+
+// availableCapabilities returns the capabilities a connection may enable. JSON mode is only offered to connections
+// still using the text protocol over a plain stream, since gRPC sessions structure every line already. Compression
+// is only offered over plain streams, since gRPC compresses by itself and WebSocket clients negotiate permessage-deflate.
+func availableCapabilities(conn net.Conn) []string {
+
+	wire, isWire := conn.(*wireConn)
+	if !isWire {
+		return nil
+	}
+
+	wire.writeMutex.Lock()
+	defer wire.writeMutex.Unlock()
+
+	_, isGRPC := wire.Conn.(*grpcConn)
+	_, isWebSocket := wire.Conn.(*websocketConn)
+
+	available := make([]string, 0, len(capabilities))
+	for _, capability := range capabilities {
+		switch {
+
+			case capability == capJSON && (isGRPC || wire.format != textFormat) && !wire.capabilities[capJSON]:
+				continue
+
+			case capability == capDeflate && (isGRPC || isWebSocket):
+				continue
+		}
+		available = append(available, capability)
+	}
+	return available
+}
+
+// handleCapCommand lists and enables capabilities. /CAP LS lists the capabilities the connection may enable,
+// /CAP LIST those it has enabled, and /CAP REQ enables, or with a leading '-' disables, each capability named.
+// A request is granted with CAP ACK only if every change in it can be made; otherwise none is, and it is refused with CAP NAK.
+func (server *ChatServer) handleCapCommand(conn net.Conn, arguments string) {
+
+	subcommand, requested, _ := strings.Cut(strings.TrimSpace(arguments), " ")
+	wire, isWire := conn.(*wireConn)
+
+	switch strings.ToUpper(subcommand) {
+
+		case "LS":
+			fmt.Fprintf(conn, "CAP LS %s\n", strings.Join(availableCapabilities(conn), " "))
+
+		case "LIST":
+			var enabled []string
+			for _, capability := range capabilities {
+				if hasCapability(conn, capability) {
+					enabled = append(enabled, capability)
+				}
+			}
+			fmt.Fprintln(conn, strings.TrimSpace("CAP LIST "+strings.Join(enabled, " ")))
+
+		case "REQ":
+			changes := strings.Fields(requested)
+			available := availableCapabilities(conn)
+
+			valid := isWire && len(changes) > 0
+			for _, change := range changes {
+				capability, disable := strings.CutPrefix(change, "-")
+
+				// Once the connection has switched to JSON or started compressing it can't switch back
+				if !slices.Contains(available, capability) || (disable && (capability == capJSON || capability == capDeflate)) {
+					valid = false
+				}
+			}
+			if !valid {
+				fmt.Fprintf(conn, "CAP NAK %s\n", requested)
+				return
+			}
+
+			// The acknowledgment is the last line sent in the old format, so the client knows where JSON or compression begins
+			fmt.Fprintf(conn, "CAP ACK %s\n", requested)
+			wire.setCapabilities(changes)
+
+		default:
+			sendReply(conn, errUsage, "Usage: /CAP LS, /CAP LIST, or /CAP REQ <capability> [-<capability>...]")
+	}
+}
+
+// messageTagged reports whether a client's capabilities ask for tags on the messages delivered to it.
+func messageTagged(conn net.Conn) bool {
+
+	return hasCapability(conn, capSequence) || hasCapability(conn, capServerTime)
+}
+
+// tagMessage returns the line delivering a message to a client, prefixed with the tags its capabilities ask for:
+// @seq=<sequence number>;time=<when it was sent>, or either tag alone.
+func tagMessage(conn net.Conn, message sequencedMessage) string {
+
+	var tags []string
+	if hasCapability(conn, capSequence) {
+		tags = append(tags, fmt.Sprintf("seq=%d", message.seq))
+	}
+	if hasCapability(conn, capServerTime) {
+		tags = append(tags, "time="+message.sentAt.UTC().Format(serverTimeFormat))
+	}
+
+	if len(tags) == 0 {
+		return message.text
+	}
+	return "@" + strings.Join(tags, ";") + " " + message.text
+}
+
+// handleTypingCommand relays a typing notification to the members of channels or to users, given comma-separated,
+// for those receiving them with the typing capability. Notifications are not stored, acknowledged, or sent back to the sender.
+func (server *ChatServer) handleTypingCommand(conn net.Conn, targets string, state string) {
+
+	if state == "" {
+		state = "active"
+	}
+	if !slices.Contains(typingStates, state) {
+		sendReplyf(conn, errInvalidArgument, "Typing state must be one of %s", strings.Join(typingStates, ", "))
+		return
+	}
+
+	server.onHub(func() {
+		senderNickname := server.users.nickname(conn)
+		if senderNickname == "" || !server.hasScope(conn, ScopeSend) {
+			return
+		}
+
+		for _, target := range strings.Split(targets, ",") {
+			var receivers []net.Conn
+			if isChannelName(target) {
+				channel, exists := server.channels[target]
+				if !exists || !channel.isMember(conn) {
+					sendReplyf(conn, errNotInChannel, "You're not in %s", target)
+					continue
+				}
+				for member := range channel.members {
+					receivers = append(receivers, member)
+				}
+			} else {
+				receivers = server.users.connections(target)
+			}
+
+			for _, receiver := range receivers {
+				if hasCapability(receiver, capTyping) && server.canDeliver(conn, receiver) {
+					fmt.Fprintf(receiver, "TYPING %s %s %s\n", senderNickname, target, state)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import "time"
+
+// Policies for a new connection arriving once the server holds -max-conns, chosen with the -max-conns-policy flag.
+const (
+	FullPolicyRefuse = "refuse" // FullPolicyRefuse refuses the new connection
+	FullPolicyEvict  = "evict"  // FullPolicyEvict disconnects the longest-idle unauthenticated connection to make room for it
+)
+
+// admission is the outcome of admitting a new connection.
+type admission int
+
+const (
+	admitted          admission = iota // admitted connections are served
+	refusedForIP                       // refusedForIP connections come from an address holding its maximum number of connections
+	refusedServerFull                  // refusedServerFull connections arrived with the server holding its maximum number of connections
+)
+
+// isAuthenticated reports whether a client has proved who it is: by logging in to an account, by single
+// sign-on, by a bot token, or by becoming a server operator.
+func (client *Client) isAuthenticated() bool {
+
+	return client.account != "" || client.ssoIdentity != "" || client.scopes != nil || client.operator
+}
+
+// evictIdleConnection disconnects the unauthenticated client that has gone longest without sending a command,
+// to make room for a new connection. It reports whether there was one to evict. It must run on the hub goroutine.
+func (server *ChatServer) evictIdleConnection() bool {
+
+	var idlest *Client
+	for _, client := range server.clients {
+		// A client already closing is making room already, so it doesn't count again
+		if client.detached || client.isAuthenticated() || client.lifecycle.ctx.Err() != nil {
+			continue
+		}
+		if idlest == nil || client.lastActive.Before(idlest.lastActive) {
+			idlest = client
+		}
+	}
+	if idlest == nil {
+		return false
+	}
+
+	server.clientLog(idlest.conn).Info("Evicting idle connection to make room for a new one", "idle", time.Since(idlest.lastActive).Round(time.Second))
+	sendReply(idlest.conn, errServerFull, "Disconnected to make room for other connections: the server is full")
+	idlest.lifecycle.cancel()
+	return true
+}
+
+// admitsMoreConnections reports whether the server may take another connection under its connection cap,
+// evicting an idle connection to make room if the configuration says to. It must run on the hub goroutine.
+func (server *ChatServer) admitsMoreConnections() bool {
+
+	if server.config.MaxConns <= 0 || server.openConnections < server.config.MaxConns {
+		return true
+	}
+	return server.config.FullPolicy == FullPolicyEvict && server.evictIdleConnection()
+}
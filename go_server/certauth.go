@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tlsHandshakeTimeout is how long a TLS client has to complete its handshake; one that stalls is disconnected
+// when it runs out, as the handshake closes the connection.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// clientCertFingerprint returns the hex-encoded SHA-256 fingerprint of the certificate a TLS client
+// presented, or an empty string for plaintext connections and clients without a certificate.
+func clientCertFingerprint(conn net.Conn) string {
+
+	if wire, isWire := conn.(*wireConn); isWire {
+		conn = wire.Conn
+	}
+
+	// WebSocket clients connecting over wss:// present their certificate on the underlying connection
+	if websocket, isWebSocket := conn.(*websocketConn); isWebSocket {
+		conn = websocket.Conn
+	}
+
+	var certificates []*x509.Certificate
+	switch typed := conn.(type) {
+
+		case *tls.Conn:
+			ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+			defer cancel()
+			if err := typed.HandshakeContext(ctx); err != nil {
+				return ""
+			}
+			certificates = typed.ConnectionState().PeerCertificates
+
+		// The QUIC handshake is complete by the time a client's stream is accepted
+		case *quicConn:
+			certificates = typed.connection.ConnectionState().TLS.PeerCertificates
+	}
+
+	if len(certificates) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256(certificates[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// identifyByCertificate logs a newly connected client in to the account bound to its TLS client
+// certificate, if any, and gives it the account's nickname when that is free.
+func (server *ChatServer) identifyByCertificate(conn net.Conn) {
+
+	fingerprint := clientCertFingerprint(conn)
+	if fingerprint == "" {
+		return
+	}
+
+	account, bound := server.accounts.FindByCertFingerprint(fingerprint)
+	if !bound {
+		return
+	}
+	nickname := account.Nickname
+	claim := server.claimNickname(nickname)
+
+	server.onHubWithClaim(claim, func() {
+		server.clients[conn].account = nickname
+		server.clientLog(conn).Info("Identified by client certificate", "account", nickname)
+		sendReplyf(conn, rplLoggedIn, "You were identified as %s by your client certificate", nickname)
+		server.deliverOfflineMessages(conn, nickname)
+
+		holder, inUse := server.findUserByNickname(nickname)
+		if !inUse {
+			server.setNickname(conn, claim)
+		} else if server.clients[holder].account == nickname {
+			server.attachDevice(conn, holder)
+		}
+	})
+}
+
+// handleCertCommand manages the client certificates bound to the user's account with /CERT ADD,
+// which binds the certificate of the current connection, /CERT DEL <fingerprint>, and /CERT LIST.
+func (server *ChatServer) handleCertCommand(conn net.Conn, certArgs string) {
+
+	// The fingerprint is read before locking since it may need to complete the TLS handshake
+	fingerprint := clientCertFingerprint(conn)
+
+	server.onHub(func() {
+		client, exists := server.clients[conn]
+		if !exists {
+			return
+		}
+
+		account, loggedIn := server.accounts.Get(client.account)
+		if !loggedIn {
+			sendReplyf(conn, errNotLoggedIn, "You must log in with %s before managing certificates", LOGIN)
+			return
+		}
+
+		fields := strings.Fields(certArgs)
+
+		switch {
+
+			case len(fields) == 1 && fields[0] == "ADD":
+				if fingerprint == "" {
+					sendReply(conn, errNoCertificate, "You are not connected with a TLS client certificate")
+					return
+				}
+				if slices.Contains(account.CertFingerprints, fingerprint) {
+					sendReplyf(conn, errAlreadyExists, "Certificate %s is already bound to %s", fingerprint, account.Nickname)
+					return
+				}
+
+				if err := server.accounts.SetCertFingerprints(account.Nickname, append(account.CertFingerprints, fingerprint)); err != nil {
+					slog.Error("Failed to bind certificate", "account", account.Nickname, "err", err)
+					sendReply(conn, errUnavailable, "Failed to bind certificate")
+					return
+				}
+
+				sendReplyf(conn, rplCertificate, "Bound certificate %s to %s", fingerprint, account.Nickname)
+				slog.Info("Bound certificate", "account", account.Nickname, "fingerprint", fingerprint)
+
+			case len(fields) == 2 && fields[0] == "DEL":
+				remaining := slices.DeleteFunc(account.CertFingerprints, func(bound string) bool { return strings.EqualFold(bound, fields[1]) })
+				if len(remaining) == len(account.CertFingerprints) {
+					sendReplyf(conn, errNoChange, "Certificate %s is not bound to %s", fields[1], account.Nickname)
+					return
+				}
+				if err := server.accounts.SetCertFingerprints(account.Nickname, remaining); err != nil {
+					slog.Error("Failed to unbind certificate", "account", account.Nickname, "err", err)
+					sendReply(conn, errUnavailable, "Failed to unbind certificate")
+					return
+				}
+
+				sendReplyf(conn, rplCertificate, "Unbound certificate %s from %s", fields[1], account.Nickname)
+
+			case len(fields) == 1 && fields[0] == "LIST":
+				if len(account.CertFingerprints) == 0 {
+					sendReplyf(conn, rplCertificateList, "No certificates are bound to %s", account.Nickname)
+					return
+				}
+				for _, bound := range account.CertFingerprints {
+					sendReply(conn, rplCertificateList, bound)
+				}
+
+			default:
+				sendReplyf(conn, errUsage, "Usage: %s ADD | %s DEL <fingerprint> | %s LIST", CERT, CERT, CERT)
+		}
+	})
+}
@@ -0,0 +1,846 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChannelRole represents the privileges a member holds within a channel
+type ChannelRole int
+
+const (
+	ChannelMember ChannelRole = iota
+	ChannelVoiced
+	ChannelOperator
+)
+
+// prefix returns the symbol shown before a member's nickname to indicate their role.
+func (role ChannelRole) prefix() string {
+
+	switch role {
+
+		case ChannelOperator:
+			return "@"
+
+		case ChannelVoiced:
+			return "+"
+
+		default:
+			return ""
+	}
+}
+
+// Channel represents a named chat room that users can join to exchange messages scoped to its members.
+type Channel struct {
+	name       string                   // name is the channel's name, including the leading '#'
+	topic      string                   // topic is the channel's current topic, empty if none has been set
+	members    map[net.Conn]ChannelRole // members maps the connections that have joined the channel to their roles
+	inviteOnly bool                     // inviteOnly restricts joining to invited nicknames (mode +i)
+	key        string                   // key is the password required to join the channel (mode +k), empty if none
+	limit      int                      // limit caps the number of members (mode +l); 0 means unlimited
+	moderated  bool                     // moderated restricts speaking to operators and voiced members (mode +m)
+	slowMode   time.Duration            // slowMode is the minimum time between a member's messages (mode +S); 0 disables it
+	lastSpoke  map[string]time.Time     // lastSpoke records when each nickname last spoke in the channel, for slow mode
+	invited    map[string]bool          // invited is the set of nicknames admitted to an invite-only channel
+	bans       map[string]bool          // bans is the set of nickname or IP masks barred from joining
+	knocks     map[string]time.Time     // knocks records when each nickname last knocked on the channel
+
+	history []channelMessage // history holds the channel's most recent messages, oldest first
+
+	registered        bool             // registered channels are persisted to disk and survive restarts (mode +r)
+	operatorNicknames map[string]bool  // operatorNicknames is the persisted operator list of a registered channel
+	retention         *RetentionPolicy // retention replaces the server's message retention for a registered channel (mode +R); nil uses the default
+}
+
+// channelMessage is a message sent to a channel, kept for replay to users who join later.
+type channelMessage struct {
+	sentAt time.Time // sentAt is when the message was sent
+	text   string    // text is the message as delivered to channel members
+}
+
+// Channel names start with '#' followed by letters, numbers, underscores, or dashes
+var validChannelPattern = regexp.MustCompile("^#[a-zA-Z0-9_-]+$")
+
+// newChannel creates an empty channel with the given name.
+func newChannel(name string) *Channel {
+
+	return &Channel{
+		name:              name,
+		members:           make(map[net.Conn]ChannelRole),
+		invited:           make(map[string]bool),
+		bans:              make(map[string]bool),
+		knocks:            make(map[string]time.Time),
+		lastSpoke:         make(map[string]time.Time),
+		operatorNicknames: make(map[string]bool),
+	}
+}
+
+// isMember reports whether a connection has joined the channel.
+func (channel *Channel) isMember(conn net.Conn) bool {
+
+	_, joined := channel.members[conn]
+	return joined
+}
+
+// isBanned reports whether a nickname or remote IP matches any of the channel's ban masks.
+func (channel *Channel) isBanned(nickname string, ip string) bool {
+
+	for mask := range channel.bans {
+		if globMatch(mask, nickname) || globMatch(mask, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordKnock records that a nickname knocked on the channel now, forgetting the knocks made longer ago than
+// interval, which no longer limit anyone.
+func (channel *Channel) recordKnock(nickname string, interval time.Duration) {
+
+	now := time.Now()
+	for knocker, knockedAt := range channel.knocks {
+		if now.Sub(knockedAt) >= interval {
+			delete(channel.knocks, knocker)
+		}
+	}
+	channel.knocks[nickname] = now
+}
+
+// globMatch reports whether value matches a shell-style mask such as "ali*" or "192.168.1.*".
+// Matching is case-insensitive, and malformed masks match nothing.
+func globMatch(mask string, value string) bool {
+
+	matched, err := path.Match(strings.ToLower(mask), strings.ToLower(value))
+	return err == nil && matched
+}
+
+// isOperator reports whether a connection holds operator privileges in the channel.
+func (channel *Channel) isOperator(conn net.Conn) bool {
+
+	role, joined := channel.members[conn]
+	return joined && role == ChannelOperator
+}
+
+// validateChannelName checks that a channel name starts with '#', is 2-20 characters long,
+// and contains only letters, numbers, underscores, and dashes.
+func validateChannelName(name string) (bool, string) {
+
+	if len(name) < 2 || len(name) > 20 {
+		return false, "Channel name must be between 2 and 20 characters"
+	}
+
+	if !validChannelPattern.MatchString(name) {
+		return false, "Channel name must start with # and contain only letters, numbers, underscores, and dashes"
+	}
+
+	return true, ""
+}
+
+// isChannelName reports whether a message recipient refers to a channel rather than a user.
+func isChannelName(recipient string) bool {
+	return strings.HasPrefix(recipient, "#")
+}
+
+// handleJoinCommand adds the client to the named channel, creating the channel if it doesn't exist,
+// and notifies the channel's existing members. A key given when creating a channel becomes its key;
+// joining an existing keyed channel requires the matching key.
+func (server *ChatServer) handleJoinCommand(conn net.Conn, channelName string, key string) {
+
+	validName, msg := validateChannelName(channelName)
+	if !validName {
+		sendReply(conn, errInvalidChannel, msg)
+		return
+	}
+
+	server.onHub(func() {
+		if _, registered := server.users.lookup(conn); !registered {
+			sendReply(conn, errNotRegistered, "You must register a nickname before you can join a channel")
+			return
+		}
+
+		server.joinChannel(conn, channelName, key)
+	})
+}
+
+// joinChannel adds a registered user to a channel, creating it if needed, after checking the channel's
+// invite-only and key restrictions. It must run on the hub goroutine.
+func (server *ChatServer) joinChannel(conn net.Conn, channelName string, key string) {
+
+	nickname := server.users.nickname(conn)
+
+	channel, exists := server.channels[channelName]
+	if !exists {
+		channel = newChannel(channelName)
+		channel.key = key
+		channel.limit = server.config.ChannelLimit
+		server.channels[channelName] = channel
+	}
+
+	if channel.isMember(conn) {
+		sendReplyf(conn, errAlreadyInChannel, "You're already in %s", channelName)
+		return
+	}
+
+	if channel.isBanned(nickname, remoteIP(conn)) {
+		sendReplyf(conn, errBannedFromChannel, "Cannot join %s: you are banned", channelName)
+		return
+	}
+	if channel.inviteOnly && !channel.invited[nickname] {
+		sendReplyf(conn, errInviteOnly, "Cannot join %s: channel is invite-only (use /KNOCK to ask for an invite)", channelName)
+		return
+	}
+	if channel.key != "" && channel.key != key {
+		sendReplyf(conn, errBadChannelKey, "Cannot join %s: wrong or missing channel key", channelName)
+		return
+	}
+	if channel.limit > 0 && len(channel.members) >= channel.limit {
+		sendReplyf(conn, errChannelFull, "Cannot join %s: channel is full (limit %d members)", channelName, channel.limit)
+		return
+	}
+
+	delete(channel.invited, nickname)
+
+	payload := newMessagePayload(fmt.Sprintf("%s joined %s", nickname, channelName))
+	defer payload.release()
+	for member := range channel.members {
+		server.deliverPayload(member, payload)
+	}
+
+	// Registered operators regain their status on join; otherwise the first user to join
+	// an empty channel without registered operators becomes its operator
+	if channel.operatorNicknames[nickname] || (len(channel.members) == 0 && len(channel.operatorNicknames) == 0) {
+		server.setMemberRole(channel, conn, ChannelOperator)
+	} else {
+		server.setMemberRole(channel, conn, ChannelMember)
+	}
+	server.joinPresence(channelName, nickname)
+	sendReplyf(conn, rplJoined, "You joined %s", channelName)
+
+	if channel.topic != "" {
+		sendReplyf(conn, rplTopic, "Topic for %s: %s", channelName, channel.topic)
+	}
+
+	for _, entry := range channel.history {
+		sendReplyf(conn, rplBacklog, "[%s] %s", entry.sentAt.Format("15:04:05"), entry.text)
+	}
+}
+
+// handlePartCommand removes the client from the named channel and notifies the remaining members.
+func (server *ChatServer) handlePartCommand(conn net.Conn, channelName string) {
+
+	server.onHub(func() {
+		channel, exists := server.channels[channelName]
+		if !exists || !channel.isMember(conn) {
+			sendReplyf(conn, errNotInChannel, "You're not in %s", channelName)
+			return
+		}
+
+		for _, device := range server.otherDevices(conn) {
+			server.deliver(device, fmt.Sprintf("You left %s from another device", channelName))
+		}
+		server.removeMember(channel, conn)
+		sendReplyf(conn, rplParted, "You left %s", channelName)
+
+		payload := newMessagePayload(fmt.Sprintf("%s left %s", server.users.nickname(conn), channelName))
+		defer payload.release()
+		for member := range channel.members {
+			server.deliverPayload(member, payload)
+		}
+	})
+}
+
+// handleKnockCommand asks the operators of an invite-only channel to invite the requesting user.
+// Each user may knock on a channel at most once per configured knock interval.
+func (server *ChatServer) handleKnockCommand(conn net.Conn, channelName string) {
+
+	server.onHub(func() {
+		nickname, registered := server.users.lookup(conn)
+		if !registered {
+			sendReply(conn, errNotRegistered, "You must register a nickname before you can knock on a channel")
+			return
+		}
+
+		channel, exists := server.channels[channelName]
+		if !exists {
+			sendReplyf(conn, errNoSuchChannel, "No such channel %s", channelName)
+			return
+		}
+
+		if channel.isMember(conn) {
+			sendReplyf(conn, errAlreadyInChannel, "You're already in %s", channelName)
+			return
+		}
+		if !channel.inviteOnly {
+			sendReplyf(conn, errNotInviteOnly, "%s is not invite-only; use /JOIN instead", channelName)
+			return
+		}
+		if channel.isBanned(nickname, remoteIP(conn)) {
+			sendReplyf(conn, errBannedFromChannel, "Cannot knock on %s: you are banned", channelName)
+			return
+		}
+
+		if lastKnock, knocked := channel.knocks[nickname]; knocked {
+			if wait := server.config.KnockInterval - time.Since(lastKnock); wait > 0 {
+				sendReplyf(conn, errRateLimited, "You must wait %s before knocking on %s again", wait.Round(time.Second), channelName)
+				return
+			}
+		}
+		channel.recordKnock(nickname, server.config.KnockInterval)
+
+		payload := newMessagePayload(fmt.Sprintf("%s knocked on %s and is asking for an invite", nickname, channelName))
+		defer payload.release()
+		for member := range channel.members {
+			if channel.isOperator(member) {
+				server.deliverPayload(member, payload)
+			}
+		}
+
+		sendReplyf(conn, rplKnocked, "Knocked on %s", channelName)
+	})
+}
+
+// handleListChannelsCommand sends the requesting client every channel's name, member count, and topic.
+func (server *ChatServer) handleListChannelsCommand(conn net.Conn) {
+
+	server.onHub(func() {
+		if len(server.channels) == 0 {
+			sendReply(conn, rplChannelList, "No channels")
+			return
+		}
+
+		channelNames := make([]string, 0, len(server.channels))
+		for name := range server.channels {
+			channelNames = append(channelNames, name)
+		}
+		sort.Strings(channelNames)
+
+		sendReply(conn, rplChannelList, "Current channels:")
+		for _, name := range channelNames {
+			channel := server.channels[name]
+			if channel.topic == "" {
+				sendReplyf(conn, rplChannelList, "%s (%d members)", name, len(channel.members))
+			} else {
+				sendReplyf(conn, rplChannelList, "%s (%d members): %s", name, len(channel.members), channel.topic)
+			}
+		}
+	})
+}
+
+// handleNamesCommand sends the requesting client the members of a channel, with operators
+// prefixed by '@' and voiced members prefixed by '+'.
+func (server *ChatServer) handleNamesCommand(conn net.Conn, channelName string) {
+
+	server.onHub(func() {
+		channel, exists := server.channels[channelName]
+		if !exists {
+			sendReplyf(conn, errNoSuchChannel, "No such channel %s", channelName)
+			return
+		}
+
+		names := make([]string, 0, len(channel.members))
+		listed := make(map[string]bool)
+		for member, role := range channel.members {
+			// Users connected from several devices are listed once
+			if listed[server.users.nickname(member)] {
+				continue
+			}
+			listed[server.users.nickname(member)] = true
+			names = append(names, role.prefix()+server.users.nickname(member))
+		}
+		sort.Slice(names, func(i, j int) bool {
+			return strings.TrimLeft(names[i], "@+") < strings.TrimLeft(names[j], "@+")
+		})
+
+		sendReplyf(conn, rplNames, "Members of %s: %s", channelName, strings.Join(names, " "))
+	})
+}
+
+// handleTopicCommand shows the topic of a channel, or sets it when a new topic is given.
+// Only operators of the channel may change its topic; the change is announced to every member.
+func (server *ChatServer) handleTopicCommand(conn net.Conn, channelName string, newTopic string) {
+
+	server.onHub(func() {
+		channel, exists := server.channels[channelName]
+		if !exists {
+			sendReplyf(conn, errNoSuchChannel, "No such channel %s", channelName)
+			return
+		}
+
+		newTopic = strings.Trim(newTopic, " ")
+		if newTopic == "" {
+			if channel.topic == "" {
+				sendReplyf(conn, rplNoTopic, "No topic is set for %s", channelName)
+			} else {
+				sendReplyf(conn, rplTopic, "Topic for %s: %s", channelName, channel.topic)
+			}
+			return
+		}
+
+		if !channel.isOperator(conn) {
+			sendReplyf(conn, errNotChannelOperator, "You must be a channel operator to change the topic of %s", channelName)
+			return
+		}
+
+		channel.topic = newTopic
+		if channel.registered {
+			server.saveRegisteredChannels()
+		}
+
+		payload := newMessagePayload(fmt.Sprintf("%s changed the topic of %s to: %s", server.users.nickname(conn), channelName, newTopic))
+		defer payload.release()
+		for member := range channel.members {
+			server.deliverPayload(member, payload)
+		}
+	})
+}
+
+// handleModeCommand changes a channel's modes. Only channel operators may change modes.
+// Supported modes are +i and -i, which turn invite-only mode on and off, +k <key> and -k,
+// which set and remove the channel key, +l <count> and -l, which set and remove the member limit,
+// +m and -m, which turn moderated mode on and off, +S <seconds> and -S, which set and remove the slow mode interval,
+// +o <nick> and -o <nick>, which grant and revoke operator status, +r and -r, which register the channel so it persists across restarts and unregister it,
+// and +R <days> [messages] and -R, which set and remove a registered channel's own message retention.
+func (server *ChatServer) handleModeCommand(conn net.Conn, channelName string, modeArgs string) {
+
+	server.onHub(func() {
+		channel, exists := server.channels[channelName]
+		if !exists {
+			sendReplyf(conn, errNoSuchChannel, "No such channel %s", channelName)
+			return
+		}
+
+		if !channel.isOperator(conn) {
+			sendReplyf(conn, errNotChannelOperator, "You must be a channel operator to change modes of %s", channelName)
+			return
+		}
+
+		modeFields := strings.Fields(modeArgs)
+		if len(modeFields) == 0 {
+			sendReply(conn, errNeedMoreParams, "Missing mode")
+			return
+		}
+		mode := modeFields[0]
+
+		switch mode {
+
+			case "+i":
+				channel.inviteOnly = true
+
+			case "-i":
+				channel.inviteOnly = false
+				channel.invited = make(map[string]bool)
+
+			case "+k":
+				if len(modeFields) < 2 {
+					sendReply(conn, errNeedMoreParams, "Mode +k requires a key")
+					return
+				}
+				channel.key = modeFields[1]
+
+			case "-k":
+				channel.key = ""
+
+			case "+l":
+				if len(modeFields) < 2 {
+					sendReply(conn, errNeedMoreParams, "Mode +l requires a member limit")
+					return
+				}
+
+				limit, err := strconv.Atoi(modeFields[1])
+				if err != nil || limit < 1 {
+					sendReply(conn, errInvalidArgument, "Member limit must be a positive number")
+					return
+				}
+				channel.limit = limit
+				mode = mode + " " + modeFields[1]
+
+			case "-l":
+				channel.limit = 0
+
+			case "+m":
+				channel.moderated = true
+
+			case "-m":
+				channel.moderated = false
+
+			case "+S":
+				if len(modeFields) < 2 {
+					sendReply(conn, errNeedMoreParams, "Mode +S requires an interval in seconds")
+					return
+				}
+
+				seconds, err := strconv.Atoi(modeFields[1])
+				if err != nil || seconds < 1 {
+					sendReply(conn, errInvalidArgument, "Slow mode interval must be a positive number of seconds")
+					return
+				}
+				channel.slowMode = time.Duration(seconds) * time.Second
+				mode = mode + " " + modeFields[1]
+
+			case "-S":
+				channel.slowMode = 0
+				channel.lastSpoke = make(map[string]time.Time)
+
+			case "+o", "-o":
+				if len(modeFields) < 2 {
+					sendReplyf(conn, errNeedMoreParams, "Mode %s requires a nickname", mode)
+					return
+				}
+
+				targetConn, found := server.findChannelMember(channel, modeFields[1])
+				if !found {
+					sendReplyf(conn, errUserNotInChannel, "%s is not in %s", modeFields[1], channelName)
+					return
+				}
+
+				if mode == "+o" {
+					server.setMemberRole(channel, targetConn, ChannelOperator)
+					if channel.registered {
+						channel.operatorNicknames[modeFields[1]] = true
+					}
+				} else {
+					server.setMemberRole(channel, targetConn, ChannelMember)
+					delete(channel.operatorNicknames, modeFields[1])
+				}
+				mode = mode + " " + modeFields[1]
+
+			case "+r":
+				channel.registered = true
+				for member, role := range channel.members {
+					if role == ChannelOperator {
+						channel.operatorNicknames[server.users.nickname(member)] = true
+					}
+				}
+
+			case "-r":
+				if !channel.registered {
+					sendReplyf(conn, errChannelNotRegistered, "%s is not registered", channelName)
+					return
+				}
+				channel.registered = false
+				channel.operatorNicknames = make(map[string]bool)
+				channel.retention = nil
+				server.saveRegisteredChannels()
+
+			case "+R":
+				if !channel.registered {
+					sendReplyf(conn, errChannelNotRegistered, "%s must be registered (mode +r) to set its retention", channelName)
+					return
+				}
+				if len(modeFields) < 2 {
+					sendReply(conn, errNeedMoreParams, "Mode +R requires a number of days, and optionally a number of messages, to keep (0 = no limit)")
+					return
+				}
+
+				var policy RetentionPolicy
+				var daysErr, messagesErr error
+				policy.Days, daysErr = strconv.Atoi(modeFields[1])
+				if len(modeFields) >= 3 {
+					policy.Messages, messagesErr = strconv.Atoi(modeFields[2])
+				}
+				if daysErr != nil || messagesErr != nil || policy.Days < 0 || policy.Messages < 0 {
+					sendReply(conn, errInvalidArgument, "Retention days and messages must be numbers of at least 0")
+					return
+				}
+				channel.retention = &policy
+				mode = fmt.Sprintf("%s %d %d", mode, policy.Days, policy.Messages)
+
+			case "-R":
+				channel.retention = nil
+
+			default:
+				sendReplyf(conn, errUnknownMode, "Unknown mode %s", mode)
+				return
+		}
+
+		if channel.registered {
+			server.saveRegisteredChannels()
+		}
+
+		payload := newMessagePayload(fmt.Sprintf("%s set mode %s on %s", server.users.nickname(conn), mode, channelName))
+		defer payload.release()
+		for member := range channel.members {
+			server.deliverPayload(member, payload)
+		}
+	})
+}
+
+// handleInviteCommand lets a channel operator admit a nickname to an invite-only channel.
+// The invited user, if online, is told about the invitation.
+func (server *ChatServer) handleInviteCommand(conn net.Conn, nickname string, channelName string) {
+
+	server.onHub(func() {
+		channel, exists := server.channels[channelName]
+		if !exists {
+			sendReplyf(conn, errNoSuchChannel, "No such channel %s", channelName)
+			return
+		}
+
+		if !channel.isOperator(conn) {
+			sendReplyf(conn, errNotChannelOperator, "You must be a channel operator to invite users to %s", channelName)
+			return
+		}
+
+		channel.invited[nickname] = true
+		sendReplyf(conn, rplInviting, "Invited %s to %s", nickname, channelName)
+
+		if invitedConn, online := server.findUserByNickname(nickname); online {
+			server.deliver(invitedConn, fmt.Sprintf("%s invited you to %s", server.users.nickname(conn), channelName))
+		}
+	})
+}
+
+// handleChannelKickCommand lets a channel operator remove a member from a channel, with an optional reason.
+// The kicked user and the remaining members are told about the kick.
+func (server *ChatServer) handleChannelKickCommand(conn net.Conn, channelName string, nickname string, reason string) {
+
+	server.onHub(func() {
+		channel, exists := server.channels[channelName]
+		if !exists {
+			sendReplyf(conn, errNoSuchChannel, "No such channel %s", channelName)
+			return
+		}
+
+		if !channel.isOperator(conn) {
+			sendReplyf(conn, errNotChannelOperator, "You must be a channel operator to kick users from %s", channelName)
+			return
+		}
+
+		targetConn, found := server.findChannelMember(channel, nickname)
+		if !found {
+			sendReplyf(conn, errUserNotInChannel, "%s is not in %s", nickname, channelName)
+			return
+		}
+
+		kickMessage := fmt.Sprintf("%s was kicked from %s by %s", nickname, channelName, server.users.nickname(conn))
+		if reason != "" {
+			kickMessage += " (" + reason + ")"
+		}
+
+		payload := newMessagePayload(kickMessage)
+		defer payload.release()
+		for member := range channel.members {
+			server.deliverPayload(member, payload)
+		}
+		server.removeMember(channel, targetConn)
+	})
+}
+
+// handleVoiceCommand lets a channel operator grant or revoke a member's voice,
+// which allows them to speak while the channel is moderated.
+func (server *ChatServer) handleVoiceCommand(conn net.Conn, channelName string, nickname string, voice bool) {
+
+	server.onHub(func() {
+		channel, exists := server.channels[channelName]
+		if !exists {
+			sendReplyf(conn, errNoSuchChannel, "No such channel %s", channelName)
+			return
+		}
+
+		if !channel.isOperator(conn) {
+			sendReplyf(conn, errNotChannelOperator, "You must be a channel operator to change voice in %s", channelName)
+			return
+		}
+
+		targetConn, found := server.findChannelMember(channel, nickname)
+		if !found {
+			sendReplyf(conn, errUserNotInChannel, "%s is not in %s", nickname, channelName)
+			return
+		}
+
+		if channel.members[targetConn] == ChannelOperator {
+			sendReplyf(conn, errInvalidTarget, "%s is a channel operator and can always speak in %s", nickname, channelName)
+			return
+		}
+
+		var announcement string
+		if voice {
+			server.setMemberRole(channel, targetConn, ChannelVoiced)
+			announcement = fmt.Sprintf("%s gave voice to %s in %s", server.users.nickname(conn), nickname, channelName)
+		} else {
+			server.setMemberRole(channel, targetConn, ChannelMember)
+			announcement = fmt.Sprintf("%s removed voice from %s in %s", server.users.nickname(conn), nickname, channelName)
+		}
+
+		payload := newMessagePayload(announcement)
+		defer payload.release()
+		for member := range channel.members {
+			server.deliverPayload(member, payload)
+		}
+	})
+}
+
+// handleChannelBanCommand lets a channel operator list a channel's bans, add a ban mask, or remove one.
+// Masks may match either nicknames or IP addresses and may contain '*' and '?' wildcards.
+func (server *ChatServer) handleChannelBanCommand(conn net.Conn, channelName string, mask string, ban bool) {
+
+	server.onHub(func() {
+		channel, exists := server.channels[channelName]
+		if !exists {
+			sendReplyf(conn, errNoSuchChannel, "No such channel %s", channelName)
+			return
+		}
+
+		if !channel.isOperator(conn) {
+			sendReplyf(conn, errNotChannelOperator, "You must be a channel operator to manage bans in %s", channelName)
+			return
+		}
+
+		if mask == "" {
+			if len(channel.bans) == 0 {
+				sendReplyf(conn, rplBanList, "No bans in %s", channelName)
+				return
+			}
+
+			masks := make([]string, 0, len(channel.bans))
+			for banMask := range channel.bans {
+				masks = append(masks, banMask)
+			}
+			sort.Strings(masks)
+
+			sendReplyf(conn, rplBanList, "Bans in %s: %s", channelName, strings.Join(masks, " "))
+			return
+		}
+
+		var announcement string
+		if ban {
+			if _, err := path.Match(mask, ""); err != nil {
+				sendReplyf(conn, errInvalidArgument, "Invalid ban mask %s", mask)
+				return
+			}
+			channel.bans[mask] = true
+			announcement = fmt.Sprintf("%s banned %s from %s", server.users.nickname(conn), mask, channelName)
+
+		} else {
+			if !channel.bans[mask] {
+				sendReplyf(conn, errNoChange, "%s is not banned from %s", mask, channelName)
+				return
+			}
+			delete(channel.bans, mask)
+			announcement = fmt.Sprintf("%s unbanned %s from %s", server.users.nickname(conn), mask, channelName)
+		}
+
+		if channel.registered {
+			server.saveRegisteredChannels()
+		}
+
+		payload := newMessagePayload(announcement)
+		defer payload.release()
+		for member := range channel.members {
+			server.deliverPayload(member, payload)
+		}
+	})
+}
+
+// findChannelMember finds the connection of the channel member with the given nickname.
+// It must run on the hub goroutine.
+func (server *ChatServer) findChannelMember(channel *Channel, nickname string) (net.Conn, bool) {
+
+	for member := range channel.members {
+		if server.users.nickname(member) == nickname {
+			return member, true
+		}
+	}
+	return nil, false
+}
+
+// sendToChannel delivers a formatted message to every member of a channel except the sender, prefixed
+// with the channel name. The sender must be a member of the channel. It returns the ID the message was accepted
+// with, or the NACK code of a refused message. It must run on the hub goroutine.
+func (server *ChatServer) sendToChannel(conn net.Conn, channelName string, text string) (int64, string) {
+
+	channel, exists := server.channels[channelName]
+	if !exists || !channel.isMember(conn) {
+		sendReplyf(conn, errNotInChannel, "You're not in %s", channelName)
+		return 0, nackNotInChannel
+	}
+
+	if channel.moderated && channel.members[conn] == ChannelMember {
+		sendReplyf(conn, errModerated, "Cannot send to %s: channel is moderated and only operators and voiced users may speak", channelName)
+		return 0, nackModerated
+	}
+
+	senderNickname := server.users.nickname(conn)
+
+	// Channel operators are exempt from slow mode
+	if channel.slowMode > 0 && !channel.isOperator(conn) {
+		if wait := channel.slowMode - time.Since(channel.lastSpoke[senderNickname]); wait > 0 {
+			sendReplyf(conn, errSlowMode, "Cannot send to %s: slow mode is on, wait %d seconds before speaking again", channelName, int(math.Ceil(wait.Seconds())))
+			return 0, nackSlowMode
+		}
+		channel.lastSpoke[senderNickname] = time.Now()
+	}
+
+	body := text
+	text = fmt.Sprintf("[%s] %s", channelName, text)
+
+	recipients := make([]net.Conn, 0, len(channel.members))
+	for member := range channel.members {
+		if server.canDeliver(conn, member) {
+			recipients = append(recipients, member)
+		}
+	}
+	payload := newMessagePayload(text)
+	server.fanOut(recipients, payload)
+	payload.release()
+
+	// Shadow-banned messages must not surface later through history or /SEEN either
+	id := server.nextMessageID()
+	if server.shadowBans[senderNickname] {
+		return id, ""
+	}
+
+	server.recordChannelHistory(channel, text)
+	server.recordLastMessage(senderNickname, text)
+	server.storeMessage(StoredMessage{ID: id, Sender: senderNickname, Channel: channelName, Body: body})
+	return id, ""
+}
+
+// recordChannelHistory appends a message to a channel's history, discarding the oldest messages
+// once the configured history length is exceeded. It must run on the hub goroutine.
+func (server *ChatServer) recordChannelHistory(channel *Channel, text string) {
+
+	if server.config.ChannelHistory <= 0 {
+		return
+	}
+
+	channel.history = append(channel.history, channelMessage{sentAt: time.Now(), text: text})
+	if len(channel.history) > server.config.ChannelHistory {
+		channel.history = channel.history[len(channel.history)-server.config.ChannelHistory:]
+	}
+}
+
+// sweepEmptyChannels periodically removes channels that have no members and are not registered.
+// It runs for the lifetime of the server.
+func (server *ChatServer) sweepEmptyChannels(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		server.onHub(func() {
+			for name, channel := range server.channels {
+				if len(channel.members) == 0 && !channel.registered {
+					delete(server.channels, name)
+					slog.Info("Removed empty channel", "channel", name)
+				}
+			}
+		})
+	}
+}
+
+// removeFromAllChannels drops a connection from every channel it has joined. It must run on the hub goroutine.
+func (server *ChatServer) removeFromAllChannels(conn net.Conn) {
+
+	for _, channel := range server.channels {
+		delete(channel.members, conn)
+	}
+}
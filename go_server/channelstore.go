@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ChannelRecord is the stored representation of a registered channel.
+type ChannelRecord struct {
+	Name       string   `json:"name"`
+	Topic      string   `json:"topic,omitempty"`
+	InviteOnly bool     `json:"invite_only,omitempty"`
+	Key        string   `json:"key,omitempty"`
+	Limit      int      `json:"limit,omitempty"`
+	Moderated  bool     `json:"moderated,omitempty"`
+	SlowMode   int      `json:"slow_mode_seconds,omitempty"`
+	Operators  []string `json:"operators,omitempty"`
+	Bans       []string `json:"bans,omitempty"`
+
+	Retention *RetentionPolicy `json:"retention,omitempty"` // Retention replaces the server's message retention for the channel
+}
+
+// ChannelStore persists registered channels, so that a storage backend such as Bolt can keep them
+// in place of the channels file. Implementations must be safe for concurrent use.
+type ChannelStore interface {
+	// SaveChannels replaces every stored channel with the given ones.
+	SaveChannels(records []ChannelRecord) error
+
+	// LoadChannels returns every stored channel.
+	LoadChannels() ([]ChannelRecord, error)
+}
+
+// loadRegisteredChannels re-creates every registered channel kept in the channel store, or in the configured
+// channels file when there is no store. A missing file is not an error; it simply means no channels have been
+// registered yet.
+func (server *ChatServer) loadRegisteredChannels() error {
+
+	var records []ChannelRecord
+	source := server.config.ChannelsFile
+
+	if server.channelStore != nil {
+		var err error
+		if records, err = server.channelStore.LoadChannels(); err != nil {
+			return err
+		}
+		source = "the database"
+	} else {
+		if server.config.ChannelsFile == "" {
+			return nil
+		}
+
+		data, err := os.ReadFile(server.config.ChannelsFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(data, &records); err != nil {
+			return err
+		}
+	}
+
+	server.onHub(func() {
+		for _, record := range records {
+			server.restoreChannel(record)
+		}
+	})
+
+	slog.Info("Loaded registered channels", "count", len(records), "source", source)
+	return nil
+}
+
+// restoreChannel re-creates a registered channel from its record. It must run on the hub goroutine.
+func (server *ChatServer) restoreChannel(record ChannelRecord) {
+
+	channel := newChannel(record.Name)
+	channel.registered = true
+	channel.topic = record.Topic
+	channel.inviteOnly = record.InviteOnly
+	channel.key = record.Key
+	channel.limit = record.Limit
+	channel.moderated = record.Moderated
+	channel.slowMode = time.Duration(record.SlowMode) * time.Second
+	channel.retention = record.Retention
+	for _, nickname := range record.Operators {
+		channel.operatorNicknames[nickname] = true
+	}
+	for _, mask := range record.Bans {
+		channel.bans[mask] = true
+	}
+	server.channels[record.Name] = channel
+}
+
+// saveRegisteredChannels writes every registered channel to the channel store, or to the configured
+// channels file when there is no store, replacing the file atomically. Failures are logged rather than
+// returned, since the in-memory state remains valid. It must run on the hub goroutine.
+func (server *ChatServer) saveRegisteredChannels() {
+
+	if server.channelStore == nil && server.config.ChannelsFile == "" {
+		return
+	}
+
+	records := server.registeredChannelRecords()
+	if server.channelStore != nil {
+		if err := server.channelStore.SaveChannels(records); err != nil {
+			slog.Error("Failed to save registered channels", "err", err)
+		}
+		return
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		slog.Error("Failed to encode registered channels", "err", err)
+		return
+	}
+
+	if err := writeFileAtomic(server.config.ChannelsFile, data); err != nil {
+		slog.Error("Failed to save registered channels", "err", err)
+	}
+}
+
+// registeredChannelRecords returns the records of every registered channel, sorted by name.
+// It must run on the hub goroutine.
+func (server *ChatServer) registeredChannelRecords() []ChannelRecord {
+
+	records := []ChannelRecord{}
+	for _, channel := range server.channels {
+		if !channel.registered {
+			continue
+		}
+
+		record := ChannelRecord{
+			Name:       channel.name,
+			Topic:      channel.topic,
+			InviteOnly: channel.inviteOnly,
+			Key:        channel.key,
+			Limit:      channel.limit,
+			Moderated:  channel.moderated,
+			SlowMode:   int(channel.slowMode / time.Second),
+			Retention:  channel.retention,
+		}
+		for nickname := range channel.operatorNicknames {
+			record.Operators = append(record.Operators, nickname)
+		}
+		sort.Strings(record.Operators)
+
+		for mask := range channel.bans {
+			record.Bans = append(record.Bans, mask)
+		}
+		sort.Strings(record.Bans)
+
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+
+	return records
+}
+
+// writeFileAtomic writes data to a temporary file beside path and renames it into place,
+// so readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
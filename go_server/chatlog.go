@@ -0,0 +1,180 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultChatLogFormat is the default line format of the chat logs.
+const defaultChatLogFormat = "[{time}] {body}"
+
+// chatLogFile is an open log file and what is needed to decide when to rotate it.
+type chatLogFile struct {
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// chatLogger writes public traffic, and optionally private messages, to per-channel log files,
+// rotating each file when it grows too large or too old and compressing the rotated files.
+type chatLogger struct {
+	dir            string
+	format         string
+	maxSize        int64         // maxSize is the size at which a log is rotated; 0 disables size-based rotation
+	rotateInterval time.Duration // rotateInterval is the age at which a log is rotated; 0 disables time-based rotation
+
+	mutex sync.Mutex              // mutex protects access to files
+	files map[string]*chatLogFile // files maps log names to their open files
+}
+
+// newChatLogger creates a chat logger writing to dir, creating the directory if needed.
+func newChatLogger(dir string, format string, maxSize int64, rotateInterval time.Duration) (*chatLogger, error) {
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	return &chatLogger{
+		dir:            dir,
+		format:         format,
+		maxSize:        maxSize,
+		rotateInterval: rotateInterval,
+		files:          make(map[string]*chatLogFile),
+	}, nil
+}
+
+// write appends a message to the named log, formatted with the logger's format. The format's
+// {time}, {channel}, {sender}, {recipients}, and {body} placeholders are replaced with the message's fields.
+func (logger *chatLogger) write(name string, message StoredMessage) {
+
+	line := strings.NewReplacer(
+		"{time}", message.SentAt.Format(time.RFC3339),
+		"{channel}", message.Channel,
+		"{sender}", message.Sender,
+		"{recipients}", strings.Join(message.Recipients, ","),
+		"{body}", message.Body,
+	).Replace(logger.format) + "\n"
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	logFile, err := logger.open(name, int64(len(line)))
+	if err != nil {
+		slog.Error("Failed to open chat log", "log", name, "err", err)
+		return
+	}
+
+	written, err := io.WriteString(logFile.file, line)
+	logFile.size += int64(written)
+	if err != nil {
+		slog.Error("Failed to write chat log", "log", name, "err", err)
+	}
+}
+
+// open returns the named log's open file, rotating it first if writing pending more bytes would make it
+// too large or it is too old. The caller must hold logger.mutex.
+func (logger *chatLogger) open(name string, pending int64) (*chatLogFile, error) {
+
+	path := filepath.Join(logger.dir, name+".log")
+
+	logFile, exists := logger.files[name]
+	if exists {
+		tooLarge := logger.maxSize > 0 && logFile.size > 0 && logFile.size+pending > logger.maxSize
+		tooOld := logger.rotateInterval > 0 && time.Since(logFile.openedAt) >= logger.rotateInterval
+		if !tooLarge && !tooOld {
+			return logFile, nil
+		}
+
+		logFile.file.Close()
+		delete(logger.files, name)
+		logger.rotate(path)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	logFile = &chatLogFile{file: file, size: info.Size(), openedAt: time.Now()}
+	logger.files[name] = logFile
+	return logFile, nil
+}
+
+// rotate renames a full log out of the way and compresses it in the background. The caller must hold logger.mutex.
+func (logger *chatLogger) rotate(path string) {
+
+	rotated := strings.TrimSuffix(path, ".log") + "-" + time.Now().Format("20060102-150405.000000") + ".log"
+	if err := os.Rename(path, rotated); err != nil {
+		slog.Error("Failed to rotate chat log", "path", path, "err", err)
+		return
+	}
+
+	go func() {
+		if err := compressFile(rotated); err != nil {
+			slog.Error("Failed to compress chat log", "path", rotated, "err", err)
+		}
+	}()
+}
+
+// compressFile gzips a file to the same path with .gz appended, removing the original once compressed.
+func compressFile(path string) error {
+
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+
+	compressor := gzip.NewWriter(target)
+	_, err = io.Copy(compressor, source)
+	if closeErr := compressor.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// logMessage writes a message to the chat logs, if they are enabled. Channel messages go to a log per channel
+// and broadcasts to the broadcast log; direct messages go to the private log only if configured.
+func (server *ChatServer) logMessage(message StoredMessage) {
+
+	if server.chatLog == nil {
+		return
+	}
+
+	switch {
+
+		case message.Channel != "":
+			server.chatLog.write("channel-"+strings.TrimPrefix(message.Channel, "#"), message)
+
+		case len(message.Recipients) == 1 && message.Recipients[0] == "*":
+			server.chatLog.write("broadcast", message)
+
+		case server.config.ChatLogPrivate:
+			server.chatLog.write("private", message)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Client holds the state the server tracks for a single connection beyond the user's nickname.
+type Client struct {
+	conn        net.Conn       // conn is the client's network connection
+	lifecycle   *connLifecycle // lifecycle ends the connection when canceled
+	connectedAt time.Time      // connectedAt is when the client connected
+	lastActive  time.Time      // lastActive is when the client last sent a command
+	awayMessage string         // awayMessage is the user's away message, empty when the user is not away
+
+	scopes          []string // scopes restricts a client that authenticated with a bot token; nil means unrestricted
+	ssoIdentity     string   // ssoIdentity is the identity verified by the single sign-on provider, empty if none
+	account         string   // account is the nickname of the account the user has logged in to, empty if none
+	operator        bool     // operator grants server operator privileges such as /KICK
+	quietDisconnect bool     // quietDisconnect suppresses the leave announcement when the server ends the connection
+
+	ignored map[string]bool // ignored is the set of nicknames whose messages are never delivered to this client
+
+	pingToken  string        // pingToken is the token of the unanswered server PING, empty if none is pending
+	pingSentAt time.Time     // pingSentAt is when the pending server PING was sent
+	lag        time.Duration // lag is the round-trip latency measured by the last answered PING
+
+	commandLimits map[string]*tokenBucket // commandLimits holds the client's rate limiter for each rate-limited command
+	lastRenamed   time.Time               // lastRenamed is when the user last changed nickname, for the rename cooldown
+	nickTimer     *time.Timer             // nickTimer renames the user if they don't log in to the registered nickname they took
+
+	sessionToken     string             // sessionToken lets the user resume this session from a new connection with /RESUME
+	detached         bool               // detached is set while the session is held for resumption after its connection dropped
+	resumeTimer      *time.Timer        // resumeTimer ends a detached session once the resume grace period passes
+	sequence         uint64             // sequence is the sequence number of the latest message delivered to the session
+	detachedSequence uint64             // detachedSequence is the sequence number of the last message delivered before the session was detached
+	replay           []sequencedMessage // replay holds the latest messages delivered to a resumable session, for /RESUME to send again
+
+	lastMessage   string    // lastMessage is the text of the user's previous message, for repeat suppression
+	lastMessageAt time.Time // lastMessageAt is when the user's previous message was sent
+	repeats       int       // repeats counts how many times in a row lastMessage has been sent
+}
+
+// addClient starts tracking metadata for a newly connected client. Clients connecting from
+// a configured operator host are granted server operator privileges.
+func (server *ChatServer) addClient(conn net.Conn, lifecycle *connLifecycle) {
+
+	now := time.Now()
+
+	server.onHub(func() {
+		server.clients[conn] = &Client{
+			conn:        conn,
+			lifecycle:   lifecycle,
+			operator:    slices.Contains(server.config.OperatorHosts, remoteIP(conn)),
+			connectedAt: now,
+			lastActive:  now,
+			ignored:     make(map[string]bool),
+
+			commandLimits: make(map[string]*tokenBucket),
+		}
+	})
+}
+
+// touchClient records that a client has just sent a command.
+func (server *ChatServer) touchClient(conn net.Conn) {
+
+	server.onHub(func() {
+		if client, exists := server.clients[conn]; exists {
+			client.lastActive = time.Now()
+		}
+	})
+}
+
+// isIgnoring reports whether the receiving connection has ignored the sender's nickname.
+// It must run on the hub goroutine.
+func (server *ChatServer) isIgnoring(receiverConn net.Conn, senderNickname string) bool {
+
+	client, exists := server.clients[receiverConn]
+	return exists && client.ignored[senderNickname]
+}
+
+// handleIgnoreCommand adds a nickname to, or removes it from, the client's ignore list.
+// With no nickname it lists the nicknames currently ignored.
+func (server *ChatServer) handleIgnoreCommand(conn net.Conn, nickname string, ignore bool) {
+
+	server.onHub(func() {
+		client, exists := server.clients[conn]
+		if !exists {
+			return
+		}
+
+		if nickname == "" {
+			if len(client.ignored) == 0 {
+				sendReply(conn, rplIgnoreList, "You are not ignoring anyone")
+				return
+			}
+
+			nicknames := make([]string, 0, len(client.ignored))
+			for ignoredNickname := range client.ignored {
+				nicknames = append(nicknames, ignoredNickname)
+			}
+			sort.Strings(nicknames)
+
+			sendReplyf(conn, rplIgnoreList, "Ignoring: %s", strings.Join(nicknames, " "))
+			return
+		}
+
+		if ignore {
+			if nickname == server.users.nickname(conn) {
+				sendReply(conn, errInvalidTarget, "You cannot ignore yourself")
+				return
+			}
+			client.ignored[nickname] = true
+			sendReplyf(conn, rplIgnore, "You are now ignoring %s", nickname)
+
+		} else {
+			if !client.ignored[nickname] {
+				sendReplyf(conn, errNoChange, "You are not ignoring %s", nickname)
+				return
+			}
+			delete(client.ignored, nickname)
+			sendReplyf(conn, rplIgnore, "You are no longer ignoring %s", nickname)
+		}
+	})
+}
+
+// findUserByNickname returns the connection registered under a nickname. It must run on the hub goroutine.
+func (server *ChatServer) findUserByNickname(nickname string) (net.Conn, bool) {
+
+	return server.users.anyConnection(nickname)
+}
@@ -0,0 +1,290 @@
+// Command loadgen puts a chat server under load. It connects a number of simulated clients, joins them
+// all to one channel, and has each of them send messages to it at a steady rate; it then reports how
+// long the messages took to reach the other members, and how many of them never did.
+//
+// Every message carries the time it was sent, so loadgen should run on the same host as the server, or on
+// one with a closely synchronised clock. The server's flood, repeat, and connection limits apply to the
+// simulated clients like any others, so a server being load tested is normally started with them relaxed:
+//
+//	./server -flood-rate 0 -max-conns-per-ip 0
+//	go run ./cmd/loadgen -clients 500 -rate 2 -duration 30s
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// messageMarker starts the text of every message loadgen sends, telling its messages apart from the
+// server's notices and replies.
+const messageMarker = "loadgen"
+
+// options configure a load test.
+type options struct {
+	addr       string        // addr is the host:port of the server under test
+	useTLS     bool          // useTLS connects over TLS
+	insecure   bool          // insecure skips verifying the server's TLS certificate
+	clients    int           // clients is the number of simulated clients
+	rate       float64       // rate is the number of messages each client sends per second
+	duration   time.Duration // duration is how long the clients keep sending
+	drain      time.Duration // drain is how long to wait for messages still in flight once sending stops
+	channel    string        // channel is the channel the clients join and send to
+	nickPrefix string        // nickPrefix starts the nickname of every simulated client
+	size       int           // size pads each message to at least this many bytes
+}
+
+// loadClient is one simulated client and what it has seen.
+type loadClient struct {
+	nickname   string
+	conn       net.Conn
+	writer     *bufio.Writer
+	writeMutex sync.Mutex // writeMutex serialises the sender and the reader's PONGs
+
+	joined    chan struct{}   // joined is closed once the server confirms the client joined the channel
+	sent      atomic.Int64    // sent counts the messages the client sent
+	latencies []time.Duration // latencies holds the delivery latency of every message the client received
+	closing   atomic.Bool     // closing is set once the test is over and closes the connection
+	lost      atomic.Bool     // lost is set if the connection ended before the test did
+}
+
+func main() {
+
+	var opts options
+	flag.StringVar(&opts.addr, "addr", "localhost:4000", "host:port of the chat server under test")
+	flag.BoolVar(&opts.useTLS, "tls", false, "connect over TLS")
+	flag.BoolVar(&opts.insecure, "insecure", false, "skip verifying the server's TLS certificate")
+	flag.IntVar(&opts.clients, "clients", 100, "number of simulated clients")
+	flag.Float64Var(&opts.rate, "rate", 1, "messages each client sends per second")
+	flag.DurationVar(&opts.duration, "duration", 30*time.Second, "how long the clients keep sending")
+	flag.DurationVar(&opts.drain, "drain", 5*time.Second, "how long to wait for messages still in flight once sending stops")
+	flag.StringVar(&opts.channel, "channel", "#loadgen", "channel the clients join and send to")
+	flag.StringVar(&opts.nickPrefix, "nick-prefix", "lg", "prefix of the simulated clients' nicknames")
+	flag.IntVar(&opts.size, "size", 0, "pad each message to at least this many bytes")
+	flag.Parse()
+
+	if opts.clients < 2 {
+		log.Fatalln("-clients must be at least 2, so messages have someone to reach")
+	}
+	if opts.rate <= 0 {
+		log.Fatalln("-rate must be positive")
+	}
+	if len(opts.nickPrefix)+len(strconv.Itoa(opts.clients-1)) > 10 {
+		log.Fatalln("-nick-prefix is too long for nicknames of at most 10 characters")
+	}
+
+	clients, readers := connectClients(opts)
+	log.Printf("%d clients joined %s; sending %.2f messages per second each for %s\n", len(clients), opts.channel, opts.rate, opts.duration)
+
+	start := time.Now()
+	var senders sync.WaitGroup
+	for _, client := range clients {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			client.send(opts, start.Add(opts.duration))
+		}()
+	}
+	senders.Wait()
+	elapsed := time.Since(start)
+
+	time.Sleep(opts.drain)
+	for _, client := range clients {
+		client.closing.Store(true)
+		client.conn.Close()
+	}
+	readers.Wait()
+
+	report(os.Stdout, clients, elapsed)
+}
+
+// connectClients connects every simulated client and waits for all of them to join the channel. The
+// returned WaitGroup is done once every client's connection has been read to the end.
+func connectClients(opts options) ([]*loadClient, *sync.WaitGroup) {
+
+	clients := make([]*loadClient, opts.clients)
+	readers := &sync.WaitGroup{}
+	for i := range clients {
+		conn, err := dial(opts)
+		if err != nil {
+			log.Fatalf("Failed to connect client %d: %v\n", i, err)
+		}
+
+		client := &loadClient{
+			nickname: fmt.Sprintf("%s%d", opts.nickPrefix, i),
+			conn:     conn,
+			writer:   bufio.NewWriter(conn),
+			joined:   make(chan struct{}),
+		}
+		clients[i] = client
+
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			client.read()
+		}()
+
+		if err := client.writeLine(fmt.Sprintf("/NICK %s\n/JOIN %s", client.nickname, opts.channel)); err != nil {
+			log.Fatalf("Failed to register %s: %v\n", client.nickname, err)
+		}
+	}
+
+	deadline := time.After(30 * time.Second)
+	for _, client := range clients {
+		select {
+
+			case <-client.joined:
+
+			case <-deadline:
+				log.Fatalf("Timed out waiting for %s to join %s\n", client.nickname, opts.channel)
+		}
+	}
+	return clients, readers
+}
+
+// dial connects to the server under test.
+func dial(opts options) (net.Conn, error) {
+
+	if opts.useTLS {
+		return tls.Dial("tcp", opts.addr, &tls.Config{InsecureSkipVerify: opts.insecure})
+	}
+	return net.Dial("tcp", opts.addr)
+}
+
+// writeLine sends a line to the server.
+func (client *loadClient) writeLine(line string) error {
+
+	client.writeMutex.Lock()
+	defer client.writeMutex.Unlock()
+
+	client.writer.WriteString(line)
+	client.writer.WriteByte('\n')
+	return client.writer.Flush()
+}
+
+// send sends messages to the channel at the configured rate until the deadline. Messages are sent on a
+// fixed schedule rather than after one another, so a slow server shows up as latency, not a lower rate.
+func (client *loadClient) send(opts options, deadline time.Time) {
+
+	interval := time.Duration(float64(time.Second) / opts.rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for seq := 0; time.Now().Before(deadline); seq++ {
+		message := fmt.Sprintf("/MSG %s %s %s %d %d", opts.channel, messageMarker, client.nickname, seq, time.Now().UnixNano())
+		if pad := opts.size - len(message); pad > 0 {
+			message += " " + strings.Repeat("x", pad)
+		}
+		if err := client.writeLine(message); err != nil {
+			client.lost.Store(true)
+			return
+		}
+		client.sent.Add(1)
+		<-ticker.C
+	}
+}
+
+// read reads the client's connection until it is closed, noting when it joins the channel, answering
+// keepalive PINGs, and recording the latency of every loadgen message delivered to it.
+func (client *loadClient) read() {
+
+	scanner := bufio.NewScanner(client.conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	joined := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+
+			case strings.HasPrefix(line, "PING "):
+				client.writeLine("/PONG " + strings.TrimPrefix(line, "PING "))
+
+			case !joined && strings.Contains(line, "RPL_JOINED"):
+				joined = true
+				close(client.joined)
+
+			default:
+				if latency, ok := messageLatency(line); ok {
+					client.latencies = append(client.latencies, latency)
+				}
+		}
+	}
+
+	// A connection that ends before the test closes it was ended by the server
+	if !client.closing.Load() {
+		client.lost.Store(true)
+	}
+}
+
+// messageLatency returns how long ago a loadgen message line was sent, and whether the line is one.
+func messageLatency(line string) (time.Duration, bool) {
+
+	_, text, found := strings.Cut(line, " said: "+messageMarker+" ")
+	if !found {
+		return 0, false
+	}
+
+	// The text is the sender's nickname, the message's sequence number, and when it was sent
+	fields := strings.Fields(text)
+	if len(fields) < 3 {
+		return 0, false
+	}
+	sentAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Since(time.Unix(0, sentAt)), true
+}
+
+// report writes the results of a load test: the messages sent and delivered, the deliveries dropped,
+// and percentiles of the delivery latency.
+func report(out io.Writer, clients []*loadClient, elapsed time.Duration) {
+
+	var sent, lostClients int64
+	var latencies []time.Duration
+	for _, client := range clients {
+		sent += client.sent.Load()
+		latencies = append(latencies, client.latencies...)
+		if client.lost.Load() {
+			lostClients++
+		}
+	}
+
+	// Every message is meant for every member of the channel but its sender
+	expected := sent * int64(len(clients)-1)
+	delivered := int64(len(latencies))
+	dropped := expected - delivered
+
+	fmt.Fprintf(out, "clients:    %d (%d disconnected by the server)\n", len(clients), lostClients)
+	fmt.Fprintf(out, "sent:       %d messages in %s (%.1f/s)\n", sent, elapsed.Round(time.Millisecond), float64(sent)/elapsed.Seconds())
+	fmt.Fprintf(out, "delivered:  %d of %d (%.1f/s)\n", delivered, expected, float64(delivered)/elapsed.Seconds())
+	if expected > 0 {
+		fmt.Fprintf(out, "dropped:    %d (%.3f%%)\n", dropped, 100*float64(dropped)/float64(expected))
+	}
+	if len(latencies) == 0 {
+		return
+	}
+
+	slices.Sort(latencies)
+	fmt.Fprintf(out, "latency:    p50 %s  p90 %s  p99 %s  p99.9 %s  max %s\n",
+		percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99), percentile(latencies, 99.9),
+		latencies[len(latencies)-1].Round(time.Microsecond))
+}
+
+// percentile returns the given percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+
+	index := int(float64(len(sorted)-1) * p / 100)
+	return sorted[index].Round(time.Microsecond)
+}
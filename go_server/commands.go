@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+)
+
+// Command describes a command of the line protocol: how its arguments are parsed, who may use it, and what it does.
+// Commands are registered with RegisterCommand, so that new commands need no changes to the parser.
+type Command struct {
+	Name       string     // Name is the command as typed, such as /NICK
+	Usage      string     // Usage describes the arguments, such as "<#channel> [key]", for usage errors and /HELP
+	Params     int        // Params is the number of arguments the command takes
+	Required   int        // Required is the number of arguments that must be given; the rest may be left out
+	Rest       bool       // Rest makes the last argument everything left on the line, as typed, rather than one word
+	Permission Permission // Permission is the permission the command needs, when Restricted is set
+	Restricted bool       // Restricted limits the command to roles granted Permission
+	Tags       []string   // Tags are the client tags the command accepts, given before it as "@name=value;name=value /COMMAND"
+
+	// Run carries out the command. It is given exactly Params arguments, with those left out empty,
+	// followed by the value of each of its Tags, empty for those not given.
+	Run func(server *ChatServer, conn net.Conn, args []string)
+}
+
+// commandRegistry maps each command's name to its description.
+var commandRegistry = make(map[string]*Command)
+
+// RegisterCommand adds a command to the line protocol. It panics if the command is already registered,
+// since two commands with one name would make one of them unreachable.
+func RegisterCommand(command Command) {
+
+	command.Name = normalizeCommandName(command.Name)
+	if _, exists := commandRegistry[command.Name]; exists {
+		panic("command " + command.Name + " registered twice")
+	}
+	commandRegistry[command.Name] = &command
+}
+
+// normalizeCommandName returns the form a command's name is registered and looked up under, so that commands
+// match whatever their case: /nick, /Nick, and /NICK are all /NICK.
+func normalizeCommandName(name string) string {
+
+	return strings.ToUpper(name)
+}
+
+// errUnterminatedQuote is returned for an argument with an opening quote but no closing one.
+var errUnterminatedQuote = errors.New("unterminated quoted argument")
+
+// parseArguments splits the arguments of a command line. Arguments are separated by spaces, and one holding spaces
+// can be given in double quotes, with \" and \\ standing for a quote and a backslash within them. When the command
+// takes the rest of the line as its last argument, that argument is kept as typed. Only the arguments given are returned.
+func (command *Command) parseArguments(line string) ([]string, error) {
+
+	var args []string
+	for {
+		line = strings.TrimLeft(line, " ")
+		if line == "" {
+			return args, nil
+		}
+
+		if command.Rest && len(args) == command.Params-1 {
+			return append(args, line), nil
+		}
+
+		if line[0] != '"' {
+			word, rest, _ := strings.Cut(line, " ")
+			args = append(args, word)
+			line = rest
+			continue
+		}
+
+		var word strings.Builder
+		closed := false
+		i := 1
+		for ; i < len(line) && !closed; i++ {
+			switch {
+
+				case line[i] == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\'):
+					i++
+					word.WriteByte(line[i])
+
+				case line[i] == '"':
+					closed = true
+
+				default:
+					word.WriteByte(line[i])
+			}
+		}
+		if !closed || (i < len(line) && line[i] != ' ') {
+			return nil, errUnterminatedQuote
+		}
+		args = append(args, word.String())
+		line = line[i:]
+	}
+}
+
+// usageLine returns how a command is used, such as "/JOIN <#channel> [key]".
+func (command *Command) usageLine() string {
+
+	return strings.TrimSpace(command.Name + " " + command.Usage)
+}
+
+// splitCommandTags separates the client tags given before a command, as "@name=value;name=value /COMMAND ...",
+// from the command. A tag given without a value has an empty one.
+func splitCommandTags(userCommand string) (map[string]string, string) {
+
+	if !strings.HasPrefix(userCommand, "@") {
+		return nil, userCommand
+	}
+
+	tagList, userCommand, _ := strings.Cut(userCommand[1:], " ")
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(tagList, ";") {
+		name, value, _ := strings.Cut(tag, "=")
+		tags[name] = value
+	}
+	return tags, strings.TrimLeft(userCommand, " ")
+}
+
+// handleUserCommands interprets and processes a command received from a user, looking it up in the registry,
+// parsing its arguments and tags, and checking them, the rate limits, and the user's permissions before running it.
+func (server *ChatServer) handleUserCommands(userCommand string, conn net.Conn) {
+
+	tags, userCommand := splitCommandTags(userCommand)
+	name, line, _ := strings.Cut(userCommand, " ")
+	command, known := commandRegistry[normalizeCommandName(name)]
+	if !known {
+		sendReply(conn, errUnknownCommand, "Invalid command")
+
+		server.onHub(func() {
+			server.recordFailure(conn, "an invalid command")
+		})
+		return
+	}
+
+	args, err := command.parseArguments(line)
+	if err != nil {
+		sendReplyf(conn, errInvalidArgument, "Invalid arguments to %s: %v", command.Name, err)
+		return
+	}
+	if len(args) < command.Required || len(args) > command.Params {
+		sendReplyf(conn, errUsage, "Usage: %s", command.usageLine())
+		return
+	}
+	for tag := range tags {
+		if !slices.Contains(command.Tags, tag) {
+			sendReplyf(conn, errInvalidArgument, "%s does not accept the %s tag", command.Name, tag)
+			return
+		}
+	}
+
+	if !server.allowCommand(conn, command.Name) || !server.authorizeCommand(conn, command, args) {
+		return
+	}
+
+	args = append(args, make([]string, command.Params-len(args))...)
+	for _, tag := range command.Tags {
+		args = append(args, tags[tag])
+	}
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		server.clientLog(conn).Debug("Running command", "command", command.Name)
+	}
+	command.Run(server, conn, args)
+}
+
+// init registers the commands built into the server.
+func init() {
+
+	for _, command := range builtinCommands {
+		RegisterCommand(command)
+	}
+}
+
+// builtinCommands are the commands built into the server.
+var builtinCommands = []Command{
+	{Name: NICK, Usage: "<nickname>", Params: 1, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleNicknameCommand(conn, args[0])
+	}},
+	{Name: LIST, Usage: "[CHANNELS]", Params: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		if strings.EqualFold(args[0], "CHANNELS") {
+			server.handleListChannelsCommand(conn)
+		} else {
+			server.handleListCommand(conn)
+		}
+	}},
+	{Name: MSG, Usage: "<nick|#channel|*>[,...] <text>", Params: 2, Required: 2, Rest: true, Tags: []string{dedupTag}, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleMessageCommand(conn, args[0], args[1], SpeechMessage, args[2])
+	}},
+	{Name: ME, Usage: "<nick|#channel|*>[,...] <action>", Params: 2, Required: 2, Rest: true, Tags: []string{dedupTag}, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleMessageCommand(conn, args[0], args[1], ActionMessage, args[2])
+	}},
+	{Name: TYPING, Usage: "<nick|#channel>[,...] [active|paused|done]", Params: 2, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleTypingCommand(conn, args[0], args[1])
+	}},
+
+	{Name: JOIN, Usage: "<#channel> [key]", Params: 2, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleJoinCommand(conn, args[0], args[1])
+	}},
+	{Name: PART, Usage: "<#channel>", Params: 1, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handlePartCommand(conn, args[0])
+	}},
+	{Name: NAMES, Usage: "<#channel>", Params: 1, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleNamesCommand(conn, args[0])
+	}},
+	{Name: TOPIC, Usage: "<#channel> [topic]", Params: 2, Required: 1, Rest: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleTopicCommand(conn, args[0], args[1])
+	}},
+	{Name: KNOCK, Usage: "<#channel>", Params: 1, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleKnockCommand(conn, args[0])
+	}},
+	{Name: MODE, Usage: "<#channel> <modes> [arguments]", Params: 2, Required: 2, Rest: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleModeCommand(conn, args[0], args[1])
+	}},
+	{Name: INVITE, Usage: "<nick> <#channel>", Params: 2, Required: 2, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleInviteCommand(conn, args[0], args[1])
+	}},
+	{Name: VOICE, Usage: "<#channel> <nick>", Params: 2, Required: 2, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleVoiceCommand(conn, args[0], args[1], true)
+	}},
+	{Name: DEVOICE, Usage: "<#channel> <nick>", Params: 2, Required: 2, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleVoiceCommand(conn, args[0], args[1], false)
+	}},
+	{Name: CBAN, Usage: "<#channel> [mask]", Params: 2, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleChannelBanCommand(conn, args[0], args[1], true)
+	}},
+	{Name: CUNBAN, Usage: "<#channel> <mask>", Params: 2, Required: 2, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleChannelBanCommand(conn, args[0], args[1], false)
+	}},
+
+	// Kicking from a channel is governed by channel roles, and kicking from the server by the kick permission
+	{Name: KICK, Usage: "<nick> [reason] | <#channel> <nick> [reason]", Params: 2, Required: 1, Rest: true, Permission: PermKick, Restricted: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		if !isChannelName(args[0]) {
+			server.handleKickCommand(conn, args[0], strings.TrimSpace(args[1]))
+			return
+		}
+		nickname, reason, _ := strings.Cut(args[1], " ")
+		if nickname == "" {
+			sendReplyf(conn, errUsage, "Usage: %s <#channel> <nick> [reason]", KICK)
+			return
+		}
+		server.handleChannelKickCommand(conn, args[0], nickname, strings.TrimSpace(reason))
+	}},
+
+	{Name: WHOIS, Usage: "<nick>", Params: 1, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleWhoisCommand(conn, args[0])
+	}},
+	{Name: WHO, Usage: "<pattern>", Params: 1, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleWhoCommand(conn, args[0])
+	}},
+	{Name: WHOWAS, Usage: "<nick>", Params: 1, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleWhowasCommand(conn, args[0])
+	}},
+	{Name: SEEN, Usage: "<nick>", Params: 1, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleSeenCommand(conn, args[0])
+	}},
+	{Name: AWAY, Usage: "[message]", Params: 1, Rest: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleAwayCommand(conn, args[0])
+	}},
+	{Name: IGNORE, Usage: "[nick]", Params: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleIgnoreCommand(conn, args[0], true)
+	}},
+	{Name: UNIGNORE, Usage: "<nick>", Params: 1, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleIgnoreCommand(conn, args[0], false)
+	}},
+	{Name: NOTIFY, Usage: "[nick]", Params: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleNotifyCommand(conn, args[0], true)
+	}},
+	{Name: UNNOTIFY, Usage: "<nick>", Params: 1, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleNotifyCommand(conn, args[0], false)
+	}},
+
+	{Name: REGISTER, Usage: "<password>", Params: 1, Required: 1, Rest: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleRegisterCommand(conn, args[0])
+	}},
+	{Name: LOGIN, Usage: "<nick> <password>", Params: 2, Required: 2, Rest: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleLoginCommand(conn, args[0], args[1])
+	}},
+	{Name: GHOST, Usage: "<nick> <password>", Params: 2, Required: 2, Rest: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleGhostCommand(conn, args[0], args[1])
+	}},
+	{Name: CERT, Usage: "ADD | DEL <fingerprint> | LIST", Params: 1, Rest: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleCertCommand(conn, args[0])
+	}},
+	{Name: SSO, Params: 0, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleSSOCommand(conn)
+	}},
+	{Name: AUTH, Usage: "<token>", Params: 1, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleAuthCommand(conn, args[0])
+	}},
+	{Name: RESUME, Usage: "<token> [last seq]", Params: 2, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleResumeCommand(conn, args[0], args[1])
+	}},
+
+	{Name: OPER, Usage: "<password>", Params: 1, Required: 1, Rest: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleOperCommand(conn, args[0])
+	}},
+	{Name: BAN, Usage: "[nick|ip] [duration]", Params: 2, Permission: PermBan, Restricted: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleBanCommand(conn, args[0], args[1])
+	}},
+	{Name: UNBAN, Usage: "<nick|ip>", Params: 1, Required: 1, Permission: PermBan, Restricted: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleUnbanCommand(conn, args[0])
+	}},
+	{Name: MUTE, Usage: "<nick> <minutes>", Params: 2, Required: 2, Permission: PermMute, Restricted: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleMuteCommand(conn, args[0], args[1])
+	}},
+	{Name: UNMUTE, Usage: "<nick>", Params: 1, Required: 1, Permission: PermMute, Restricted: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleUnmuteCommand(conn, args[0])
+	}},
+	{Name: SHADOWBAN, Usage: "<nick>", Params: 1, Required: 1, Permission: PermMute, Restricted: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleShadowbanCommand(conn, args[0])
+	}},
+	{Name: UNSHADOWBAN, Usage: "<nick>", Params: 1, Required: 1, Permission: PermMute, Restricted: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleUnshadowbanCommand(conn, args[0])
+	}},
+	{Name: TOKEN, Usage: "CREATE <name> <read,send> | REVOKE <name> | LIST", Params: 1, Rest: true, Permission: PermManageTokens, Restricted: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleTokenCommand(conn, args[0])
+	}},
+	{Name: ROLE, Usage: "<nick> <role>", Params: 2, Required: 2, Permission: PermAssignRoles, Restricted: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleRoleCommand(conn, args[0], args[1])
+	}},
+	{Name: ARCHIVE, Usage: "<#channel|nick> <from YYYY-MM-DD> <to YYYY-MM-DD> [json|csv]", Params: 2, Required: 1, Rest: true, Permission: PermExportArchives, Restricted: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleArchiveCommand(conn, args[0], args[1])
+	}},
+	{Name: STATS, Params: 0, Permission: PermViewStats, Restricted: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleStatsCommand(conn)
+	}},
+
+	{Name: HISTORY, Usage: "<#channel|nick> [count] [before id]", Params: 2, Required: 1, Rest: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleHistoryCommand(conn, args[0], args[1])
+	}},
+	{Name: SEARCH, Usage: "<#channel> <words> [" + searchBeforePrefix + "<id>]", Params: 2, Required: 1, Rest: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleSearchCommand(conn, args[0], args[1])
+	}},
+	{Name: EXPORT, Params: 0, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleExportCommand(conn)
+	}},
+
+	{Name: UPTIME, Params: 0, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleUptimeCommand(conn)
+	}},
+	{Name: TIME, Params: 0, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleTimeCommand(conn)
+	}},
+	{Name: VERSION, Params: 0, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleVersionCommand(conn)
+	}},
+	{Name: MOTD, Params: 0, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleMotdCommand(conn)
+	}},
+	{Name: TOP, Usage: "[DAY|WEEK]", Params: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleTopCommand(conn, strings.ToUpper(args[0]))
+	}},
+	{Name: PING, Usage: "[token]", Params: 1, Rest: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handlePingCommand(conn, args[0])
+	}},
+	{Name: PONG, Usage: "<token>", Params: 1, Required: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handlePongCommand(conn, args[0])
+	}},
+	{Name: LAG, Params: 0, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleLagCommand(conn)
+	}},
+	{Name: CAP, Usage: "LS | LIST | REQ <capability> [-<capability>...]", Params: 1, Rest: true, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleCapCommand(conn, args[0])
+	}},
+	{Name: HELP, Usage: "[command]", Params: 1, Run: func(server *ChatServer, conn net.Conn, args []string) {
+		server.handleHelpCommand(conn, args[0])
+	}},
+}
+
+// describeCommand returns the usage of a registered command, noting when it is restricted.
+func describeCommand(command *Command) string {
+
+	if command.Restricted {
+		return fmt.Sprintf("%s (staff only)", command.usageLine())
+	}
+	return command.usageLine()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// BenchmarkParseCommand measures the parse path every command line takes: splitting off client tags,
+// looking up the command, and splitting its arguments.
+func BenchmarkParseCommand(b *testing.B) {
+
+	lines := map[string]string{
+		"plain":  "/MSG #lobby hello everyone, how is it going?",
+		"quoted": `/TOPIC #lobby "release \"2.0\" is out"`,
+		"tagged": "@dedup=4f1c2a /MSG alice,bob,#lobby see you all tomorrow",
+	}
+
+	for name, line := range lines {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, userCommand := splitCommandTags(line)
+				commandName, arguments, _ := strings.Cut(userCommand, " ")
+				command, known := commandRegistry[normalizeCommandName(commandName)]
+				if !known {
+					b.Fatalf("Unknown command in %q", line)
+				}
+				if _, err := command.parseArguments(arguments); err != nil {
+					b.Fatalf("Failed to parse %q: %v", line, err)
+				}
+			}
+		})
+	}
+}
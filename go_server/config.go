@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Config holds the settings that control server behavior, populated from command-line flags.
+type Config struct {
+	ListenAddrs   []string // ListenAddrs are the host:port addresses the server listens on; "[::]:port" listens on IPv4 and IPv6, and port 0 picks a free port
+	OperatorHosts []string // OperatorHosts lists remote IPs whose connections are granted server operator privileges
+	OperPassword  string   // OperPassword is the password for /OPER; operator login is disabled when empty
+
+	TLSCert   string      // TLSCert is the PEM certificate of the TLS listener; TLS is disabled unless TLSCert and TLSKey are set
+	TLSKey    string      // TLSKey is the PEM private key of the TLS listener
+	TLSPort   string      // TLSPort is the port the TLS listener accepts connections on, on each host the server listens on
+	TLSOnly   bool        // TLSOnly turns off the plaintext listener, so clients can only connect over TLS
+	TLSConfig *tls.Config // TLSConfig, when set by an embedding program, is used for the TLS listener instead of TLSCert and TLSKey
+
+	QUICListen string // QUICListen is the UDP address of the experimental QUIC listener, which requires TLS; empty disables it
+	GRPCListen string // GRPCListen is the address the gRPC chat service listens on, over TLS when TLS is configured; empty disables it
+	HTTPListen string // HTTPListen is the address of the HTTP endpoint serving WebSocket clients, the HTTP API, and the browser client, over TLS when TLS is configured; empty disables it
+
+	PprofListen string // PprofListen is the address of the HTTP endpoint serving net/http/pprof profiles, over TLS when TLS is configured; empty disables it
+	PprofToken  string // PprofToken is the bearer token every request to the profiling endpoint must carry
+
+	MaxConnsPerIP  int     // MaxConnsPerIP caps simultaneous connections from one IP; 0 means unlimited
+	MaxConns       int     // MaxConns caps simultaneous connections to the server; 0 means unlimited
+	FullPolicy     string  // FullPolicy is what happens to a new connection once MaxConns are open: "refuse" it, or "evict" an idle one
+	AcceptRate     float64 // AcceptRate is the sustained number of connections accepted per second; 0 means unlimited
+	AcceptBurst    int     // AcceptBurst is the number of connections that may be accepted at once before AcceptRate applies
+	MaxLineLength  int     // MaxLineLength is the longest line, in bytes, a client may send; longer lines are rejected
+	SendQueueSize  int     // SendQueueSize is the number of writes queued for a client before it is disconnected as too slow to read them
+	FanOutWorkers  int     // FanOutWorkers is the number of workers writing large broadcasts to their recipients in parallel
+	TranscriptSize int     // TranscriptSize is the number of delivered lines kept per connection for /EXPORT
+	WhowasSize     int     // WhowasSize is the number of given up nicknames remembered for /WHOWAS
+	ChannelsFile   string  // ChannelsFile is where registered channels are persisted between restarts
+	SeenFile       string  // SeenFile is where the /SEEN last-seen index is persisted between restarts
+	MotdFile       string  // MotdFile is the message of the day sent after registration; reloaded on SIGHUP
+	ActivityFile   string  // ActivityFile is where the /TOP message counters are persisted between restarts
+	BansFile       string  // BansFile is where the /BAN list is persisted between restarts
+	AccountsFile   string  // AccountsFile is where registered accounts are persisted
+	TokensFile     string  // TokensFile is where bot authentication tokens are persisted
+	OfflineFile    string  // OfflineFile is where direct messages held for offline users are persisted
+	ArchiveDir     string  // ArchiveDir is the directory /ARCHIVE writes exported messages to
+	SnapshotFile   string  // SnapshotFile is where periodic snapshots of the server state are written and recovered from; empty disables snapshots
+	MessageDB      string  // MessageDB is the SQLite database where messages and the last-seen index are persisted; empty keeps messages in memory only
+	BoltDB         string  // BoltDB is the Bolt database where messages, the last-seen index, accounts, and registered channels are persisted
+
+	GuestNicknames    bool          // GuestNicknames gives connecting clients a generated nickname so they can chat without /NICK
+	ResumeGracePeriod time.Duration // ResumeGracePeriod is how long a disconnected session can be resumed with /RESUME; 0 disables resumption
+	ResumeBufferSize  int           // ResumeBufferSize is the number of the latest messages delivered to a session kept for /RESUME to send again
+	DedupWindow       time.Duration // DedupWindow is how long a message's dedup ID is remembered, so a retry isn't delivered twice; 0 disables dedup IDs
+	OfflineQueueSize  int           // OfflineQueueSize is the most direct messages held for an offline registered nickname; 0 disables holding
+	OfflineExpiry     time.Duration // OfflineExpiry is how long held messages are kept before being discarded; 0 keeps them until delivered
+	NickGracePeriod   time.Duration // NickGracePeriod is how long a user taking a registered nickname has to log in before being renamed; 0 refuses the nickname
+
+	OIDCIssuer       string        // OIDCIssuer is the OpenID Connect identity provider used by /SSO; empty disables single sign-on
+	OIDCClientID     string        // OIDCClientID is the server's client ID at the identity provider
+	OIDCClientSecret string        // OIDCClientSecret is the server's client secret at the identity provider
+	OIDCRedirectURL  string        // OIDCRedirectURL is the public URL of the callback endpoint registered with the identity provider
+	OIDCListenAddr   string        // OIDCListenAddr is the address the callback HTTP endpoint listens on
+	OIDCLoginTimeout time.Duration // OIDCLoginTimeout is how long a /SSO login URL remains valid
+
+	ChatLogDir            string        // ChatLogDir is the directory public traffic is logged to, one file per channel; empty disables chat logs
+	ChatLogFormat         string        // ChatLogFormat is the line format of the chat logs, with {time}, {channel}, {sender}, {recipients}, and {body} placeholders
+	ChatLogMaxSize        int64         // ChatLogMaxSize is the size in bytes at which a chat log is rotated; 0 disables size-based rotation
+	ChatLogRotateInterval time.Duration // ChatLogRotateInterval is the age at which a chat log is rotated; 0 disables time-based rotation
+	ChatLogPrivate        bool          // ChatLogPrivate also logs direct messages, to a separate private log
+
+	RedisAddr     string // RedisAddr is the Redis server sharing nicknames, away status, and channel membership between servers; empty disables sharing
+	RedisPassword string // RedisPassword authenticates with the Redis server
+	RedisPrefix   string // RedisPrefix starts every Redis key, so several chat networks can share one Redis server
+
+	AuthWebhookURL     string        // AuthWebhookURL is an HTTP endpoint that decides /LOGIN attempts; empty uses only local accounts
+	AuthWebhookSecret  string        // AuthWebhookSecret is sent to the auth webhook as a bearer token
+	AuthWebhookTimeout time.Duration // AuthWebhookTimeout is how long to wait for the auth webhook to answer
+
+	LinkBlocklistFile string // LinkBlocklistFile lists link domains that may not be posted, one per line; empty disables the link filter
+	LinkFilterAction  string // LinkFilterAction is what happens to messages with blocked links: "block" or "flag"
+
+	AutoBanThreshold int           // AutoBanThreshold is the number of failures within AutoBanWindow that bans an IP; 0 disables auto-banning
+	AutoBanWindow    time.Duration // AutoBanWindow is the period over which failures are counted
+	AutoBanDuration  time.Duration // AutoBanDuration is how long an automatic ban lasts
+
+	ChannelHistory int    // ChannelHistory is the number of recent messages per channel replayed on /JOIN
+	DefaultChannel string // DefaultChannel is the channel newly registered users are placed in
+	AutoJoin       bool   // AutoJoin controls whether newly registered users join DefaultChannel
+	ChannelLimit   int    // ChannelLimit is the member cap given to newly created channels; 0 means unlimited
+
+	ChannelSweepInterval time.Duration // ChannelSweepInterval is how often empty unregistered channels are removed
+	KnockInterval        time.Duration // KnockInterval is the minimum time between a user's /KNOCKs on the same channel
+	PingInterval         time.Duration // PingInterval is how long a client may send nothing before the server PINGs it; 0 disables keepalive
+	PingTimeout          time.Duration // PingTimeout is how long a client has to answer a PING before being disconnected
+	IdleTimeout          time.Duration // IdleTimeout is how long a client may send nothing at all, not even PONG, before being disconnected; 0 disables it
+	WriteTimeout         time.Duration // WriteTimeout is the deadline for each write to a client; 0 disables write deadlines
+
+	BackupBucket    string        // BackupBucket is the S3-compatible bucket backups are uploaded to; empty disables backups
+	BackupEndpoint  string        // BackupEndpoint is the URL of the S3-compatible object store
+	BackupRegion    string        // BackupRegion is the region requests to the object store are signed for
+	BackupAccessKey string        // BackupAccessKey is the access key ID used to sign requests to the object store
+	BackupSecretKey string        // BackupSecretKey is the secret access key used to sign requests to the object store
+	BackupPrefix    string        // BackupPrefix is prepended to the key of every backup
+	BackupInterval  time.Duration // BackupInterval is how often a backup is uploaded
+	BackupKeep      int           // BackupKeep is the number of most recent backups kept; 0 keeps every backup
+	BackupRestore   string        // BackupRestore is the key of a backup, or "latest", to restore on startup; empty restores nothing
+
+	RetentionDays     int           // RetentionDays is how many days stored messages are kept; 0 keeps them without an age limit
+	RetentionMessages int           // RetentionMessages is the most stored messages kept per channel; 0 sets no limit
+	RetentionInterval time.Duration // RetentionInterval is how often stored messages outside the retention are deleted
+	SnapshotInterval  time.Duration // SnapshotInterval is how often the server state is written to SnapshotFile
+
+	FloodRate    float64 // FloodRate is the sustained number of lines per second a client may send; 0 disables flood protection
+	FloodBurst   int     // FloodBurst is the number of lines a client may send in a quick burst
+	FloodStrikes int     // FloodStrikes is the number of rate limit violations before a client is disconnected; 0 never disconnects
+
+	CommandRate  float64       // CommandRate is the sustained rate per second of each expensive command such as /LIST; 0 disables the limit
+	CommandBurst int           // CommandBurst is the number of each expensive command a client may send in a quick burst
+	NickCooldown time.Duration // NickCooldown is the minimum time between a user's nickname changes
+
+	RepeatLimit  int           // RepeatLimit is how many times in a row a message may be repeated within RepeatWindow; 0 disables the check
+	RepeatWindow time.Duration // RepeatWindow is how long a message counts as a repeat of the previous one
+
+	LogLevel  string // LogLevel is the least severe level logged: "debug", "info", "warn", or "error"
+	LogFormat string // LogFormat is how log records are written: "text" or "json"
+}
+
+// parseConfig reads the server configuration from command-line flags.
+func parseConfig() Config {
+
+	config := defineConfigFlags(flag.CommandLine)
+	flag.Parse()
+	return *config
+}
+
+// defineConfigFlags defines the configuration flags on a flag set, returning the configuration they fill in
+// when the flag set is parsed. Tests parse their own flag sets to configure servers.
+func defineConfigFlags(flags *flag.FlagSet) *Config {
+
+	var config Config
+
+	config.ListenAddrs = []string{net.JoinHostPort(HOST, PORT)}
+	flags.Func("listen", "comma-separated host:port addresses to listen on, such as [::]:4000 for IPv4 and IPv6 (default "+net.JoinHostPort(HOST, PORT)+")", func(value string) error {
+		config.ListenAddrs = strings.Split(value, ",")
+		return nil
+	})
+	flags.Func("operator-hosts", "comma-separated remote IPs granted server operator privileges", func(value string) error {
+		config.OperatorHosts = strings.Split(value, ",")
+		return nil
+	})
+	flags.StringVar(&config.OperPassword, "oper-password", os.Getenv("CHAT_OPER_PASSWORD"), "password for /OPER (defaults to $CHAT_OPER_PASSWORD; empty disables operator login)")
+	flags.StringVar(&config.TLSCert, "tls-cert", "", "PEM certificate file for the TLS listener (empty = TLS disabled)")
+	flags.StringVar(&config.TLSKey, "tls-key", "", "PEM private key file for the TLS listener")
+	flags.StringVar(&config.TLSPort, "tls-port", "4443", "port the TLS listener accepts connections on")
+	flags.BoolVar(&config.TLSOnly, "tls-only", false, "accept connections only over TLS, turning off the plaintext listener")
+	flags.StringVar(&config.QUICListen, "quic-listen", "", "UDP address of the experimental QUIC listener, which requires -tls-cert and -tls-key (empty = disabled)")
+	flags.StringVar(&config.GRPCListen, "grpc-listen", "", "address the gRPC chat service listens on, over TLS when TLS is configured (empty = disabled)")
+	flags.StringVar(&config.HTTPListen, "http-listen", "", "address of the HTTP endpoint serving the browser client, WebSocket clients at "+websocketPath+", and the HTTP API, over TLS when TLS is configured (empty = disabled)")
+	flags.StringVar(&config.PprofListen, "pprof-listen", "", "address of the HTTP endpoint serving runtime profiles at "+pprofPath+", over TLS when TLS is configured; keep it private (empty = disabled)")
+	flags.StringVar(&config.PprofToken, "pprof-token", os.Getenv("CHAT_PPROF_TOKEN"), "bearer token required by the profiling endpoint (defaults to $CHAT_PPROF_TOKEN)")
+	flags.IntVar(&config.MaxConnsPerIP, "max-conns-per-ip", 0, "maximum simultaneous connections per remote IP (0 = unlimited)")
+	flags.IntVar(&config.MaxConns, "max-conns", 0, "maximum simultaneous connections to the server (0 = unlimited)")
+	flags.StringVar(&config.FullPolicy, "max-conns-policy", FullPolicyRefuse, "what to do with a new connection once -max-conns are open: refuse it, or evict the longest-idle unauthenticated connection")
+	flags.Float64Var(&config.AcceptRate, "accept-rate", 100, "sustained connections accepted per second; others wait in the listen backlog (0 = unlimited)")
+	flags.IntVar(&config.AcceptBurst, "accept-burst", 200, "connections that may be accepted at once before -accept-rate applies")
+	flags.IntVar(&config.MaxLineLength, "max-line-length", defaultMaxLineLength, "longest line in bytes a client may send; longer lines are rejected")
+	flags.IntVar(&config.SendQueueSize, "send-queue-size", 1024, "writes queued for a client before it is disconnected for not reading them")
+	flags.IntVar(&config.FanOutWorkers, "fanout-workers", runtime.GOMAXPROCS(0), "workers writing large broadcasts to their recipients in parallel")
+	flags.IntVar(&config.TranscriptSize, "transcript-size", 100, "number of delivered lines kept per connection for /EXPORT")
+	flags.IntVar(&config.WhowasSize, "whowas-size", 100, "number of given up nicknames remembered for /WHOWAS")
+	flags.StringVar(&config.ChannelsFile, "channels-file", "channels.json", "file where registered channels are persisted")
+	flags.StringVar(&config.SeenFile, "seen-file", "seen.json", "file where the last-seen index is persisted")
+	flags.StringVar(&config.MotdFile, "motd-file", "motd.txt", "file containing the message of the day (reloaded on SIGHUP)")
+	flags.StringVar(&config.ActivityFile, "activity-file", "activity.json", "file where the /TOP message counters are persisted")
+	flags.StringVar(&config.BansFile, "bans-file", "bans.json", "file where the IP ban list is persisted")
+	flags.StringVar(&config.AccountsFile, "accounts-file", "accounts.json", "file where registered accounts are persisted")
+	flags.StringVar(&config.TokensFile, "tokens-file", "tokens.json", "file where bot authentication tokens are persisted")
+	flags.StringVar(&config.OfflineFile, "offline-file", "offline.json", "file where direct messages held for offline users are persisted")
+	flags.StringVar(&config.ArchiveDir, "archive-dir", "archives", "directory /ARCHIVE writes exported messages to")
+	flags.StringVar(&config.SnapshotFile, "snapshot-file", "", "file periodic snapshots of accounts, channels, bans, and offline messages are written to, and recovered from on startup when their own files are missing (empty = disabled)")
+	flags.StringVar(&config.MessageDB, "message-db", "", "SQLite database where messages and the last-seen index are persisted, instead of memory and -seen-file (empty = disabled)")
+	flags.StringVar(&config.BoltDB, "bolt-db", "", "Bolt database where messages, the last-seen index, accounts, and registered channels are persisted, instead of memory and their files (empty = disabled)")
+	flags.StringVar(&config.OIDCIssuer, "oidc-issuer", "", "OpenID Connect issuer URL for /SSO logins (empty = disabled)")
+	flags.StringVar(&config.OIDCClientID, "oidc-client-id", "", "client ID registered with the OpenID Connect provider")
+	flags.StringVar(&config.OIDCClientSecret, "oidc-client-secret", os.Getenv("CHAT_OIDC_CLIENT_SECRET"), "client secret registered with the OpenID Connect provider (defaults to $CHAT_OIDC_CLIENT_SECRET)")
+	flags.StringVar(&config.OIDCRedirectURL, "oidc-redirect-url", "http://localhost:8080"+oidcCallbackPath, "public URL of the single sign-on callback endpoint")
+	flags.StringVar(&config.OIDCListenAddr, "oidc-listen", "localhost:8080", "address the single sign-on callback endpoint listens on")
+	flags.DurationVar(&config.OIDCLoginTimeout, "oidc-login-timeout", 5*time.Minute, "how long a /SSO login URL remains valid")
+	flags.StringVar(&config.ChatLogDir, "chat-log-dir", "", "directory public channel traffic is logged to, one file per channel (empty = disabled)")
+	flags.StringVar(&config.ChatLogFormat, "chat-log-format", defaultChatLogFormat, "chat log line format, with {time}, {channel}, {sender}, {recipients}, and {body} placeholders")
+	flags.Int64Var(&config.ChatLogMaxSize, "chat-log-max-size", 10<<20, "size in bytes at which a chat log is rotated and compressed (0 = no size limit)")
+	flags.DurationVar(&config.ChatLogRotateInterval, "chat-log-rotate", 24*time.Hour, "age at which a chat log is rotated and compressed (0 = no age limit)")
+	flags.BoolVar(&config.ChatLogPrivate, "chat-log-private", false, "also log direct messages, to a separate private log")
+	flags.StringVar(&config.RedisAddr, "redis-addr", "", "Redis server address for sharing presence between server instances (empty = disabled)")
+	flags.StringVar(&config.RedisPassword, "redis-password", os.Getenv("CHAT_REDIS_PASSWORD"), "Redis password (defaults to $CHAT_REDIS_PASSWORD)")
+	flags.StringVar(&config.RedisPrefix, "redis-prefix", "chat:", "prefix for every Redis key")
+	flags.StringVar(&config.AuthWebhookURL, "auth-webhook", "", "HTTP endpoint that login attempts are POSTed to for an allow/deny and role decision (empty = disabled)")
+	flags.StringVar(&config.AuthWebhookSecret, "auth-webhook-secret", os.Getenv("CHAT_AUTH_WEBHOOK_SECRET"), "bearer token sent to the auth webhook (defaults to $CHAT_AUTH_WEBHOOK_SECRET)")
+	flags.DurationVar(&config.AuthWebhookTimeout, "auth-webhook-timeout", 5*time.Second, "how long to wait for the auth webhook to answer")
+	flags.BoolVar(&config.GuestNicknames, "guest-nicknames", false, "give connecting clients a generated guest nickname so they can chat without /NICK")
+	flags.DurationVar(&config.ResumeGracePeriod, "resume-grace-period", 0, "how long a disconnected session can be resumed with /RESUME (0 = disabled)")
+	flags.IntVar(&config.OfflineQueueSize, "offline-queue-size", 50, "most direct messages held for an offline registered nickname (0 = disabled)")
+	flags.DurationVar(&config.OfflineExpiry, "offline-expiry", 7*24*time.Hour, "how long messages held for offline users are kept (0 = until delivered)")
+	flags.IntVar(&config.ResumeBufferSize, "resume-buffer-size", 100, "number of the latest messages delivered to a session kept for resuming it")
+	flags.DurationVar(&config.DedupWindow, "dedup-window", 10*time.Minute, "how long a message's dedup ID is remembered so that a retry is not delivered again (0 = disabled)")
+	flags.DurationVar(&config.NickGracePeriod, "nick-grace-period", time.Minute, "time a user taking a registered nickname has to log in before being renamed (0 = refuse the nickname)")
+	flags.StringVar(&config.LinkBlocklistFile, "link-blocklist-file", "", "file listing link domains that may not be posted, one per line (empty = disabled)")
+	flags.StringVar(&config.LinkFilterAction, "link-filter-action", LinkFilterBlock, "what to do with messages linking to blocked domains: block or flag (report to operators)")
+	flags.IntVar(&config.AutoBanThreshold, "autoban-threshold", 10, "invalid commands or failed password attempts within the window that ban an IP (0 = disabled)")
+	flags.DurationVar(&config.AutoBanWindow, "autoban-window", time.Minute, "period over which failures are counted towards an automatic ban")
+	flags.DurationVar(&config.AutoBanDuration, "autoban-duration", 10*time.Minute, "how long an automatic ban lasts")
+	flags.StringVar(&config.DefaultChannel, "default-channel", "#lobby", "channel newly registered users automatically join")
+	flags.BoolVar(&config.AutoJoin, "auto-join", true, "automatically join newly registered users to the default channel")
+	flags.IntVar(&config.ChannelLimit, "channel-limit", 0, "member cap for newly created channels (0 = unlimited)")
+	flags.DurationVar(&config.ChannelSweepInterval, "channel-sweep-interval", time.Minute, "how often empty unregistered channels are removed (0 = never)")
+	flags.DurationVar(&config.KnockInterval, "knock-interval", time.Minute, "minimum time between a user's knocks on the same channel")
+	flags.DurationVar(&config.PingInterval, "ping-interval", 2*time.Minute, "how long a client may be idle before it is sent a keepalive PING (0 = disabled)")
+	flags.DurationVar(&config.PingTimeout, "ping-timeout", time.Minute, "how long a client has to answer a PING before being disconnected")
+	flags.DurationVar(&config.IdleTimeout, "idle-timeout", 10*time.Minute, "how long a client may send nothing, not even PONG, before being disconnected (0 = never)")
+	flags.DurationVar(&config.WriteTimeout, "write-timeout", 10*time.Second, "deadline for each write to a client; a client missing several in a row is disconnected (0 = no deadline)")
+	flags.StringVar(&config.BackupBucket, "backup-bucket", "", "S3-compatible bucket backups are uploaded to (empty = disabled)")
+	flags.StringVar(&config.BackupEndpoint, "backup-endpoint", "https://s3.amazonaws.com", "URL of the S3-compatible object store backups are uploaded to")
+	flags.StringVar(&config.BackupRegion, "backup-region", "us-east-1", "region of the backup bucket")
+	flags.StringVar(&config.BackupAccessKey, "backup-access-key", os.Getenv("CHAT_BACKUP_ACCESS_KEY"), "access key ID for the backup bucket (defaults to $CHAT_BACKUP_ACCESS_KEY)")
+	flags.StringVar(&config.BackupSecretKey, "backup-secret-key", os.Getenv("CHAT_BACKUP_SECRET_KEY"), "secret access key for the backup bucket (defaults to $CHAT_BACKUP_SECRET_KEY)")
+	flags.StringVar(&config.BackupPrefix, "backup-prefix", "chat-backups/", "prefix of the keys backups are uploaded under")
+	flags.DurationVar(&config.BackupInterval, "backup-interval", 24*time.Hour, "how often a backup is uploaded")
+	flags.IntVar(&config.BackupKeep, "backup-keep", 7, "number of most recent backups kept in the bucket (0 = keep all)")
+	flags.StringVar(&config.BackupRestore, "backup-restore", "", "key of a backup, or \"latest\", to restore over the state files and database on startup (empty = none)")
+	flags.IntVar(&config.RetentionDays, "retention-days", 0, "days stored messages are kept; registered channels can override it with mode +R (0 = no limit)")
+	flags.IntVar(&config.RetentionMessages, "retention-messages", 0, "most stored messages kept per channel; registered channels can override it with mode +R (0 = no limit)")
+	flags.DurationVar(&config.RetentionInterval, "retention-interval", time.Hour, "how often stored messages outside the retention are deleted (0 = never)")
+	flags.DurationVar(&config.SnapshotInterval, "snapshot-interval", 5*time.Minute, "how often the server state is written to the snapshot file")
+	flags.Float64Var(&config.FloodRate, "flood-rate", 5, "sustained lines per second a client may send (0 = unlimited)")
+	flags.IntVar(&config.FloodBurst, "flood-burst", 10, "lines a client may send in a quick burst")
+	flags.IntVar(&config.FloodStrikes, "flood-strikes", 20, "rate limit violations before a client is disconnected (0 = never)")
+	flags.Float64Var(&config.CommandRate, "command-rate", 0.2, "sustained rate per second of each expensive command such as /LIST and /WHOIS (0 = unlimited)")
+	flags.IntVar(&config.CommandBurst, "command-burst", 3, "number of each expensive command a client may send in a quick burst")
+	flags.DurationVar(&config.NickCooldown, "nick-cooldown", 30*time.Second, "minimum time between a user's nickname changes")
+	flags.IntVar(&config.RepeatLimit, "repeat-limit", 3, "identical messages in a row allowed within the repeat window (0 = unlimited)")
+	flags.DurationVar(&config.RepeatWindow, "repeat-window", 30*time.Second, "how long a message counts as a repeat of the previous one")
+	flags.IntVar(&config.ChannelHistory, "channel-history", 20, "number of recent messages per channel replayed on join (0 = disabled)")
+	flags.StringVar(&config.LogLevel, "log-level", "info", "least severe level logged: debug, info, warn, or error")
+	flags.StringVar(&config.LogFormat, "log-format", LogFormatText, "how log records are written: text, or json for log aggregation")
+	return &config
+}
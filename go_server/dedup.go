@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+)
+
+// dedupTag is the client tag carrying a message's dedup ID: "@dedup=<id> /MSG ...".
+const dedupTag = "dedup"
+
+// maxDedupIDLength is the longest dedup ID a client may give.
+const maxDedupIDLength = 64
+
+// dedupRecord remembers a message sent with a dedup ID, so that a retry of it is acknowledged again rather than delivered again.
+type dedupRecord struct {
+	key          string           // key is the sender's nickname and the dedup ID
+	acknowledged []acknowledgment // acknowledged are the acknowledgments the message was given
+	rememberedAt time.Time        // rememberedAt is when the message was sent
+}
+
+// withDedupID returns a message command tagged with its dedup ID, if it has one. Dedup IDs can't hold spaces or semicolons,
+// which end the tag.
+func withDedupID(dedupID string, command string) string {
+
+	if dedupID == "" {
+		return command
+	}
+	return "@" + dedupTag + "=" + dedupID + " " + command
+}
+
+// dedupKey returns the key a sender's dedup ID is remembered under. IDs belong to nicknames rather than connections,
+// so that a message retried after reconnecting is still recognized.
+func dedupKey(senderNickname string, dedupID string) string {
+
+	return senderNickname + " " + dedupID
+}
+
+// findDuplicate returns the acknowledgments of a message already sent with a dedup ID, if there is one.
+// It must run on the hub goroutine.
+func (server *ChatServer) findDuplicate(senderNickname string, dedupID string) ([]acknowledgment, bool) {
+
+	server.forgetExpiredDedupIDs()
+	record, exists := server.dedupIDs[dedupKey(senderNickname, dedupID)]
+	if !exists {
+		return nil, false
+	}
+	return record.acknowledged, true
+}
+
+// rememberDedupID records the acknowledgments of a message sent with a dedup ID for the dedup window.
+// Messages refused for every target are not remembered, so that a retry is tried afresh. It must run on the hub goroutine.
+func (server *ChatServer) rememberDedupID(senderNickname string, dedupID string, acknowledged []acknowledgment) {
+
+	if len(acknowledged) == 0 {
+		return
+	}
+
+	record := &dedupRecord{key: dedupKey(senderNickname, dedupID), acknowledged: acknowledged, rememberedAt: time.Now()}
+	server.dedupIDs[record.key] = record
+	server.dedupOrder = append(server.dedupOrder, record)
+}
+
+// forgetExpiredDedupIDs drops the dedup IDs remembered for longer than the dedup window. Records are kept in the order
+// they were remembered, so only the expired ones at the front are looked at. It must run on the hub goroutine.
+func (server *ChatServer) forgetExpiredDedupIDs() {
+
+	expired := 0
+	for _, record := range server.dedupOrder {
+		if time.Since(record.rememberedAt) < server.config.DedupWindow {
+			break
+		}
+		if server.dedupIDs[record.key] == record {
+			delete(server.dedupIDs, record.key)
+		}
+		expired++
+	}
+	server.dedupOrder = server.dedupOrder[expired:]
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// devicesOf returns every connection registered under a nickname. A user logged in to an account
+// may be connected from several devices at once, which all share the account's nickname.
+// It must run on the hub goroutine.
+func (server *ChatServer) devicesOf(nickname string) []net.Conn {
+
+	return server.users.connections(nickname)
+}
+
+// otherDevices returns the user's connections other than conn. It must run on the hub goroutine.
+func (server *ChatServer) otherDevices(conn net.Conn) []net.Conn {
+
+	nickname, registered := server.users.lookup(conn)
+	if !registered {
+		return nil
+	}
+
+	var devices []net.Conn
+	for _, device := range server.devicesOf(nickname) {
+		if device != conn {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// attachDevice adds a connection that has logged in to an account as another device of the user
+// already connected as existing, sharing their nickname and channel memberships.
+// It must run on the hub goroutine.
+func (server *ChatServer) attachDevice(conn net.Conn, existing net.Conn) {
+
+	nickname := server.users.nickname(existing)
+
+	// A guest nickname used before logging in is given up
+	if currentNickname, registered := server.users.lookup(conn); registered {
+		server.broadcastMsg(UserLeavesServer, conn, currentNickname)
+		server.removeUser(conn)
+	}
+
+	server.users.set(conn, nickname)
+	if server.clients[conn].sessionToken == "" {
+		server.issueSessionToken(conn)
+	}
+
+	channelNames := server.channelsOf(existing)
+	for _, channelName := range channelNames {
+		channel := server.channels[channelName]
+		channel.members[conn] = channel.members[existing]
+		sendReplyf(conn, rplJoined, "You joined %s", channelName)
+	}
+
+	sendReplyf(conn, rplNickname, "Connected as %s alongside %d other device(s)", nickname, len(server.otherDevices(conn)))
+	for _, device := range server.otherDevices(conn) {
+		server.deliver(device, fmt.Sprintf("%s connected another device from %s", nickname, conn.RemoteAddr()))
+	}
+	server.clientLog(conn).Info("Attached as another device")
+}
+
+// setMemberRole gives a channel member a role on every one of their devices, adding any devices
+// not yet in the channel. It must run on the hub goroutine.
+func (server *ChatServer) setMemberRole(channel *Channel, conn net.Conn, role ChannelRole) {
+
+	channel.members[conn] = role
+	for _, device := range server.otherDevices(conn) {
+		if !channel.isMember(device) {
+			server.deliver(device, fmt.Sprintf("You joined %s from another device", channel.name))
+		}
+		channel.members[device] = role
+	}
+}
+
+// removeMember removes a user from a channel on every one of their devices. It must run on the hub goroutine.
+func (server *ChatServer) removeMember(channel *Channel, conn net.Conn) {
+
+	delete(channel.members, conn)
+	for _, device := range server.otherDevices(conn) {
+		delete(channel.members, device)
+	}
+	server.partPresence(channel.name, server.users.nickname(conn))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fanOutThreshold is the fewest recipients a broadcast needs to be handed to the fan-out workers; smaller
+// broadcasts are written by the hub goroutine itself, as handing them over would cost more than it saves.
+const fanOutThreshold = 64
+
+// fanOutChunk is the number of recipients each fan-out job writes to.
+const fanOutChunk = 32
+
+// pendingWrite is a line to be written to one recipient of a broadcast, held until it is written.
+type pendingWrite struct {
+	conn net.Conn
+	line *outboundBuffer
+}
+
+// fanOutJob is a worker's share of the writes of one broadcast.
+type fanOutJob struct {
+	writes    []pendingWrite
+	broadcast *broadcastProgress
+}
+
+// broadcastProgress accounts for the writes of one broadcast as the workers complete them.
+type broadcastProgress struct {
+	done   sync.WaitGroup // done is waited on until every job of the broadcast is written
+	failed atomic.Int64   // failed counts the writes that failed, to connections already closing
+}
+
+// fanOutPool is a bounded pool of workers writing broadcasts to their recipients in parallel, so that
+// encoding and queueing a message for thousands of sockets is not a single sequential loop. It keeps
+// running totals of the broadcasts it has written, for metrics.
+type fanOutPool struct {
+	jobs chan fanOutJob // jobs receives the writes for the workers to make
+
+	broadcasts atomic.Int64 // broadcasts counts the broadcasts written by the workers
+	writes     atomic.Int64 // writes counts the writes made for those broadcasts
+	failures   atomic.Int64 // failures counts the writes that failed, to connections already closing
+	busy       atomic.Int64 // busy is the total time, in nanoseconds, taken from handing out a broadcast to its last write
+}
+
+// fanOutStats is a snapshot of the fan-out pool's running totals.
+type fanOutStats struct {
+	Broadcasts int64
+	Writes     int64
+	Failures   int64
+	Busy       time.Duration
+}
+
+// newFanOutPool starts a fan-out pool with the given number of workers.
+func newFanOutPool(workers int) *fanOutPool {
+
+	pool := &fanOutPool{jobs: make(chan fanOutJob, workers)}
+	for i := 0; i < workers; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+// work makes the writes of each job handed to the pool.
+func (pool *fanOutPool) work() {
+
+	for job := range pool.jobs {
+		for _, write := range job.writes {
+			if err := writeOutbound(write.conn, write.line); err != nil {
+				job.broadcast.failed.Add(1)
+			}
+		}
+		job.broadcast.done.Done()
+	}
+}
+
+// write splits a broadcast's writes into jobs for the workers and returns once every one of them is made,
+// so that whatever the caller writes next reaches each recipient after the broadcast does. Each recipient
+// must appear once, as writes to the same connection in different jobs may be made in either order.
+func (pool *fanOutPool) write(writes []pendingWrite) {
+
+	start := time.Now()
+	broadcast := &broadcastProgress{}
+	for remaining := writes; len(remaining) > 0; {
+		job := remaining[:min(fanOutChunk, len(remaining))]
+		remaining = remaining[len(job):]
+
+		broadcast.done.Add(1)
+		pool.jobs <- fanOutJob{writes: job, broadcast: broadcast}
+	}
+	broadcast.done.Wait()
+
+	pool.broadcasts.Add(1)
+	pool.writes.Add(int64(len(writes)))
+	pool.failures.Add(broadcast.failed.Load())
+	pool.busy.Add(int64(time.Since(start)))
+}
+
+// stats returns the pool's running totals.
+func (pool *fanOutPool) stats() fanOutStats {
+
+	return fanOutStats{
+		Broadcasts: pool.broadcasts.Load(),
+		Writes:     pool.writes.Load(),
+		Failures:   pool.failures.Load(),
+		Busy:       time.Duration(pool.busy.Load()),
+	}
+}
+
+// fanOut delivers a payload to each of the recipients, the way deliverPayload does. Large broadcasts are
+// written by the fan-out pool in parallel, small ones by the hub goroutine as they are recorded; either way
+// every write is made before fanOut returns. It must run on the hub goroutine.
+func (server *ChatServer) fanOut(recipients []net.Conn, payload *messagePayload) {
+
+	if len(recipients) < fanOutThreshold {
+		for _, conn := range recipients {
+			server.deliverPayload(conn, payload)
+		}
+		return
+	}
+
+	writes := make([]pendingWrite, 0, len(recipients))
+	for _, conn := range recipients {
+		if line := server.recordDelivery(conn, payload); line != nil {
+			writes = append(writes, pendingWrite{conn: conn, line: line})
+		}
+	}
+	server.fanOutPool.write(writes)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// discardConn is a connection whose writes always succeed and go nowhere, standing in for a client socket.
+type discardConn struct {
+	net.Conn
+}
+
+// Write implements net.Conn, discarding the data.
+func (discardConn) Write(p []byte) (int, error) {
+
+	return len(p), nil
+}
+
+// Close implements net.Conn.
+func (discardConn) Close() error {
+
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn; writes never block, so deadlines are ignored.
+func (discardConn) SetWriteDeadline(time.Time) error {
+
+	return nil
+}
+
+// RemoteAddr implements net.Conn.
+func (discardConn) RemoteAddr() net.Addr {
+
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// BenchmarkFanOut measures broadcasting a message to channels of increasing size on the hub goroutine,
+// through the outbound queues of the recipients, from below the fan-out threshold to well above it.
+func BenchmarkFanOut(b *testing.B) {
+
+	server := newTestServer(b)
+
+	for _, size := range []int{16, fanOutThreshold, 1024, 8192} {
+		b.Run(fmt.Sprintf("recipients=%d", size), func(b *testing.B) {
+			recipients := make([]net.Conn, size)
+			for i := range recipients {
+				recipients[i] = newWireConn(discardConn{}, server.config)
+			}
+			b.Cleanup(func() {
+				for _, conn := range recipients {
+					conn.Close()
+				}
+			})
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				server.onHub(func() {
+					payload := newMessagePayload("[#bench] bench said: hello, everyone")
+					server.fanOut(recipients, payload)
+					payload.release()
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+//go:generate protoc --proto_path=chatpb --go_out=chatpb --go_opt=paths=source_relative --go-grpc_out=chatpb --go-grpc_opt=paths=source_relative chat.proto
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"server/chatpb"
+)
+
+// grpcChatService serves chat sessions over gRPC, handling each session like a TCP connection.
+type grpcChatService struct {
+	chatpb.UnimplementedChatServer
+	server *ChatServer
+}
+
+// grpcConn adapts a gRPC chat session to net.Conn, so gRPC clients are handled exactly like TCP clients.
+// Each client message reads as one line of the command protocol, and each line written is sent as one
+// server message.
+type grpcConn struct {
+	stream     chatpb.Chat_ConnectServer
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	lines      chan string // lines receives the commands read from the stream
+	pending    []byte      // pending is the unread remainder of the last command received
+	readErr    error       // readErr is the error that ended the stream, set before lines is closed
+
+	writeMutex sync.Mutex // writeMutex serializes sends, since a stream may only be sent on by one goroutine at a time
+	partial    []byte     // partial is a line written in pieces whose end has not been written yet
+	closed     chan struct{}
+	closeOnce  sync.Once
+}
+
+// grpcAddr stands in for a session address gRPC does not know.
+type grpcAddr string
+
+func (addr grpcAddr) Network() string { return "grpc" }
+func (addr grpcAddr) String() string  { return string(addr) }
+
+// serveGRPC runs the gRPC chat service, over TLS when TLS is configured. It runs for the lifetime of the server.
+func (server *ChatServer) serveGRPC() {
+
+	listen, err := net.Listen(TYPE, server.config.GRPCListen)
+	if err != nil {
+		fatal("Failed to start gRPC listener", "err", err)
+	}
+
+	var options []grpc.ServerOption
+	if server.tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(server.tlsConfig)))
+	}
+
+	grpcServer := grpc.NewServer(options...)
+	chatpb.RegisterChatServer(grpcServer, &grpcChatService{server: server})
+
+	slog.Info("Server accepting gRPC sessions", "addr", server.config.GRPCListen)
+	if err := grpcServer.Serve(listen); err != nil {
+		fatal("gRPC server failed", "err", err)
+	}
+}
+
+// Connect implements chatpb.ChatServer, serving a chat session until the client ends it or the server closes it.
+func (service *grpcChatService) Connect(stream chatpb.Chat_ConnectServer) error {
+
+	var localAddr, remoteAddr net.Addr = grpcAddr("unknown"), grpcAddr("unknown")
+	if client, exists := peer.FromContext(stream.Context()); exists {
+		if client.LocalAddr != nil {
+			localAddr = client.LocalAddr
+		}
+		if client.Addr != nil {
+			remoteAddr = client.Addr
+		}
+	}
+
+	conn := &grpcConn{stream: stream, localAddr: localAddr, remoteAddr: remoteAddr, lines: make(chan string), closed: make(chan struct{})}
+	go conn.receive()
+
+	service.server.serveConnection(conn)
+	return nil
+}
+
+// receive translates the client's messages into command lines until the stream ends or the session is closed.
+func (conn *grpcConn) receive() {
+
+	defer close(conn.lines)
+
+	for {
+		message, err := conn.stream.Recv()
+		if err != nil {
+			conn.readErr = err
+			return
+		}
+
+		line, valid := grpcCommandLine(message)
+		if !valid {
+			continue
+		}
+
+		select {
+
+			case conn.lines <- line:
+
+			case <-conn.closed:
+				return
+		}
+	}
+}
+
+// grpcCommandLine returns the line of the command protocol equivalent to a client message.
+func grpcCommandLine(message *chatpb.ClientMessage) (string, bool) {
+
+	switch command := message.Command.(type) {
+
+		case *chatpb.ClientMessage_Nick:
+			return NICK + " " + command.Nick.Nickname, true
+
+		case *chatpb.ClientMessage_Join:
+			return strings.TrimSpace(JOIN + " " + command.Join.Channel + " " + command.Join.Key), true
+
+		case *chatpb.ClientMessage_Part:
+			return PART + " " + command.Part.Channel, true
+
+		case *chatpb.ClientMessage_Send:
+			if command.Send.Action {
+				return withDedupID(command.Send.Dedup, ME+" "+command.Send.To+" "+command.Send.Text), true
+			}
+			return withDedupID(command.Send.Dedup, MSG+" "+command.Send.To+" "+command.Send.Text), true
+
+		case *chatpb.ClientMessage_List:
+			if command.List.Channels {
+				return LIST + " CHANNELS", true
+			}
+			return LIST, true
+
+		case *chatpb.ClientMessage_Raw:
+			return command.Raw.Line, true
+
+		default:
+			return "", false
+	}
+}
+
+// Read implements net.Conn, returning the client's commands as newline-terminated lines.
+func (conn *grpcConn) Read(p []byte) (int, error) {
+
+	for len(conn.pending) == 0 {
+		select {
+
+			case line, open := <-conn.lines:
+				if !open {
+					if conn.readErr == nil || errors.Is(conn.readErr, io.EOF) {
+						return 0, io.EOF
+					}
+					return 0, conn.readErr
+				}
+				// A command can't carry further commands on lines of its own
+				line, _, _ = strings.Cut(line, "\n")
+				conn.pending = []byte(line + "\n")
+
+			case <-conn.closed:
+				return 0, net.ErrClosed
+		}
+	}
+
+	n := copy(p, conn.pending)
+	conn.pending = conn.pending[n:]
+	return n, nil
+}
+
+// Write implements net.Conn, sending each complete line written as a server message.
+func (conn *grpcConn) Write(p []byte) (int, error) {
+
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	select {
+
+		case <-conn.closed:
+			return 0, net.ErrClosed
+
+		default:
+	}
+
+	conn.partial = append(conn.partial, p...)
+	for {
+		line, rest, complete := strings.Cut(string(conn.partial), "\n")
+		if !complete {
+			break
+		}
+		if err := conn.stream.Send(parseServerLine(line).serverMessage(line)); err != nil {
+			return 0, err
+		}
+		conn.partial = []byte(rest)
+	}
+	return len(p), nil
+}
+
+// Close implements net.Conn. The session's stream ends once its handler returns.
+func (conn *grpcConn) Close() error {
+
+	conn.closeOnce.Do(func() { close(conn.closed) })
+	return nil
+}
+
+// LocalAddr implements net.Conn, returning the address the client connected to.
+func (conn *grpcConn) LocalAddr() net.Addr {
+
+	return conn.localAddr
+}
+
+// RemoteAddr implements net.Conn, returning the client's address.
+func (conn *grpcConn) RemoteAddr() net.Addr {
+
+	return conn.remoteAddr
+}
+
+// SetDeadline implements net.Conn. Deadlines are not supported; idle sessions are closed by the keepalive instead.
+func (conn *grpcConn) SetDeadline(deadline time.Time) error {
+
+	return nil
+}
+
+// SetReadDeadline implements net.Conn. Deadlines are not supported.
+func (conn *grpcConn) SetReadDeadline(deadline time.Time) error {
+
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn. Deadlines are not supported.
+func (conn *grpcConn) SetWriteDeadline(deadline time.Time) error {
+
+	return nil
+}
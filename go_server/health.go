@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Health check paths served on the HTTP endpoint for orchestrators and load balancers.
+const (
+	healthzPath = "/healthz" // healthzPath reports whether the server is alive
+	readyzPath  = "/readyz"  // readyzPath reports whether the server is ready to take connections
+)
+
+// healthCheckTimeout is how long a health check waits for the hub or the storage before failing.
+const healthCheckTimeout = 2 * time.Second
+
+// hubResponds reports whether the hub goroutine runs a queued function within the timeout. Unlike onHub,
+// it gives up rather than waiting on a stalled hub, so it is safe to call from probes.
+func (server *ChatServer) hubResponds(timeout time.Duration) bool {
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	done := make(chan struct{})
+	select {
+
+		case server.hub <- func() { close(done) }:
+
+		case <-timer.C:
+			return false
+	}
+
+	select {
+
+		case <-done:
+			return true
+
+		case <-timer.C:
+			return false
+	}
+}
+
+// storageResponds checks that the storage can be read within the timeout.
+func (server *ChatServer) storageResponds(timeout time.Duration) error {
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := server.storage.LastMessageID()
+		result <- err
+	}()
+
+	select {
+
+		case err := <-result:
+			return err
+
+		case <-time.After(timeout):
+			return errors.New("timed out")
+	}
+}
+
+// listenersAccepting checks that connections are being accepted: every configured listener is open and
+// none is backing off after failed Accepts.
+func (server *ChatServer) listenersAccepting() error {
+
+	configured, accepting := server.configuredListeners.Load(), server.acceptLoops.Load()
+	if configured == 0 {
+		return errors.New("not listening")
+	}
+	if accepting < configured {
+		return fmt.Errorf("%d of %d listeners accepting", accepting, configured)
+	}
+	if failing := server.failingAccepts.Load(); failing > 0 {
+		return fmt.Errorf("%d listeners failing to accept", failing)
+	}
+	return nil
+}
+
+// handleHealthz answers liveness probes: the server is alive as long as its hub goroutine is responsive.
+func (server *ChatServer) handleHealthz(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writer.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	if !server.hubResponds(healthCheckTimeout) {
+		slog.Warn("Liveness check failed", "check", "hub")
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(writer, "hub: not responding")
+		return
+	}
+	fmt.Fprintln(writer, "ok")
+}
+
+// handleReadyz answers readiness probes, listing each check on its own line. The server is ready when its
+// listeners are accepting connections, its storage is reachable, and its hub goroutine is responsive.
+func (server *ChatServer) handleReadyz(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writer.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	checks := []struct {
+		name string
+		err  error
+	}{
+		{"listeners", server.listenersAccepting()},
+		{"storage", server.storageResponds(healthCheckTimeout)},
+		{"hub", nil},
+	}
+	if !server.hubResponds(healthCheckTimeout) {
+		checks[2].err = errors.New("not responding")
+	}
+
+	var report strings.Builder
+	status := http.StatusOK
+	for _, check := range checks {
+		if check.err != nil {
+			slog.Warn("Readiness check failed", "check", check.name, "err", check.err)
+			fmt.Fprintf(&report, "%s: %v\n", check.name, check.err)
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		fmt.Fprintf(&report, "%s: ok\n", check.name)
+	}
+
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(status)
+	fmt.Fprint(writer, report.String())
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// helpTopics summarizes the commands for /HELP, grouped by what they are for.
+var helpTopics = []string{
+	"Messaging: /MSG <nick|#channel|*>[,...] <text>, /ME <targets> <action>, /TYPING <targets> [active|paused|done]",
+	"Retries: prefix /MSG or /ME with @dedup=<id> and a message sent again with the same ID is acknowledged again instead of delivered twice",
+	"Channels: /JOIN <#channel> [key], /PART <#channel>, /LIST [CHANNELS], /NAMES <#channel>, /TOPIC <#channel> [topic], /KNOCK <#channel>",
+	"Channel operators: /MODE, /INVITE, /KICK <#channel> <nick> [reason], /VOICE, /DEVOICE, /CBAN, /CUNBAN",
+	"Users: /NICK <nickname>, /WHOIS, /WHO, /WHOWAS, /SEEN, /AWAY [message], /IGNORE, /UNIGNORE, /NOTIFY, /UNNOTIFY",
+	"Accounts: /REGISTER <password>, /LOGIN <nick> <password>, /GHOST <nick> <password>, /CERT, /SSO, /AUTH <token>, /RESUME <token> [last seq]",
+	"History: /HISTORY <#channel|nick> [count] [before id], /SEARCH <#channel> <words>, /EXPORT",
+	"Server: /UPTIME, /TIME, /VERSION, /MOTD, /TOP [DAY|WEEK], /PING <token>, /LAG, /CAP LS|LIST|REQ",
+	"Staff: /OPER, /KICK, /BAN, /UNBAN, /MUTE, /UNMUTE, /SHADOWBAN, /UNSHADOWBAN, /TOKEN, /ROLE, /ARCHIVE, /STATS",
+}
+
+// handleHelpCommand sends the requesting client a summary of the commands, the protocol's limits, and the form of its replies,
+// or the usage of one command, as registered.
+func (server *ChatServer) handleHelpCommand(conn net.Conn, name string) {
+
+	if name != "" {
+		if !strings.HasPrefix(name, "/") {
+			name = "/" + name
+		}
+		command, known := commandRegistry[normalizeCommandName(name)]
+		if !known {
+			sendReplyf(conn, errUnknownCommand, "No such command %s", normalizeCommandName(name))
+			return
+		}
+		sendReply(conn, rplHelp, describeCommand(command))
+		return
+	}
+
+	for _, topic := range helpTopics {
+		sendReply(conn, rplHelp, topic)
+	}
+	sendReplyf(conn, rplHelp, "Lines may be at most %d bytes; longer lines are rejected without being run", server.config.MaxLineLength)
+	sendReply(conn, rplHelp, "Replies start with a number and a name, such as 401 ERR_UNKNOWN_COMMAND; numbers from 400 are errors")
+	sendReplyf(conn, rplHelp, "Use %s <command> for the usage of a command; quote an argument holding spaces as \"like this\"", HELP)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultHistoryCount = 20  // defaultHistoryCount is the number of messages in a /HISTORY page when no count is given
+	maxHistoryCount     = 100 // maxHistoryCount is the largest page /HISTORY returns
+)
+
+// handleHistoryCommand sends a page of stored messages from a channel the user has joined, or from their
+// direct conversation with another nickname when they are logged in to their nickname's account. Pages run back from the latest message, or from the message
+// before a given ID, so clients can keep scrolling back.
+func (server *ChatServer) handleHistoryCommand(conn net.Conn, target string, pageArgs string) {
+
+	count := defaultHistoryCount
+	var beforeID int64
+
+	fields := strings.Fields(pageArgs)
+	if len(fields) >= 1 {
+		parsed, err := strconv.Atoi(fields[0])
+		if err != nil || parsed < 1 || parsed > maxHistoryCount {
+			sendReplyf(conn, errInvalidArgument, "Count must be a number from 1 to %d", maxHistoryCount)
+			return
+		}
+		count = parsed
+	}
+	if len(fields) >= 2 {
+		parsed, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || parsed < 1 {
+			sendReplyf(conn, errInvalidArgument, "Invalid message ID %s", fields[1])
+			return
+		}
+		beforeID = parsed
+	}
+
+	var nickname, account string
+	var registered, member bool
+	server.onHub(func() {
+		nickname, registered = server.users.lookup(conn)
+		channel, exists := server.channels[target]
+		member = exists && channel.isMember(conn)
+		if client, exists := server.clients[conn]; exists {
+			account = client.account
+		}
+	})
+
+	if !registered {
+		sendReply(conn, errNotRegistered, "You must register a nickname before you can read history")
+		return
+	}
+
+	// Stored history is read off the hub goroutine, since the storage may have to query a database
+	var messages []StoredMessage
+	var err error
+	if isChannelName(target) {
+		if !member {
+			sendReplyf(conn, errNotInChannel, "You're not in %s", target)
+			return
+		}
+		messages, err = server.storage.LoadHistory(target, beforeID, count)
+	} else {
+		// Conversations are stored by nickname, so only the owner of the nickname's account may read them back;
+		// anyone else could take the nickname once its owner disconnects
+		if account == "" || account != nickname {
+			sendReplyf(conn, errNotLoggedIn, "You must be logged in to the account %s with %s to read its private messages", nickname, LOGIN)
+			return
+		}
+		messages, err = server.storage.LoadConversation(nickname, target, beforeID, count)
+	}
+
+	if err != nil {
+		slog.Error("Failed to load history", "target", target, "nickname", nickname, "err", err)
+		sendReply(conn, errUnavailable, "History is unavailable right now; try again later")
+		return
+	}
+
+	if len(messages) == 0 {
+		sendReplyf(conn, rplEndOfHistory, "No more history for %s", target)
+		return
+	}
+
+	for _, message := range messages {
+		sendReplyf(conn, rplHistory, "%d [%s] %s", message.ID, message.SentAt.Format("Jan 2 15:04:05"), message.Body)
+	}
+
+	if len(messages) == count {
+		sendReplyf(conn, rplMoreHistory, "For earlier messages use %s %s %d %d", HISTORY, target, count, messages[0].ID)
+	}
+}
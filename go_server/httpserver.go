@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// websocketPath is the HTTP path WebSocket clients connect to.
+const websocketPath = "/ws"
+
+// serveHTTP runs the HTTP endpoint serving WebSocket clients, the HTTP API, the browser client, and the health
+// and readiness probes, over TLS when TLS is configured.
+// It runs for the lifetime of the server.
+func (server *ChatServer) serveHTTP() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(websocketPath, server.handleWebSocket)
+	mux.HandleFunc(apiMessagesPath, server.handlePostMessage)
+	mux.HandleFunc(apiStreamPath, server.handleStream)
+	mux.HandleFunc(healthzPath, server.handleHealthz)
+	mux.HandleFunc(readyzPath, server.handleReadyz)
+	mux.Handle("/", webUIHandler())
+
+	httpServer := &http.Server{Addr: server.config.HTTPListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	if server.tlsConfig == nil {
+		slog.Info("WebSocket clients can connect", "url", "ws://"+server.config.HTTPListen+websocketPath)
+		if err := httpServer.ListenAndServe(); err != nil {
+			fatal("HTTP server failed", "err", err)
+		}
+		return
+	}
+
+	// WebSockets are upgraded from HTTP/1.1 connections, so HTTP/2 is not offered
+	httpServer.TLSConfig = server.tlsConfig.Clone()
+	httpServer.TLSConfig.NextProtos = []string{"http/1.1"}
+
+	slog.Info("WebSocket clients can connect", "url", "wss://"+server.config.HTTPListen+websocketPath)
+	if err := httpServer.ListenAndServeTLS("", ""); err != nil {
+		fatal("HTTP server failed", "err", err)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+// The hub goroutine owns the server's shared state: the users and channels maps and the other fields
+// ChatServer documents as the hub's are read and written only by the functions it runs. Connection
+// goroutines, timers, and HTTP handlers send it the work that touches that state, such as a client
+// joining or leaving or a message being fanned out to its recipients, and wait for it to be done.
+// Work on the hub must not wait on clients: lines written to a client are queued for its writer goroutine.
+
+// runHub runs the work sent to the hub one at a time, for the lifetime of the server.
+func (server *ChatServer) runHub() {
+
+	for work := range server.hub {
+		work()
+	}
+}
+
+// onHub runs work on the hub goroutine and waits for it to finish. It must not be called on the hub goroutine.
+func (server *ChatServer) onHub(work func()) {
+
+	done := make(chan struct{})
+	server.hub <- func() {
+		defer close(done)
+		work()
+	}
+	<-done
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+)
+
+// keepAlive sends PING to a client that has sent nothing for the ping interval, and cancels the connection's lifecycle
+// when the PING goes unanswered for the ping timeout or the client sends nothing at all for the idle timeout,
+// so dead connections don't linger in the users map receiving messages no one reads. Any line the client sends,
+// not only the PONG, shows the connection is alive.
+func (server *ChatServer) keepAlive(ctx context.Context, conn net.Conn, cancel context.CancelFunc) {
+
+	for {
+		lastActive, pingSentAt, pingPending := server.keepAliveState(conn)
+		idle := time.Since(lastActive)
+
+		switch {
+
+			case server.config.IdleTimeout > 0 && idle >= server.config.IdleTimeout:
+				server.clientLog(conn).Info("Disconnecting idle client", "idle", idle.Round(time.Second))
+				sendReply(conn, errTimeout, "Idle timeout")
+				cancel()
+				return
+
+			case pingPending && time.Since(pingSentAt) >= server.config.PingTimeout:
+				server.clientLog(conn).Info("Disconnecting client that failed to answer PING")
+				sendReply(conn, errTimeout, "Ping timeout")
+				cancel()
+				return
+
+			case !pingPending && server.config.PingInterval > 0 && idle >= server.config.PingInterval:
+				server.sendPing(conn)
+				continue
+		}
+
+		// Sleep until the earliest moment one of the cases above could apply
+		wait := time.Duration(math.MaxInt64)
+		if server.config.IdleTimeout > 0 {
+			wait = server.config.IdleTimeout - idle
+		}
+		if pingPending {
+			wait = min(wait, server.config.PingTimeout-time.Since(pingSentAt))
+		} else if server.config.PingInterval > 0 {
+			wait = min(wait, server.config.PingInterval-idle)
+		}
+
+		if !sleepContext(ctx, wait) {
+			return
+		}
+	}
+}
+
+// keepAliveState returns when a client last sent anything, when it was last sent a PING, and whether that PING
+// is still waiting for the client to send something.
+func (server *ChatServer) keepAliveState(conn net.Conn) (time.Time, time.Time, bool) {
+
+	lastActive, pingSentAt, awaitingPong := time.Now(), time.Time{}, false
+	server.onHub(func() {
+		if client, exists := server.clients[conn]; exists {
+			lastActive, pingSentAt = client.lastActive, client.pingSentAt
+			awaitingPong = client.pingToken != "" && !client.lastActive.After(client.pingSentAt)
+		}
+	})
+	return lastActive, pingSentAt, awaitingPong
+}
+
+// sendPing sends a PING with a fresh token to a client and records when it was sent.
+func (server *ChatServer) sendPing(conn net.Conn) {
+
+	token := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	server.onHub(func() {
+		if client, exists := server.clients[conn]; exists {
+			client.pingToken = token
+			client.pingSentAt = time.Now()
+		}
+	})
+
+	fmt.Fprintf(conn, "PING %s\n", token)
+}
+
+// handlePongCommand records a client's answer to a server PING and measures the round-trip latency.
+func (server *ChatServer) handlePongCommand(conn net.Conn, token string) {
+
+	server.onHub(func() {
+		client, exists := server.clients[conn]
+		if !exists || client.pingToken == "" || client.pingToken != token {
+			return
+		}
+
+		client.lag = time.Since(client.pingSentAt)
+		client.pingToken = ""
+	})
+}
+
+// handlePingCommand answers a client-initiated PING immediately, echoing its token
+// so the client can measure the round-trip time itself.
+func (server *ChatServer) handlePingCommand(conn net.Conn, token string) {
+
+	fmt.Fprintf(conn, "PONG %s\n", token)
+}
+
+// handleLagCommand reports the round-trip latency measured by the most recently answered server PING.
+func (server *ChatServer) handleLagCommand(conn net.Conn) {
+
+	server.onHub(func() {
+		client, exists := server.clients[conn]
+		if !exists || client.lag == 0 {
+			sendReply(conn, rplLag, "No latency measurement yet")
+			return
+		}
+
+		sendReplyf(conn, rplLag, "Your latency is %s", client.lag.Round(time.Millisecond))
+	})
+}
+
+// sleepContext waits for the given duration, returning false if the context is done first.
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+
+		case <-ctx.Done():
+			return false
+
+		case <-timer.C:
+			return true
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// connLifecycle coordinates the auxiliary goroutines serving a single client connection
+// (heartbeats, writers, timers) so that all of them stop when the connection closes.
+type connLifecycle struct {
+	ctx     context.Context    // ctx is canceled when the connection is closing
+	cancel  context.CancelFunc // cancel ends the connection's lifetime
+	workers sync.WaitGroup     // workers tracks goroutines started with Go
+}
+
+// newConnLifecycle returns a lifecycle whose context lives until Stop or cancel is called.
+func newConnLifecycle() *connLifecycle {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &connLifecycle{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Go runs fn in a new goroutine tied to the connection. fn must return once its context is done.
+func (lifecycle *connLifecycle) Go(fn func(ctx context.Context)) {
+
+	lifecycle.workers.Add(1)
+	go func() {
+		defer lifecycle.workers.Done()
+		fn(lifecycle.ctx)
+	}()
+}
+
+// Stop cancels the connection's context and waits for every auxiliary goroutine to return.
+func (lifecycle *connLifecycle) Stop() {
+
+	lifecycle.cancel()
+	lifecycle.workers.Wait()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// defaultMaxLineLength is the default longest line a client may send, the same as bufio.Scanner's limit.
+const defaultMaxLineLength = bufio.MaxScanTokenSize
+
+// readLine reads the next line from a client, without its line ending. A line longer than maxLength bytes is
+// read to its end and discarded, and reported as too long, so that one oversize line doesn't end the session
+// the way it ends a bufio.Scanner. A last line without a line ending is returned before io.EOF.
+func readLine(reader *bufio.Reader, maxLength int) ([]byte, bool, error) {
+
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong && len(line)+len(bytes.TrimRight(chunk, "\r\n")) > maxLength {
+			tooLong = true
+			line = nil
+		}
+		if !tooLong {
+			line = append(line, chunk...)
+		}
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil && (!errors.Is(err, io.EOF) || (len(line) == 0 && !tooLong)) {
+			return nil, false, err
+		}
+		break
+	}
+
+	return bytes.TrimRight(line, "\r\n"), tooLong, nil
+}
+
+// lineTooLongMessage is the protocol error sent to a client for a line longer than the limit.
+func lineTooLongMessage(maxLength int) string {
+
+	return fmt.Sprintf("Line too long: lines may be at most %d bytes", maxLength)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Link filter actions, chosen with the -link-filter-action flag.
+const (
+	LinkFilterBlock = "block" // LinkFilterBlock refuses messages containing blocked links
+	LinkFilterFlag  = "flag"  // LinkFilterFlag delivers such messages but reports them to moderators
+)
+
+// linkPattern matches URLs with an explicit scheme or a leading "www.".
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// loadLinkBlocklist reads the blocked link domains from the configured file, one per line.
+// Blank lines and lines starting with '#' are ignored.
+func (server *ChatServer) loadLinkBlocklist() error {
+
+	if server.config.LinkBlocklistFile == "" {
+		return nil
+	}
+
+	file, err := os.Open(server.config.LinkBlocklistFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	blockedDomains := make(map[string]bool)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		domain := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if domain == "" || strings.HasPrefix(domain, "#") {
+			continue
+		}
+		blockedDomains[strings.TrimPrefix(domain, ".")] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	server.onHub(func() {
+		server.blockedDomains = blockedDomains
+	})
+
+	slog.Info("Loaded blocked link domains", "count", len(blockedDomains), "path", server.config.LinkBlocklistFile)
+	return nil
+}
+
+// findBlockedLink returns the host of the first link in a message whose domain, or any parent domain,
+// is on the blocklist. It must run on the hub goroutine.
+func (server *ChatServer) findBlockedLink(message string) (string, bool) {
+
+	if len(server.blockedDomains) == 0 {
+		return "", false
+	}
+
+	for _, link := range linkPattern.FindAllString(message, -1) {
+		if !strings.Contains(link, "://") {
+			link = "http://" + link
+		}
+
+		parsed, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+
+		host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+		for domain := host; domain != ""; {
+			if server.blockedDomains[domain] {
+				return host, true
+			}
+
+			_, parent, found := strings.Cut(domain, ".")
+			if !found {
+				break
+			}
+			domain = parent
+		}
+	}
+
+	return "", false
+}
+
+// filterLinks applies the link filter to a message about to be sent to recipients. It returns false
+// if the message must not be delivered. In flag mode the message is allowed but moderators are told about it.
+func (server *ChatServer) filterLinks(conn net.Conn, recipients string, message string) bool {
+
+	allowed := true
+	server.onHub(func() {
+		var host string
+		host, allowed = server.screenLinks(conn, server.users.nickname(conn), recipients, message)
+		if !allowed {
+			sendReplyf(conn, errBlockedLink, "Message not sent: links to %s are not allowed", host)
+		}
+	})
+	return allowed
+}
+
+// screenLinks is the link filter behind filterLinks, for senders with or without a connection. It returns
+// the blocked host a message links to and whether the message may still be delivered, telling moderators
+// other than the sender about it in flag mode. It must run on the hub goroutine.
+func (server *ChatServer) screenLinks(sender net.Conn, senderNickname string, recipients string, message string) (string, bool) {
+
+	host, blocked := server.findBlockedLink(message)
+	if !blocked {
+		return "", true
+	}
+
+	slog.Warn("Message linked to a blocked domain", "nickname", senderNickname, "domain", host)
+	if server.config.LinkFilterAction != LinkFilterFlag {
+		return host, false
+	}
+
+	for moderatorConn := range server.clients {
+		if moderatorConn != sender && server.hasPermission(moderatorConn, PermViewAddresses) {
+			sendReplyf(moderatorConn, rplLinkFlagged, "Link filter: %s linked to blocked domain %s in a message to %s: %s", senderNickname, host, recipients, message)
+		}
+	}
+	return host, true
+}
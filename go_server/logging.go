@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+)
+
+// Log output formats, chosen with the -log-format flag.
+const (
+	LogFormatText = "text" // LogFormatText writes each record as key=value pairs
+	LogFormatJSON = "json" // LogFormatJSON writes each record as a JSON object, for log aggregation
+)
+
+// setUpLogging makes the configured handler the default slog logger, writing records at or above the
+// configured level to standard error. The log package writes through it as well, at error level, so what
+// libraries log is formatted like everything else.
+func setUpLogging(config Config) {
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.LogLevel)); err != nil {
+		fatal("Invalid -log-level; must be debug, info, warn, or error", "level", config.LogLevel)
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch config.LogFormat {
+
+		case LogFormatText:
+			handler = slog.NewTextHandler(os.Stderr, options)
+
+		case LogFormatJSON:
+			handler = slog.NewJSONHandler(os.Stderr, options)
+
+		default:
+			fatal("Invalid -log-format; must be "+LogFormatText+" or "+LogFormatJSON, "format", config.LogFormat)
+	}
+	slog.SetDefault(slog.New(handler))
+	slog.SetLogLoggerLevel(slog.LevelError)
+}
+
+// fatal logs an error that leaves the server unable to run, with the given attributes, and exits.
+func fatal(msg string, args ...any) {
+
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// clientLog returns a logger whose records identify a client by its remote address, and by its nickname
+// once it has one.
+func (server *ChatServer) clientLog(conn net.Conn) *slog.Logger {
+
+	if nickname, registered := server.users.lookup(conn); registered {
+		return slog.With("remote", conn.RemoteAddr().String(), "nickname", nickname)
+	}
+	return slog.With("remote", conn.RemoteAddr().String())
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// loadMotd reads the message of the day from the configured file, replacing any previously loaded one.
+// A missing file simply leaves the server without a message of the day.
+func (server *ChatServer) loadMotd() error {
+
+	if server.config.MotdFile == "" {
+		return nil
+	}
+
+	var motd []string
+
+	data, err := os.ReadFile(server.config.MotdFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		motd = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+
+	server.onHub(func() {
+		server.motd = motd
+	})
+
+	return nil
+}
+
+// reloadMotdOnSignal reloads the message of the day whenever the process receives SIGHUP,
+// so it can be changed without restarting the server. It runs for the lifetime of the server.
+func (server *ChatServer) reloadMotdOnSignal() {
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	for range hangups {
+		if err := server.loadMotd(); err != nil {
+			slog.Error("Failed to reload message of the day", "err", err)
+			continue
+		}
+		slog.Info("Reloaded message of the day", "path", server.config.MotdFile)
+	}
+}
+
+// sendMotd sends the message of the day to a client. It must run on the hub goroutine.
+func (server *ChatServer) sendMotd(conn net.Conn) {
+
+	if len(server.motd) == 0 {
+		sendReply(conn, errNoMotd, "No message of the day")
+		return
+	}
+
+	sendReply(conn, rplMotdStart, "--- Message of the day ---")
+	for _, line := range server.motd {
+		sendReply(conn, rplMotd, line)
+	}
+	sendReply(conn, rplMotdEnd, "--- End of message of the day ---")
+}
+
+// handleMotdCommand sends the message of the day to the requesting client.
+func (server *ChatServer) handleMotdCommand(conn net.Conn) {
+
+	server.onHub(func() {
+		server.sendMotd(conn)
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"time"
+)
+
+// mute silences a nickname until it expires; muted users can still read messages.
+type mute struct {
+	expiresAt time.Time   // expiresAt is when the mute is lifted
+	timer     *time.Timer // timer lifts the mute when it fires
+}
+
+// handleMuteCommand lets a moderator silence a user for a number of minutes.
+func (server *ChatServer) handleMuteCommand(conn net.Conn, nickname string, minutes string) {
+
+	muteMinutes, err := strconv.Atoi(minutes)
+	if err != nil || muteMinutes < 1 {
+		sendReply(conn, errUsage, "Usage: /MUTE <nick> <minutes>")
+		return
+	}
+	duration := time.Duration(muteMinutes) * time.Minute
+
+	server.onHub(func() {
+		targetConn, found := server.findUserByNickname(nickname)
+		if !found {
+			sendReplyf(conn, errNoSuchNick, "No such user %s", nickname)
+			return
+		}
+		if !server.outranks(conn, targetConn, "mute") {
+			return
+		}
+
+		if existing, muted := server.mutes[nickname]; muted {
+			existing.timer.Stop()
+		}
+
+		entry := &mute{expiresAt: time.Now().Add(duration)}
+		entry.timer = time.AfterFunc(duration, func() {
+			server.expireMute(entry)
+		})
+		server.mutes[nickname] = entry
+
+		sendReplyf(conn, rplMute, "Muted %s for %s", nickname, duration)
+		sendReplyf(targetConn, rplMuted, "You were muted by %s for %s", server.users.nickname(conn), duration)
+		server.clientLog(conn).Info("Muted user", "target", nickname, "duration", duration)
+	})
+}
+
+// handleUnmuteCommand lets a moderator lift a user's mute early.
+func (server *ChatServer) handleUnmuteCommand(conn net.Conn, nickname string) {
+
+	server.onHub(func() {
+		entry, muted := server.mutes[nickname]
+		if !muted {
+			sendReplyf(conn, errNoChange, "%s is not muted", nickname)
+			return
+		}
+
+		entry.timer.Stop()
+		delete(server.mutes, nickname)
+
+		sendReplyf(conn, rplMute, "Unmuted %s", nickname)
+		if targetConn, online := server.findUserByNickname(nickname); online {
+			sendReplyf(targetConn, rplUnmuted, "You were unmuted by %s", server.users.nickname(conn))
+		}
+	})
+}
+
+// expireMute lifts a mute when its timer fires, telling the user if they are online.
+func (server *ChatServer) expireMute(entry *mute) {
+
+	server.onHub(func() {
+		// The mute may have been replaced, lifted, or moved to a new nickname since the timer started
+		for nickname, current := range server.mutes {
+			if current != entry {
+				continue
+			}
+
+			delete(server.mutes, nickname)
+			if targetConn, online := server.findUserByNickname(nickname); online {
+				sendReply(targetConn, rplUnmuted, "You are no longer muted")
+			}
+		}
+	})
+}
+
+// muteRemaining returns how long a nickname remains muted, or zero if it isn't muted.
+// It must run on the hub goroutine.
+func (server *ChatServer) muteRemaining(nickname string) time.Duration {
+
+	entry, muted := server.mutes[nickname]
+	if !muted {
+		return 0
+	}
+	return time.Until(entry.expiresAt)
+}
+
+// moveMute carries a mute over when a muted user changes nickname. It must run on the hub goroutine.
+func (server *ChatServer) moveMute(oldNickname string, newNickname string) {
+
+	if entry, muted := server.mutes[oldNickname]; muted {
+		delete(server.mutes, oldNickname)
+		server.mutes[newNickname] = entry
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// isNicknameProtected reports whether a nickname belongs to an account the client has not logged in to.
+// It must run on the hub goroutine.
+func (server *ChatServer) isNicknameProtected(conn net.Conn, nickname string) bool {
+
+	if _, registered := server.accounts.Get(nickname); !registered {
+		return false
+	}
+	client, exists := server.clients[conn]
+	return !exists || client.account != nickname
+}
+
+// enforceNickname warns a user who has taken someone else's registered nickname and renames them
+// once the grace period passes unless they log in to it first. It must run on the hub goroutine.
+func (server *ChatServer) enforceNickname(conn net.Conn, nickname string) {
+
+	client := server.clients[conn]
+	if client.nickTimer != nil {
+		client.nickTimer.Stop()
+	}
+
+	sendReplyf(conn, rplLoginRequired, "%s is a registered nickname; log in with %s %s <password> within %s or you will be renamed",
+		nickname, LOGIN, nickname, server.config.NickGracePeriod)
+
+	client.nickTimer = time.AfterFunc(server.config.NickGracePeriod, func() {
+		server.renameToGuest(conn, func() bool {
+			// The user may have logged in, changed nickname, or disconnected in the meantime
+			if server.users.nickname(conn) != nickname || !server.isNicknameProtected(conn, nickname) {
+				return false
+			}
+
+			sendReplyf(conn, errTimeout, "You did not log in to %s in time", nickname)
+			server.clientLog(conn).Info("Renaming user who did not log in to the registered nickname")
+			return true
+		})
+	})
+}
+
+// maxGuestAttempts is how many guest nicknames are tried before giving up on finding a free one.
+const maxGuestAttempts = 100
+
+// renameToGuest gives a connection a guest nickname, provided due still reports true when checked on the hub
+// goroutine once the nickname has been claimed. A user who can't be given one keeps no nickname: one yet to
+// pick a nickname is asked to, and anyone else is disconnected. It must not be called on the hub goroutine.
+func (server *ChatServer) renameToGuest(conn net.Conn, due func() bool) {
+
+	nickname, err := server.guestNickname()
+	if err != nil {
+		server.onHub(func() {
+			if !due() {
+				return
+			}
+			server.clientLog(conn).Error("Failed to pick a guest nickname", "err", err)
+			if _, registered := server.users.lookup(conn); !registered {
+				sendReplyf(conn, errUnavailable, "No guest nickname is free; choose a nickname with %s <nickname>", NICK)
+				return
+			}
+			sendReply(conn, errUnavailable, "No guest nickname is free to rename you to")
+			server.clients[conn].lifecycle.cancel()
+		})
+		return
+	}
+
+	claim := server.claimNickname(nickname)
+	server.onHubWithClaim(claim, func() {
+		if due() {
+			server.setNickname(conn, claim)
+		}
+	})
+}
+
+// guestNickname picks an unused nickname of the form guest_NNNN, failing once maxGuestAttempts have been taken.
+// It looks each one up in the account store, which may be remote, so it must not be called on the hub goroutine.
+func (server *ChatServer) guestNickname() (string, error) {
+
+	for range maxGuestAttempts {
+		nickname := fmt.Sprintf("guest_%04d", rand.Intn(10000))
+		if _, inUse := server.findUserByNickname(nickname); inUse {
+			continue
+		}
+		if _, registered := server.accounts.Get(nickname); registered {
+			continue
+		}
+		return nickname, nil
+	}
+	return "", fmt.Errorf("no free guest nickname found in %d attempts", maxGuestAttempts)
+}
+
+// handleGhostCommand lets the owner of a registered nickname disconnect a stale or impostor session holding it.
+// The owner is then logged in and takes the nickname.
+func (server *ChatServer) handleGhostCommand(conn net.Conn, nickname string, password string) {
+
+	err := server.accounts.Authenticate(nickname, password)
+	if errors.Is(err, ErrNoSuchAccount) {
+		sendReplyf(conn, errNoSuchAccount, "%s is not a registered nickname", nickname)
+		return
+	}
+	if errors.Is(err, ErrAuthUnavailable) {
+		sendReply(conn, errUnavailable, "Logins are unavailable right now; try again later")
+		return
+	}
+
+	// The nickname is only claimed once the password has been checked
+	claim := nicknameClaim{nickname: nickname}
+	if err == nil {
+		claim = server.claimNickname(nickname)
+	}
+
+	server.onHubWithClaim(claim, func() {
+		client, connected := server.clients[conn]
+		if !connected {
+			return
+		}
+
+		if err != nil {
+			server.clientLog(conn).Warn("Failed /GHOST attempt", "target", nickname)
+			sendReplyf(conn, errPasswordMismatch, "Incorrect password for %s", nickname)
+			server.recordFailure(conn, "a failed /GHOST attempt")
+			return
+		}
+
+		ghostConn, inUse := server.findUserByNickname(nickname)
+		if !inUse {
+			sendReplyf(conn, errNoSuchNick, "%s is not in use", nickname)
+			return
+		}
+		if ghostConn == conn {
+			sendReply(conn, errInvalidTarget, "You can't ghost your own session")
+			return
+		}
+
+		sendReplyf(ghostConn, errGhosted, "This session was disconnected by the owner of %s", nickname)
+		payload := newMessagePayload(fmt.Sprintf("%s left the chat (ghosted)", nickname))
+		server.users.each(func(userConn net.Conn, _ string) bool {
+			if userConn != ghostConn && userConn != conn {
+				server.deliverPayload(userConn, payload)
+			}
+			return true
+		})
+		payload.release()
+
+		server.clientLog(conn).Info("Ghosted user", "target", nickname, "target_remote", ghostConn.RemoteAddr().String())
+
+		// The ghost's nickname is released right away rather than when its connection finishes closing,
+		// so the owner can take it immediately
+		server.disconnectClient(ghostConn)
+		server.removeUser(ghostConn)
+
+		client.account = nickname
+		sendReplyf(conn, rplLoggedIn, "Ghosted %s; you are now logged in", nickname)
+		server.deliverOfflineMessages(conn, nickname)
+		server.setNickname(conn, claim)
+	})
+}
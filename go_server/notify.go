@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// handleNotifyCommand adds a nickname to, or removes it from, the client's watch list, so the client is
+// told when that nickname comes online or goes offline. With no nickname it lists the watched nicknames.
+func (server *ChatServer) handleNotifyCommand(conn net.Conn, nickname string, watch bool) {
+
+	server.onHub(func() {
+		if nickname == "" {
+			var nicknames []string
+			for watchedNickname, watchers := range server.watchers {
+				if watchers[conn] {
+					nicknames = append(nicknames, watchedNickname)
+				}
+			}
+			sort.Strings(nicknames)
+
+			if len(nicknames) == 0 {
+				sendReply(conn, rplNotifyList, "Your notify list is empty")
+			} else {
+				sendReplyf(conn, rplNotifyList, "Notify list: %s", strings.Join(nicknames, " "))
+			}
+			return
+		}
+
+		if !watch {
+			if !server.watchers[nickname][conn] {
+				sendReplyf(conn, errNoChange, "%s is not on your notify list", nickname)
+				return
+			}
+
+			delete(server.watchers[nickname], conn)
+			if len(server.watchers[nickname]) == 0 {
+				delete(server.watchers, nickname)
+			}
+			sendReplyf(conn, rplNotify, "Removed %s from your notify list", nickname)
+			return
+		}
+
+		if server.watchers[nickname] == nil {
+			server.watchers[nickname] = make(map[net.Conn]bool)
+		}
+		server.watchers[nickname][conn] = true
+
+		if _, online := server.findUserByNickname(nickname); online {
+			sendReplyf(conn, rplNotify, "Added %s to your notify list (currently online)", nickname)
+		} else {
+			sendReplyf(conn, rplNotify, "Added %s to your notify list (currently offline)", nickname)
+		}
+	})
+}
+
+// notifyWatchers tells every client watching a nickname that it has come online or gone offline.
+// It must run on the hub goroutine.
+func (server *ChatServer) notifyWatchers(nickname string, online bool) {
+
+	status := "went offline"
+	if online {
+		status = "is now online"
+	}
+
+	for watcher := range server.watchers[nickname] {
+		server.deliver(watcher, fmt.Sprintf("Notify: %s %s", nickname, status))
+	}
+}
+
+// removeWatcher drops a connection from every watch list. It must run on the hub goroutine.
+func (server *ChatServer) removeWatcher(conn net.Conn) {
+
+	for nickname, watchers := range server.watchers {
+		delete(watchers, conn)
+		if len(watchers) == 0 {
+			delete(server.watchers, nickname)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+)
+
+// offlineMessage is a direct message held for a registered nickname that was offline when it was sent.
+type offlineMessage struct {
+	Sender string    `json:"sender"`
+	Text   string    `json:"text"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+// offlineMessageExpired reports whether a held message is older than the configured expiry.
+func (server *ChatServer) offlineMessageExpired(message offlineMessage) bool {
+
+	return server.config.OfflineExpiry > 0 && time.Since(message.SentAt) > server.config.OfflineExpiry
+}
+
+// loadOfflineMessages reads the held offline messages from the configured file. A missing file is not an error.
+func (server *ChatServer) loadOfflineMessages() error {
+
+	if server.config.OfflineFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(server.config.OfflineFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	server.onHub(func() {
+		err = json.Unmarshal(data, &server.offline)
+	})
+	return err
+}
+
+// saveOfflineMessages writes the held offline messages to the configured file. It must run on the hub goroutine.
+func (server *ChatServer) saveOfflineMessages() {
+
+	if server.config.OfflineFile == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(server.offline, "", "  ")
+	if err != nil {
+		slog.Error("Failed to encode offline messages", "err", err)
+		return
+	}
+
+	if err := writeFileAtomic(server.config.OfflineFile, data); err != nil {
+		slog.Error("Failed to save offline messages", "err", err)
+	}
+}
+
+// holdOfflineMessage queues a direct message for an offline registered nickname, telling the sender
+// whether it was queued. It returns the NACK code of a message that was not queued, or an empty string.
+// It must run on the hub goroutine.
+func (server *ChatServer) holdOfflineMessage(conn net.Conn, recipient string, text string) string {
+
+	if server.config.OfflineQueueSize <= 0 {
+		return nackNoSuchNick
+	}
+	if _, registered := server.accounts.Get(recipient); !registered {
+		return nackNoSuchNick
+	}
+
+	senderNickname := server.users.nickname(conn)
+
+	// Shadow-banned users are told their message was queued, but it is not
+	if !server.shadowBans[senderNickname] {
+		var queue []offlineMessage
+		for _, message := range server.offline[recipient] {
+			if !server.offlineMessageExpired(message) {
+				queue = append(queue, message)
+			}
+		}
+
+		if len(queue) >= server.config.OfflineQueueSize {
+			sendReplyf(conn, errQueueFull, "%s is offline and has too many messages waiting; try again later", recipient)
+			return nackQueueFull
+		}
+
+		server.offline[recipient] = append(queue, offlineMessage{Sender: senderNickname, Text: text, SentAt: time.Now()})
+		server.saveOfflineMessages()
+	}
+
+	sendReplyf(conn, rplMessageHeld, "%s is offline; your message will be delivered when they return", recipient)
+	return ""
+}
+
+// deliverOfflineMessages sends a client that has just logged in to an account the messages held for it.
+// It must run on the hub goroutine.
+func (server *ChatServer) deliverOfflineMessages(conn net.Conn, nickname string) {
+
+	queue, exists := server.offline[nickname]
+	if !exists {
+		return
+	}
+	delete(server.offline, nickname)
+	server.saveOfflineMessages()
+
+	var messages []offlineMessage
+	for _, message := range queue {
+		if !server.offlineMessageExpired(message) {
+			messages = append(messages, message)
+		}
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	sendReplyf(conn, rplOfflineStart, "--- %d message(s) while you were away ---", len(messages))
+	for _, message := range messages {
+		sendReplyf(conn, rplOfflineMessage, "[%s] %s", message.SentAt.Format("Jan 2 15:04:05"), message.Text)
+	}
+	sendReply(conn, rplOfflineEnd, "--- end of messages ---")
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcCallbackPath is the path of the HTTP endpoint the identity provider redirects users back to.
+const oidcCallbackPath = "/oidc/callback"
+
+// ssoLogin is a pending /SSO login waiting for the identity provider to redirect the user back.
+type ssoLogin struct {
+	conn      net.Conn  // conn is the chat session the verified identity will be bound to
+	nonce     string    // nonce must be echoed in the ID token, tying it to this login
+	expiresAt time.Time // expiresAt is when the login URL stops being accepted
+}
+
+// oidcProvider holds the endpoints and signing keys of the configured OpenID Connect identity provider,
+// discovered from the issuer on first use.
+type oidcProvider struct {
+	issuer     string
+	httpClient *http.Client
+
+	mutex                 sync.Mutex                // mutex protects access to all of the fields below
+	authorizationEndpoint string                    // authorizationEndpoint is where users are sent to log in
+	tokenEndpoint         string                    // tokenEndpoint is where authorization codes are exchanged for tokens
+	jwksURI               string                    // jwksURI is where the provider publishes its signing keys
+	keys                  map[string]*rsa.PublicKey // keys maps key IDs to the provider's token signing keys
+}
+
+// idTokenClaims are the ID token claims the server checks or uses.
+type idTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	ExpiresAt         int64    `json:"exp"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// audience is the ID token "aud" claim, which may be a single string or a list.
+type audience []string
+
+// UnmarshalJSON accepts both forms of the "aud" claim.
+func (aud *audience) UnmarshalJSON(data []byte) error {
+
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = audience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(aud))
+}
+
+// newOIDCProvider creates a provider for the given issuer URL. Nothing is fetched until it is first used.
+func newOIDCProvider(issuer string) *oidcProvider {
+
+	return &oidcProvider{
+		issuer:     strings.TrimSuffix(issuer, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// discover fetches the provider's endpoints and signing keys unless they are already known.
+func (provider *oidcProvider) discover() error {
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.tokenEndpoint != "" {
+		return nil
+	}
+
+	var metadata struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := provider.getJSON(provider.issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return fmt.Errorf("discovery: %w", err)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return errors.New("discovery: provider metadata is incomplete")
+	}
+
+	provider.authorizationEndpoint = metadata.AuthorizationEndpoint
+	provider.tokenEndpoint = metadata.TokenEndpoint
+	provider.jwksURI = metadata.JWKSURI
+
+	return provider.refreshKeys()
+}
+
+// refreshKeys re-fetches the provider's RSA signing keys. The caller must hold provider.mutex.
+func (provider *oidcProvider) refreshKeys() error {
+
+	var jwks struct {
+		Keys []struct {
+			KeyType  string `json:"kty"`
+			KeyID    string `json:"kid"`
+			Modulus  string `json:"n"`
+			Exponent string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := provider.getJSON(provider.jwksURI, &jwks); err != nil {
+		return fmt.Errorf("fetching signing keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.KeyType != "RSA" {
+			continue
+		}
+
+		modulus, err := base64.RawURLEncoding.DecodeString(jwk.Modulus)
+		if err != nil {
+			continue
+		}
+		exponent, err := base64.RawURLEncoding.DecodeString(jwk.Exponent)
+		if err != nil {
+			continue
+		}
+
+		keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}
+	}
+
+	provider.keys = keys
+	return nil
+}
+
+// signingKey returns the key with the given ID, re-fetching the key set once if the provider has rotated keys.
+func (provider *oidcProvider) signingKey(keyID string) (*rsa.PublicKey, error) {
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if key, found := provider.keys[keyID]; found {
+		return key, nil
+	}
+
+	if err := provider.refreshKeys(); err != nil {
+		return nil, err
+	}
+	if key, found := provider.keys[keyID]; found {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", keyID)
+}
+
+// getJSON fetches a URL and decodes its JSON body into target.
+func (provider *oidcProvider) getJSON(url string, target any) error {
+
+	response, err := provider.httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+// exchangeCode trades an authorization code for the user's ID token at the token endpoint.
+func (provider *oidcProvider) exchangeCode(config Config, code string) (string, error) {
+
+	provider.mutex.Lock()
+	tokenEndpoint := provider.tokenEndpoint
+	provider.mutex.Unlock()
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {config.OIDCRedirectURL},
+	}
+
+	request, err := http.NewRequest(http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(url.QueryEscape(config.OIDCClientID), url.QueryEscape(config.OIDCClientSecret))
+
+	response, err := provider.httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s", response.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return "", err
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("token response has no ID token")
+	}
+	return tokens.IDToken, nil
+}
+
+// verifyIDToken checks an RS256-signed ID token's signature, issuer, audience, expiry, and nonce,
+// returning its claims when it is valid.
+func (provider *oidcProvider) verifyIDToken(idToken string, clientID string, nonce string) (*idTokenClaims, error) {
+
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeTokenSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", header.Algorithm)
+	}
+
+	key, err := provider.signingKey(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("invalid signature")
+	}
+
+	var claims idTokenClaims
+	if err := decodeTokenSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decoding claims: %w", err)
+	}
+
+	switch {
+
+		case claims.Issuer != provider.issuer:
+			return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+
+		case !slices.Contains(claims.Audience, clientID):
+			return nil, errors.New("token was not issued for this server")
+
+		case time.Now().After(time.Unix(claims.ExpiresAt, 0)):
+			return nil, errors.New("token has expired")
+
+		case claims.Nonce != nonce:
+			return nil, errors.New("nonce mismatch")
+
+		case claims.Subject == "":
+			return nil, errors.New("token has no subject")
+	}
+
+	return &claims, nil
+}
+
+// decodeTokenSegment decodes one base64url-encoded JSON segment of a JWT.
+func decodeTokenSegment(segment string, target any) error {
+
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// randomToken returns a random hex string suitable for OAuth state and nonce values.
+func randomToken() (string, error) {
+
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
+
+// handleSSOCommand starts a single sign-on login, giving the user a short-lived URL to log in
+// with the configured identity provider.
+func (server *ChatServer) handleSSOCommand(conn net.Conn) {
+
+	if server.oidc == nil {
+		sendReply(conn, errDisabled, "Single sign-on is not enabled on this server")
+		return
+	}
+
+	if err := server.oidc.discover(); err != nil {
+		slog.Error("Failed to contact identity provider", "err", err)
+		sendReply(conn, errUnavailable, "The identity provider is unavailable; please try again later")
+		return
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		sendReply(conn, errUnavailable, "Failed to start login; please try again")
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		sendReply(conn, errUnavailable, "Failed to start login; please try again")
+		return
+	}
+
+	server.onHub(func() {
+		server.expireSSOLogins()
+		server.ssoLogins[state] = &ssoLogin{conn: conn, nonce: nonce, expiresAt: time.Now().Add(server.config.OIDCLoginTimeout)}
+	})
+
+	server.oidc.mutex.Lock()
+	authorizationEndpoint := server.oidc.authorizationEndpoint
+	server.oidc.mutex.Unlock()
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {server.config.OIDCClientID},
+		"redirect_uri":  {server.config.OIDCRedirectURL},
+		"scope":         {"openid email profile"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+
+	sendReplyf(conn, rplSSOURL, "Log in within %s at: %s?%s", server.config.OIDCLoginTimeout, authorizationEndpoint, query.Encode())
+}
+
+// expireSSOLogins discards pending logins whose URLs have expired. It must run on the hub goroutine.
+func (server *ChatServer) expireSSOLogins() {
+
+	for state, login := range server.ssoLogins {
+		if time.Now().After(login.expiresAt) {
+			delete(server.ssoLogins, state)
+		}
+	}
+}
+
+// serveOIDCCallback runs the HTTP endpoint the identity provider redirects users back to after logging in.
+// It runs for the lifetime of the server.
+func (server *ChatServer) serveOIDCCallback() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcCallbackPath, server.handleOIDCCallback)
+
+	httpServer := &http.Server{Addr: server.config.OIDCListenAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	slog.Info("Single sign-on callback listening", "addr", server.config.OIDCListenAddr, "path", oidcCallbackPath)
+	if err := httpServer.ListenAndServe(); err != nil {
+		slog.Error("Single sign-on callback server failed", "err", err)
+	}
+}
+
+// handleOIDCCallback completes a pending /SSO login: it exchanges the authorization code for an ID token,
+// verifies it, and binds the verified identity to the chat session that started the login.
+func (server *ChatServer) handleOIDCCallback(writer http.ResponseWriter, request *http.Request) {
+
+	state := request.URL.Query().Get("state")
+
+	var login *ssoLogin
+	var pending bool
+	server.onHub(func() {
+		login, pending = server.ssoLogins[state]
+		delete(server.ssoLogins, state)
+	})
+
+	if !pending || time.Now().After(login.expiresAt) {
+		http.Error(writer, "This login link is invalid or has expired; run /SSO again", http.StatusBadRequest)
+		return
+	}
+
+	if providerError := request.URL.Query().Get("error"); providerError != "" {
+		http.Error(writer, "Login was not completed: "+providerError, http.StatusBadRequest)
+		sendReplyf(login.conn, errSSOFailed, "Single sign-on failed: %s", providerError)
+		return
+	}
+
+	idToken, err := server.oidc.exchangeCode(server.config, request.URL.Query().Get("code"))
+	if err != nil {
+		slog.Warn("Failed to exchange authorization code", "err", err)
+		http.Error(writer, "Login failed", http.StatusBadGateway)
+		sendReply(login.conn, errSSOFailed, "Single sign-on failed")
+		return
+	}
+
+	claims, err := server.oidc.verifyIDToken(idToken, server.config.OIDCClientID, login.nonce)
+	if err != nil {
+		slog.Warn("Rejected ID token", "err", err)
+		http.Error(writer, "Login failed", http.StatusUnauthorized)
+		sendReply(login.conn, errSSOFailed, "Single sign-on failed")
+		return
+	}
+
+	identity := claims.Email
+	if identity == "" {
+		identity = claims.Subject
+	}
+
+	claim := nicknameClaim{nickname: claims.PreferredUsername}
+	if validNickname, _ := validateNickname(claims.PreferredUsername); validNickname {
+		claim = server.claimNickname(claims.PreferredUsername)
+	}
+
+	server.onHubWithClaim(claim, func() {
+		client, connected := server.clients[login.conn]
+		if !connected {
+			http.Error(writer, "Your chat session has ended", http.StatusGone)
+			return
+		}
+
+		client.ssoIdentity = identity
+		server.clientLog(login.conn).Info("Authenticated via single sign-on", "identity", identity)
+		sendReplyf(login.conn, rplLoggedIn, "You are now authenticated as %s", identity)
+		fmt.Fprintln(writer, "Login complete; you can return to the chat")
+
+		// Users who haven't picked a nickname yet get their username from the identity provider when it is free
+		if _, registered := server.users.lookup(login.conn); !registered && claims.PreferredUsername != "" {
+			if validNickname, _ := validateNickname(claims.PreferredUsername); validNickname && !server.isNicknameProtected(login.conn, claims.PreferredUsername) {
+				server.setNickname(login.conn, claim)
+			}
+		}
+	})
+}
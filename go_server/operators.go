@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+)
+
+// handleOperCommand elevates the client to server operator when the configured operator password matches.
+// Operator login is disabled when no password is configured.
+func (server *ChatServer) handleOperCommand(conn net.Conn, password string) {
+
+	server.onHub(func() {
+		client, exists := server.clients[conn]
+		if !exists {
+			return
+		}
+
+		if client.operator {
+			sendReply(conn, errNoChange, "You are already a server operator")
+			return
+		}
+
+		if server.config.OperPassword == "" {
+			sendReply(conn, errDisabled, "Operator login is disabled on this server")
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(password), []byte(server.config.OperPassword)) != 1 {
+			server.clientLog(conn).Warn("Failed /OPER attempt")
+			sendReply(conn, errPasswordMismatch, "Incorrect operator password")
+			server.recordFailure(conn, "a failed /OPER attempt")
+			return
+		}
+
+		client.operator = true
+		server.clientLog(conn).Info("Became a server operator")
+		sendReply(conn, rplOperator, "You are now a server operator")
+	})
+}
+
+// disconnectClient forcibly closes a client's connection without the usual leave announcement,
+// for callers that announce the disconnect themselves. It must run on the hub goroutine.
+func (server *ChatServer) disconnectClient(conn net.Conn) {
+
+	client, exists := server.clients[conn]
+	if !exists {
+		return
+	}
+
+	client.quietDisconnect = true
+	client.lifecycle.cancel()
+}
+
+// handleKickCommand lets a moderator disconnect a user, telling them the reason
+// and announcing the kick to everyone else.
+func (server *ChatServer) handleKickCommand(conn net.Conn, nickname string, reason string) {
+
+	server.onHub(func() {
+		targetConn, found := server.findUserByNickname(nickname)
+		if !found {
+			sendReplyf(conn, errNoSuchNick, "No such user %s", nickname)
+			return
+		}
+		if !server.outranks(conn, targetConn, "kick") {
+			return
+		}
+
+		kickMessage := fmt.Sprintf("%s was kicked by %s", nickname, server.users.nickname(conn))
+		if reason != "" {
+			kickMessage += " (" + reason + ")"
+		}
+
+		kickNotice := fmt.Sprintf("You were kicked by %s", server.users.nickname(conn))
+		if reason != "" {
+			kickNotice += " (" + reason + ")"
+		}
+		sendReply(targetConn, errKicked, kickNotice)
+
+		payload := newMessagePayload(kickMessage)
+		server.users.each(func(userConn net.Conn, userNickname string) bool {
+			if userNickname != nickname {
+				server.deliverPayload(userConn, payload)
+			}
+			return true
+		})
+		payload.release()
+
+		server.clientLog(conn).Info("Kicked user", "target", nickname, "target_remote", targetConn.RemoteAddr().String())
+
+		// A user connected from several devices is kicked from all of them
+		for _, device := range server.otherDevices(targetConn) {
+			sendReply(device, errKicked, kickNotice)
+			server.disconnectClient(device)
+		}
+		server.disconnectClient(targetConn)
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// closeFlushTimeout is how long closing a connection waits for the lines already queued for it to be written.
+const closeFlushTimeout = 5 * time.Second
+
+// maxMissedWriteDeadlines is how many write deadlines in a row a client may miss before it is treated as dead.
+const maxMissedWriteDeadlines = 3
+
+// maxPooledOutbound is the largest buffer returned to the pool; buffers grown by an unusually long line
+// are left for the garbage collector rather than kept alive by the pool.
+const maxPooledOutbound = 4096
+
+// outboundPool holds the buffers queued for the writer goroutines, so that queueing a line reuses a buffer
+// a writer has finished with rather than allocating one.
+var outboundPool = sync.Pool{New: func() any { return new(outboundBuffer) }}
+
+// outboundBuffer is encoded bytes queued to be written to one or more connections. A line broadcast to
+// many recipients is queued for all of them in the same buffer, which goes back to the pool once the last
+// of its holders releases it. Its bytes must not change while it is held.
+type outboundBuffer struct {
+	data []byte
+	refs atomic.Int32 // refs counts the holders of the buffer, each of which must release it once
+}
+
+// newOutboundBuffer returns a pooled buffer holding a copy of p, held once by the caller.
+func newOutboundBuffer(p []byte) *outboundBuffer {
+
+	buffer := outboundPool.Get().(*outboundBuffer)
+	buffer.data = append(buffer.data[:0], p...)
+	buffer.refs.Store(1)
+	return buffer
+}
+
+// newOutboundLine returns a pooled buffer holding text as a line, held once by the caller.
+func newOutboundLine(text string) *outboundBuffer {
+
+	buffer := outboundPool.Get().(*outboundBuffer)
+	buffer.data = append(append(buffer.data[:0], text...), '\n')
+	buffer.refs.Store(1)
+	return buffer
+}
+
+// retain adds a holder to the buffer, which must release it in turn.
+func (buffer *outboundBuffer) retain() *outboundBuffer {
+
+	buffer.refs.Add(1)
+	return buffer
+}
+
+// release gives up one hold on the buffer, returning it to the pool once nothing holds it.
+func (buffer *outboundBuffer) release() {
+
+	if buffer.refs.Add(-1) > 0 {
+		return
+	}
+	if cap(buffer.data) > maxPooledOutbound {
+		buffer.data = nil
+	}
+	outboundPool.Put(buffer)
+}
+
+// writeOutbound writes a buffer to a connection and releases the caller's hold on it. Wire connections
+// queue the buffer itself, shared with any other connection it is written to, rather than a copy of it.
+func writeOutbound(conn net.Conn, buffer *outboundBuffer) error {
+
+	defer buffer.release()
+
+	if wire, isWire := conn.(*wireConn); isWire {
+		return wire.writeBuffer(buffer)
+	}
+	_, err := conn.Write(buffer.data)
+	return err
+}
+
+// startWriter starts the goroutine writing a connection's outbound queue, so that whoever sends a client a line
+// only queues it, and a client slow to read holds up nobody but itself.
+func (conn *wireConn) startWriter(queueSize int, writeTimeout time.Duration) {
+
+	conn.outbound = make(chan *outboundBuffer, queueSize)
+	conn.drained = make(chan struct{})
+	go conn.writeQueued(writeTimeout)
+}
+
+// writeQueued writes what is queued for the connection until the queue is closed, then closes the connection.
+// A failed write closes the connection at once, which ends the client's session, and the rest of the queue is discarded.
+func (conn *wireConn) writeQueued(writeTimeout time.Duration) {
+
+	defer close(conn.drained)
+	defer conn.Conn.Close()
+
+	for buffer := range conn.outbound {
+		err := conn.writeWithDeadline(buffer.data, writeTimeout)
+		buffer.release()
+		if err != nil {
+			conn.Conn.Close()
+			for buffer := range conn.outbound {
+				buffer.release()
+			}
+			return
+		}
+	}
+}
+
+// writeWithDeadline writes data to the underlying connection, giving each attempt writeTimeout to finish.
+// An attempt that misses its deadline is retried with what it left unwritten, until the client has missed
+// maxMissedWriteDeadlines in a row without accepting any of it, when it is treated as dead.
+func (conn *wireConn) writeWithDeadline(data []byte, writeTimeout time.Duration) error {
+
+	if writeTimeout <= 0 {
+		_, err := conn.Conn.Write(data)
+		return err
+	}
+
+	missed := 0
+	for {
+		conn.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		written, err := conn.Conn.Write(data)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			return err
+		}
+
+		data = data[written:]
+		if written > 0 {
+			missed = 0
+		}
+		missed++
+		if missed >= maxMissedWriteDeadlines {
+			slog.Warn("Disconnecting client whose writes keep timing out", "remote", conn.RemoteAddr().String(), "missed", missed, "timeout", writeTimeout)
+			return err
+		}
+	}
+}
+
+// writeBuffer writes a buffer to the connection, queueing the buffer itself when the client's wire format
+// sends it unchanged, or encoding a copy of it otherwise. The caller keeps its own hold on the buffer.
+func (conn *wireConn) writeBuffer(buffer *outboundBuffer) error {
+
+	conn.writeMutex.Lock()
+	if conn.format == textFormat && conn.compressor == nil {
+		defer conn.writeMutex.Unlock()
+		return conn.enqueueBuffer(buffer.retain())
+	}
+	conn.writeMutex.Unlock()
+
+	_, err := conn.Write(buffer.data)
+	return err
+}
+
+// enqueue queues a copy of encoded bytes to be written to the connection. The caller must hold conn.writeMutex.
+func (conn *wireConn) enqueue(p []byte) error {
+
+	return conn.enqueueBuffer(newOutboundBuffer(p))
+}
+
+// enqueueBuffer queues a buffer to be written to the connection, handing the caller's hold on it to the writer.
+// When the queue is full the client has stopped reading, so rather than wait for it, or drop lines it would then
+// miss, the connection is closed, ending its session. The caller must hold conn.writeMutex.
+func (conn *wireConn) enqueueBuffer(buffer *outboundBuffer) error {
+
+	if conn.closed {
+		buffer.release()
+		return net.ErrClosed
+	}
+
+	select {
+
+		case conn.outbound <- buffer:
+			return nil
+
+		default:
+			buffer.release()
+			slog.Warn("Disconnecting client whose send queue is full", "remote", conn.RemoteAddr().String(), "queued", cap(conn.outbound))
+			conn.closed = true
+			close(conn.outbound)
+
+			// Closing the underlying connection also fails the write in progress, so the writer stops at once
+			conn.Conn.Close()
+			return net.ErrClosed
+	}
+}
+
+// Close implements net.Conn, closing the connection once the lines queued for it have been written,
+// or once closeFlushTimeout has passed for a client not reading them.
+func (conn *wireConn) Close() error {
+
+	conn.writeMutex.Lock()
+	if !conn.closed {
+		conn.closed = true
+		close(conn.outbound)
+	}
+	conn.writeMutex.Unlock()
+
+	select {
+
+		case <-conn.drained:
+
+		case <-time.After(closeFlushTimeout):
+	}
+	return conn.Conn.Close()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+)
+
+// waitForWriters waits until the writer goroutines of the connections have emptied their send queues.
+func waitForWriters(conns []net.Conn) {
+
+	for _, conn := range conns {
+		for len(conn.(*wireConn).outbound) > 0 {
+			runtime.Gosched()
+		}
+	}
+}
+
+// BenchmarkQueueSharedLine measures queueing one broadcast line for an increasing number of recipients.
+// The line is shared between their send queues rather than copied for each, so allocations per operation
+// should stay the same however many recipients there are.
+func BenchmarkQueueSharedLine(b *testing.B) {
+
+	server := newTestServer(b)
+
+	for _, size := range []int{1, 16, 256, 4096} {
+		b.Run(fmt.Sprintf("recipients=%d", size), func(b *testing.B) {
+			recipients := make([]net.Conn, size)
+			for i := range recipients {
+				recipients[i] = newWireConn(discardConn{}, server.config)
+			}
+			b.Cleanup(func() {
+				for _, conn := range recipients {
+					conn.Close()
+				}
+			})
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				payload := newMessagePayload("[#bench] bench said: hello, everyone")
+				for _, conn := range recipients {
+					if err := writeOutbound(conn, payload.line.retain()); err != nil {
+						b.Fatalf("Failed to queue the line: %v", err)
+					}
+				}
+				payload.release()
+
+				// The writers are given time to catch up well before the send queues fill, which would disconnect them
+				if i%(server.config.SendQueueSize/2) == 0 {
+					b.StopTimer()
+					waitForWriters(recipients)
+					b.StartTimer()
+				}
+			}
+		})
+	}
+}
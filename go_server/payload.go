@@ -0,0 +1,34 @@
+package main
+
+import "sync"
+
+// payloadPool holds the payloads messages are formatted into for delivery, so that fanning a message out
+// to its recipients neither formats it again nor allocates a line for each of them.
+var payloadPool = sync.Pool{New: func() any { return new(messagePayload) }}
+
+// messagePayload is a message formatted once for delivery to any number of recipients: its text, and the
+// line written to every recipient whose capabilities add no tags to it. The line is queued for each of
+// those recipients as it is, shared between their send queues, rather than copied.
+type messagePayload struct {
+	text string
+	line *outboundBuffer
+}
+
+// newMessagePayload formats a message for delivery into a pooled buffer. It must be released once delivered.
+func newMessagePayload(text string) *messagePayload {
+
+	payload := payloadPool.Get().(*messagePayload)
+	payload.text = text
+	payload.line = newOutboundLine(text)
+	return payload
+}
+
+// release returns the payload to the pool. It must not be used again afterwards. Its line goes back to
+// the pool once the last recipient it was queued for has written it.
+func (payload *messagePayload) release() {
+
+	payload.line.release()
+	payload.line = nil
+	payload.text = ""
+	payloadPool.Put(payload)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+)
+
+// pprofPath is the HTTP path the profiling endpoints are served under.
+const pprofPath = "/debug/pprof/"
+
+// servePprof runs the HTTP endpoint serving net/http/pprof, so operators can capture goroutine, heap, and CPU
+// profiles from a live server. It listens on its own address, apart from the public HTTP endpoint, and every
+// request must carry the configured pprof token as a bearer token. It runs for the lifetime of the server.
+func (server *ChatServer) servePprof() {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(pprofPath, pprof.Index)
+	mux.HandleFunc(pprofPath+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(pprofPath+"profile", pprof.Profile)
+	mux.HandleFunc(pprofPath+"symbol", pprof.Symbol)
+	mux.HandleFunc(pprofPath+"trace", pprof.Trace)
+
+	// CPU profiles and traces run for as long as they are asked to, so writes get no deadline
+	httpServer := &http.Server{Addr: server.config.PprofListen, Handler: server.requirePprofToken(mux), ReadHeaderTimeout: 10 * time.Second}
+
+	if server.tlsConfig == nil {
+		slog.Info("Profiling endpoint listening", "url", "http://"+server.config.PprofListen+pprofPath)
+		if err := httpServer.ListenAndServe(); err != nil {
+			fatal("Profiling server failed", "err", err)
+		}
+		return
+	}
+
+	httpServer.TLSConfig = server.tlsConfig.Clone()
+	slog.Info("Profiling endpoint listening", "url", "https://"+server.config.PprofListen+pprofPath)
+	if err := httpServer.ListenAndServeTLS("", ""); err != nil {
+		fatal("Profiling server failed", "err", err)
+	}
+}
+
+// requirePprofToken wraps a handler so that it only serves requests carrying the pprof token as a bearer token.
+func (server *ChatServer) requirePprofToken(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		token, hasBearer := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+		if !hasBearer || subtle.ConstantTimeCompare([]byte(token), []byte(server.config.PprofToken)) != 1 {
+			slog.Warn("Failed profiling endpoint authentication", "remote", request.RemoteAddr)
+			writer.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(writer, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// presenceTTL is how long a nickname claim outlives the server holding it, should that server stop without releasing it.
+const presenceTTL = 60 * time.Second
+
+// releaseScript deletes a nickname claim only if it is still held by the releasing server.
+const releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+
+// renewScript extends a nickname claim only if it is still held by the renewing server, taking it back if it
+// has expired meanwhile. It returns 0 if another server now holds the nickname.
+const renewScript = `local holder = redis.call("GET", KEYS[1])
+if holder == ARGV[1] then return redis.call("EXPIRE", KEYS[1], ARGV[2]) end
+if not holder then redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2]) return 1 end
+return 0`
+
+// PresenceStore shares which nicknames are online, their away status, and their channel memberships
+// outside the process, so that several server instances can see each other's users.
+// Implementations must be safe for concurrent use.
+type PresenceStore interface {
+	// ClaimNickname registers a nickname to this server, reporting false if another server holds it.
+	ClaimNickname(nickname string) (bool, error)
+
+	// ReleaseNickname gives up a nickname this server holds, clearing its away status.
+	ReleaseNickname(nickname string) error
+
+	// SetAway sets a nickname's away message; an empty message clears it.
+	SetAway(nickname string, message string) error
+
+	// JoinChannel records a nickname as a member of a channel.
+	JoinChannel(channel string, nickname string) error
+
+	// PartChannel records that a nickname has left a channel.
+	PartChannel(channel string, nickname string) error
+}
+
+// redisPresence is a PresenceStore kept in Redis. Nickname claims expire unless the server holding them
+// keeps refreshing them, so a server that stops abruptly does not hold its nicknames forever.
+type redisPresence struct {
+	client     *redisClient
+	prefix     string
+	instanceID string // instanceID identifies this server as the holder of its nickname claims
+
+	mutex sync.Mutex      // mutex protects access to held
+	held  map[string]bool // held is the set of nicknames this server has claimed
+
+	lost func(nickname string) // lost is called when another server has taken a nickname this server held
+}
+
+// newRedisPresence creates a presence store in the Redis server at addr, with every key starting with prefix,
+// and starts refreshing its nickname claims. lost is called with any nickname whose claim could not be renewed
+// because another server holds it.
+func newRedisPresence(addr string, password string, prefix string, lost func(nickname string)) (*redisPresence, error) {
+
+	instanceID, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	presence := &redisPresence{
+		client:     newRedisClient(addr, password),
+		prefix:     prefix,
+		instanceID: instanceID,
+		held:       make(map[string]bool),
+		lost:       lost,
+	}
+	if _, err := presence.client.do("PING"); err != nil {
+		return nil, err
+	}
+
+	go presence.refreshClaims()
+	return presence, nil
+}
+
+// nicknameKey returns the key holding a nickname's claim.
+func (presence *redisPresence) nicknameKey(nickname string) string {
+
+	return presence.prefix + "nick:" + nickname
+}
+
+// ClaimNickname implements PresenceStore.
+func (presence *redisPresence) ClaimNickname(nickname string) (bool, error) {
+
+	key := presence.nicknameKey(nickname)
+	ttl := strconv.Itoa(int(presenceTTL.Seconds()))
+
+	reply, err := presence.client.do("SET", key, presence.instanceID, "NX", "EX", ttl)
+	if err != nil {
+		return false, err
+	}
+
+	// The nickname may already be ours, such as when another device connects as the same user
+	if reply == nil {
+		holder, err := presence.client.do("GET", key)
+		if err != nil || holder != presence.instanceID {
+			return false, err
+		}
+	}
+
+	presence.mutex.Lock()
+	presence.held[nickname] = true
+	presence.mutex.Unlock()
+
+	return true, nil
+}
+
+// ReleaseNickname implements PresenceStore.
+func (presence *redisPresence) ReleaseNickname(nickname string) error {
+
+	presence.mutex.Lock()
+	delete(presence.held, nickname)
+	presence.mutex.Unlock()
+
+	if _, err := presence.client.do("EVAL", releaseScript, "1", presence.nicknameKey(nickname), presence.instanceID); err != nil {
+		return err
+	}
+	_, err := presence.client.do("HDEL", presence.prefix+"away", nickname)
+	return err
+}
+
+// SetAway implements PresenceStore.
+func (presence *redisPresence) SetAway(nickname string, message string) error {
+
+	var err error
+	if message == "" {
+		_, err = presence.client.do("HDEL", presence.prefix+"away", nickname)
+	} else {
+		_, err = presence.client.do("HSET", presence.prefix+"away", nickname, message)
+	}
+	return err
+}
+
+// JoinChannel implements PresenceStore.
+func (presence *redisPresence) JoinChannel(channel string, nickname string) error {
+
+	_, err := presence.client.do("SADD", presence.prefix+"channel:"+channel, nickname)
+	return err
+}
+
+// PartChannel implements PresenceStore.
+func (presence *redisPresence) PartChannel(channel string, nickname string) error {
+
+	_, err := presence.client.do("SREM", presence.prefix+"channel:"+channel, nickname)
+	return err
+}
+
+// refreshClaims renews this server's nickname claims well before they expire, giving up any that another
+// server has taken meanwhile, such as after Redis was unreachable for longer than presenceTTL.
+// It runs for the lifetime of the server.
+func (presence *redisPresence) refreshClaims() {
+
+	ticker := time.NewTicker(presenceTTL / 3)
+	defer ticker.Stop()
+
+	ttl := strconv.Itoa(int(presenceTTL.Seconds()))
+
+	for range ticker.C {
+		presence.mutex.Lock()
+		nicknames := make([]string, 0, len(presence.held))
+		for nickname := range presence.held {
+			nicknames = append(nicknames, nickname)
+		}
+		presence.mutex.Unlock()
+
+		for _, nickname := range nicknames {
+			if presence.renewClaim(nickname, ttl) {
+				continue
+			}
+			presence.lost(nickname)
+		}
+	}
+}
+
+// renewClaim extends a nickname claim, reporting false if another server has taken it. The mutex is held
+// throughout so a release made meanwhile is not undone by taking the expired claim back.
+func (presence *redisPresence) renewClaim(nickname string, ttl string) bool {
+
+	presence.mutex.Lock()
+	defer presence.mutex.Unlock()
+
+	if !presence.held[nickname] {
+		return true
+	}
+
+	reply, err := presence.client.do("EVAL", renewScript, "1", presence.nicknameKey(nickname), presence.instanceID, ttl)
+	if err != nil {
+		slog.Error("Failed to refresh shared nickname claim", "nickname", nickname, "err", err)
+		return true
+	}
+	if reply != int64(0) {
+		return true
+	}
+
+	delete(presence.held, nickname)
+	return false
+}
+
+// presenceQueueSize is the number of updates waiting to be made to the shared presence store before new ones are dropped.
+const presenceQueueSize = 1024
+
+// nicknameClaim is the outcome of claiming a nickname in the shared presence store.
+type nicknameClaim struct {
+	nickname string
+	claimed  bool  // claimed is set when the nickname is free for this server to use
+	err      error // err is set when the store could not be reached
+}
+
+// sharePresence makes the updates queued for the shared presence store one at a time, in the order they were
+// queued, so that the hub goroutine never waits on the store. It runs for the lifetime of the server.
+func (server *ChatServer) sharePresence() {
+
+	for update := range server.presenceUpdates {
+		update()
+	}
+}
+
+// queuePresence queues an update to the shared presence store, if one is configured. If the store has fallen
+// behind the update is dropped rather than holding up the caller.
+func (server *ChatServer) queuePresence(description string, update func()) {
+
+	if server.presence == nil {
+		return
+	}
+
+	select {
+
+		case server.presenceUpdates <- update:
+
+		default:
+			slog.Warn("Shared presence queue is full; dropped update", "update", description)
+	}
+}
+
+// claimNickname claims a nickname in the shared presence store, if one is configured. The claim is queued
+// behind the updates already waiting, so it reaches the store after any release of the same nickname.
+// It waits on the store, so it must not be called on the hub goroutine; the hub confirms the claim by setting
+// the nickname with setNickname, or rolls it back with abandonClaim.
+func (server *ChatServer) claimNickname(nickname string) nicknameClaim {
+
+	if server.presence == nil {
+		return nicknameClaim{nickname: nickname, claimed: true}
+	}
+
+	result := make(chan nicknameClaim, 1)
+	server.presenceUpdates <- func() {
+		claimed, err := server.presence.ClaimNickname(nickname)
+		result <- nicknameClaim{nickname: nickname, claimed: claimed, err: err}
+	}
+	return <-result
+}
+
+// onHubWithClaim runs work, which may set the claimed nickname, on the hub goroutine, then gives the claim up
+// again if the nickname ended up unused by this server. It must not be called on the hub goroutine.
+func (server *ChatServer) onHubWithClaim(claim nicknameClaim, work func()) {
+
+	server.onHub(func() {
+		work()
+		server.abandonClaim(claim)
+	})
+}
+
+// abandonClaim gives up a claim made for a nickname that no connection on this server went on to use.
+// It must run on the hub goroutine.
+func (server *ChatServer) abandonClaim(claim nicknameClaim) {
+
+	if !claim.claimed {
+		return
+	}
+	if _, inUse := server.findUserByNickname(claim.nickname); inUse {
+		return
+	}
+	server.queuePresence("release "+claim.nickname, func() {
+		server.releaseClaim(nil, claim.nickname)
+	})
+}
+
+// releaseClaim releases a nickname in the shared presence store unless a connection other than the one
+// giving it up has taken it since the release was queued. It runs on the presence goroutine.
+func (server *ChatServer) releaseClaim(conn net.Conn, nickname string) {
+
+	if holder, inUse := server.findUserByNickname(nickname); inUse && holder != conn {
+		return
+	}
+	if err := server.presence.ReleaseNickname(nickname); err != nil {
+		slog.Error("Failed to release shared nickname", "nickname", nickname, "err", err)
+	}
+}
+
+// releaseNickname queues giving up a nickname and its channel memberships in the shared presence store,
+// if one is configured. It must run on the hub goroutine.
+func (server *ChatServer) releaseNickname(conn net.Conn, nickname string) {
+
+	if server.presence == nil {
+		return
+	}
+
+	for _, channelName := range server.channelsOf(conn) {
+		server.partPresence(channelName, nickname)
+	}
+	server.queuePresence("release "+nickname, func() {
+		server.releaseClaim(conn, nickname)
+	})
+}
+
+// joinPresence queues recording a channel join in the shared presence store, if one is configured.
+func (server *ChatServer) joinPresence(channelName string, nickname string) {
+
+	server.queuePresence("join "+channelName, func() {
+		if err := server.presence.JoinChannel(channelName, nickname); err != nil {
+			slog.Error("Failed to share channel join", "nickname", nickname, "channel", channelName, "err", err)
+		}
+	})
+}
+
+// partPresence queues recording a channel part in the shared presence store, if one is configured.
+func (server *ChatServer) partPresence(channelName string, nickname string) {
+
+	server.queuePresence("part "+channelName, func() {
+		if err := server.presence.PartChannel(channelName, nickname); err != nil {
+			slog.Error("Failed to share channel part", "nickname", nickname, "channel", channelName, "err", err)
+		}
+	})
+}
+
+// awayPresence queues recording a change in away status in the shared presence store, if one is configured.
+func (server *ChatServer) awayPresence(nickname string, message string) {
+
+	server.queuePresence("away "+nickname, func() {
+		if err := server.presence.SetAway(nickname, message); err != nil {
+			slog.Error("Failed to share away status", "nickname", nickname, "err", err)
+		}
+	})
+}
+
+// nicknameClaimLost renames the users of a nickname whose shared claim another server now holds, as two
+// servers must not both have a user by that name. It must not be called on the hub goroutine.
+func (server *ChatServer) nicknameClaimLost(nickname string) {
+
+	slog.Warn("Lost shared nickname claim to another server", "nickname", nickname)
+	for _, conn := range server.users.connections(nickname) {
+		sendReplyf(conn, errNicknameInUse, "%s was taken on another server while this server could not renew its claim", nickname)
+		server.renameToGuest(conn, func() bool {
+			return server.users.nickname(conn) == nickname
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicALPN is the application protocol QUIC clients negotiate to speak the line protocol.
+const quicALPN = "go-chat"
+
+// QUIC keepalive settings. Pings keep NAT bindings open on mobile networks, and a connection survives
+// the client changing networks for as long as the idle timeout.
+const (
+	quicKeepAlivePeriod = 15 * time.Second
+	quicMaxIdleTimeout  = time.Minute
+)
+
+// quicConn adapts the stream a QUIC client opens to net.Conn, so QUIC clients are handled exactly like
+// TCP clients. Each client speaks the line protocol over the first bidirectional stream it opens.
+type quicConn struct {
+	quic.Stream
+	connection quic.Connection
+}
+
+// LocalAddr implements net.Conn.
+func (conn *quicConn) LocalAddr() net.Addr {
+
+	return conn.connection.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn, returning the client's current address, which changes when it migrates.
+func (conn *quicConn) RemoteAddr() net.Addr {
+
+	return conn.connection.RemoteAddr()
+}
+
+// Close implements net.Conn, closing the whole QUIC connection rather than only the stream's send direction.
+func (conn *quicConn) Close() error {
+
+	conn.Stream.Close()
+	return conn.connection.CloseWithError(0, "")
+}
+
+// serveQUIC accepts QUIC connections on the configured address. It runs for the lifetime of the server.
+func (server *ChatServer) serveQUIC() {
+
+	tlsConfig := server.tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{quicALPN}
+	tlsConfig.MinVersion = tls.VersionTLS13
+
+	listen, err := quic.ListenAddr(server.config.QUICListen, tlsConfig, &quic.Config{
+		KeepAlivePeriod: quicKeepAlivePeriod,
+		MaxIdleTimeout:  quicMaxIdleTimeout,
+	})
+	if err != nil {
+		fatal("Failed to start QUIC listener", "err", err)
+	}
+	defer listen.Close()
+
+	server.acceptLoops.Add(1)
+	defer server.acceptLoops.Add(-1)
+
+	slog.Info("Server accepting QUIC connections", "addr", server.config.QUICListen)
+	backoff := acceptBackoff{failing: &server.failingAccepts}
+	for {
+		server.acceptThrottle.wait()
+
+		connection, err := listen.Accept(context.Background())
+		if errors.Is(err, quic.ErrServerClosed) {
+			return
+		}
+		if err != nil {
+			delay := backoff.failed()
+			slog.Error("Failed to accept QUIC connection", "err", err, "retry_in", delay)
+			time.Sleep(delay)
+			continue
+		}
+		backoff.succeeded()
+		go server.serveQUICConnection(connection)
+	}
+}
+
+// serveQUICConnection waits for a QUIC client to open its stream and serves it.
+func (server *ChatServer) serveQUICConnection(connection quic.Connection) {
+
+	ctx, cancel := context.WithTimeout(connection.Context(), quicMaxIdleTimeout)
+	defer cancel()
+
+	stream, err := connection.AcceptStream(ctx)
+	if err != nil {
+		connection.CloseWithError(0, "no stream opened")
+		return
+	}
+	server.serveConnection(&quicConn{Stream: stream, connection: connection})
+}
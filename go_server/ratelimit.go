@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// rateLimitedCommands are the expensive or abusable commands that each have their own rate limit,
+// separate from the general flood protection.
+var rateLimitedCommands = []string{LIST, WHOIS, HISTORY, SEARCH}
+
+// floodStrikeWindow is how long a client must stay under the rate limit for its flood strikes to reset
+const floodStrikeWindow = 30 * time.Second
+
+// tokenBucket is a token-bucket rate limiter: tokens refill continuously at rate per second
+// up to burst, and each action consumes one token.
+type tokenBucket struct {
+	rate       float64   // rate is the number of tokens added per second
+	burst      float64   // burst is the maximum number of tokens the bucket holds
+	tokens     float64   // tokens is the number of tokens currently available
+	lastRefill time.Time // lastRefill is when tokens was last brought up to date
+}
+
+// newTokenBucket creates a full bucket refilling at rate tokens per second, holding at most burst tokens.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+
+	return &tokenBucket{
+		rate:       rate,
+		burst:      float64(burst),
+		tokens:     float64(burst),
+		lastRefill: time.Now(),
+	}
+}
+
+// take consumes a token if one is available. Otherwise it returns false and how long until one will be.
+func (bucket *tokenBucket) take() (bool, time.Duration) {
+
+	now := time.Now()
+	bucket.tokens = min(bucket.burst, bucket.tokens+now.Sub(bucket.lastRefill).Seconds()*bucket.rate)
+	bucket.lastRefill = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+}
+
+// floodGuard enforces the per-connection message rate limit. Clients exceeding it are warned,
+// then throttled by delaying their input, and disconnected after too many violations.
+type floodGuard struct {
+	bucket     *tokenBucket // bucket limits the rate of lines read from the client
+	strikes    int          // strikes counts recent rate limit violations
+	lastStrike time.Time    // lastStrike is when the client last exceeded the limit
+	maxStrikes int          // maxStrikes is the number of violations that triggers a disconnect
+}
+
+// newFloodGuard creates a flood guard from the server configuration, or returns nil if flood protection is disabled.
+func newFloodGuard(config Config) *floodGuard {
+
+	if config.FloodRate <= 0 {
+		return nil
+	}
+
+	return &floodGuard{
+		bucket:     newTokenBucket(config.FloodRate, max(config.FloodBurst, 1)),
+		maxStrikes: config.FloodStrikes,
+	}
+}
+
+// admit is called before each line a client sends. It returns false when the client has flooded
+// for long enough to be disconnected; otherwise it returns true, after delaying if the client is over the limit.
+func (guard *floodGuard) admit(ctx context.Context, conn net.Conn) bool {
+
+	allowed, wait := guard.bucket.take()
+	if allowed {
+		return true
+	}
+
+	if time.Since(guard.lastStrike) > floodStrikeWindow {
+		guard.strikes = 0
+	}
+	guard.strikes++
+	guard.lastStrike = time.Now()
+
+	if guard.maxStrikes > 0 && guard.strikes >= guard.maxStrikes {
+		slog.Warn("Disconnected for flooding", "remote", conn.RemoteAddr().String())
+		sendReply(conn, errFlooding, "Disconnected for flooding")
+		return false
+	}
+
+	if guard.strikes == 1 {
+		sendReply(conn, errThrottled, "You are sending messages too quickly; further messages will be delayed")
+	}
+
+	if !sleepContext(ctx, wait) {
+		return false
+	}
+
+	guard.bucket.take()
+	return true
+}
+
+// allowCommand applies the per-command rate limits, telling the client how long to wait when a command is refused.
+func (server *ChatServer) allowCommand(conn net.Conn, command string) bool {
+
+	if server.config.CommandRate <= 0 || !slices.Contains(rateLimitedCommands, command) {
+		return true
+	}
+
+	allowed := true
+	server.onHub(func() {
+		client, exists := server.clients[conn]
+		if !exists {
+			return
+		}
+
+		bucket, exists := client.commandLimits[command]
+		if !exists {
+			bucket = newTokenBucket(server.config.CommandRate, max(server.config.CommandBurst, 1))
+			client.commandLimits[command] = bucket
+		}
+
+		var wait time.Duration
+		allowed, wait = bucket.take()
+		if !allowed {
+			sendReplyf(conn, errRateLimited, "Too many %s commands; try again in %d seconds", command, int(math.Ceil(wait.Seconds())))
+		}
+	})
+	return allowed
+}
+
+// renameCooldown returns how long a registered user must wait before changing nickname again,
+// or zero if they may rename now. It must run on the hub goroutine.
+func (server *ChatServer) renameCooldown(conn net.Conn) time.Duration {
+
+	client, exists := server.clients[conn]
+	if !exists || client.lastRenamed.IsZero() {
+		return 0
+	}
+	return max(0, server.config.NickCooldown-time.Since(client.lastRenamed))
+}
+
+// allowRepeat suppresses copy-paste spam: once a user has sent the same message the configured number of times
+// in a row, each further repeat within the repeat window is dropped and the sender told why.
+func (server *ChatServer) allowRepeat(conn net.Conn, message string) bool {
+
+	if server.config.RepeatLimit <= 0 {
+		return true
+	}
+
+	allowed := true
+	server.onHub(func() {
+		client, exists := server.clients[conn]
+		if !exists {
+			return
+		}
+
+		// Repeats are matched ignoring case and surrounding whitespace so trivial variations still count
+		normalized := strings.ToLower(strings.TrimSpace(message))
+		now := time.Now()
+
+		if normalized == client.lastMessage && now.Sub(client.lastMessageAt) < server.config.RepeatWindow {
+			client.repeats++
+		} else {
+			client.lastMessage = normalized
+			client.repeats = 1
+		}
+		client.lastMessageAt = now
+
+		if client.repeats > server.config.RepeatLimit {
+			sendReply(conn, errRepeated, "Message not sent: you have repeated the same message too many times")
+			allowed = false
+		}
+	})
+	return allowed
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisTimeout bounds how long connecting to Redis or waiting for a reply may take.
+const redisTimeout = 2 * time.Second
+
+// redisClient is a minimal client for the Redis serialization protocol, sending one command at a time
+// over a single connection that is re-established after errors.
+type redisClient struct {
+	addr     string
+	password string
+
+	mutex  sync.Mutex // mutex serializes commands on the connection
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisError is an error reply from the Redis server.
+type redisError string
+
+// Error implements error.
+func (err redisError) Error() string {
+
+	return "redis: " + string(err)
+}
+
+// newRedisClient creates a client for the Redis server at addr. It connects on first use.
+func newRedisClient(addr string, password string) *redisClient {
+
+	return &redisClient{addr: addr, password: password}
+}
+
+// do sends a command and returns its reply: a string, an int64, nil, or a []any for arrays.
+func (client *redisClient) do(args ...string) (any, error) {
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.conn == nil {
+		if err := client.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := client.roundTrip(args)
+
+	// Error replies leave the connection usable, but anything else means it is in an unknown state
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		client.conn.Close()
+		client.conn = nil
+	}
+	return reply, err
+}
+
+// connect dials the server and authenticates if a password is configured. The caller must hold client.mutex.
+func (client *redisClient) connect() error {
+
+	conn, err := net.DialTimeout("tcp", client.addr, redisTimeout)
+	if err != nil {
+		return err
+	}
+	client.conn = conn
+	client.reader = bufio.NewReader(conn)
+
+	if client.password != "" {
+		if _, err := client.roundTrip([]string{"AUTH", client.password}); err != nil {
+			conn.Close()
+			client.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// roundTrip writes a command and reads its reply. The caller must hold client.mutex.
+func (client *redisClient) roundTrip(args []string) (any, error) {
+
+	client.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	command := fmt.Appendf(nil, "*%d\r\n", len(args))
+	for _, arg := range args {
+		command = fmt.Appendf(command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := client.conn.Write(command); err != nil {
+		return nil, err
+	}
+
+	return client.readReply()
+}
+
+// readReply parses one reply from the connection. The caller must hold client.mutex.
+func (client *redisClient) readReply() (any, error) {
+
+	line, err := client.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+
+		case '+':
+			return payload, nil
+
+		case '-':
+			return nil, redisError(payload)
+
+		case ':':
+			return strconv.ParseInt(payload, 10, 64)
+
+		case '$':
+			length, err := strconv.Atoi(payload)
+			if err != nil {
+				return nil, err
+			}
+			if length < 0 {
+				return nil, nil
+			}
+			data := make([]byte, length+2)
+			if _, err := io.ReadFull(client.reader, data); err != nil {
+				return nil, err
+			}
+			return string(data[:length]), nil
+
+		case '*':
+			count, err := strconv.Atoi(payload)
+			if err != nil {
+				return nil, err
+			}
+			if count < 0 {
+				return nil, nil
+			}
+			items := make([]any, count)
+			for i := range items {
+				if items[i], err = client.readReply(); err != nil {
+					return nil, err
+				}
+			}
+			return items, nil
+
+		default:
+			return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
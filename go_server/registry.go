@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"sync"
+)
+
+// registryShardBits is the number of hash bits picking a shard of the user registry.
+const registryShardBits = 6
+
+// registryShards is the number of shards the user registry is split into.
+const registryShards = 1 << registryShardBits
+
+// userRegistry maps connections to user nicknames. It is split into shards by a hash of the connection,
+// each with its own RWMutex, so that read-heavy paths such as /LIST, /WHO, and broadcast fan-out can read
+// it off the hub goroutine without contending with one another, or with writes to the other shards.
+// Writes are only made on the hub goroutine; reads may be made anywhere.
+type userRegistry struct {
+	shards [registryShards]registryShard
+
+	byNickname    map[string]map[net.Conn]bool // byNickname maps nicknames to the connections registered under them
+	nicknameMutex sync.RWMutex                 // nicknameMutex protects access to byNickname
+}
+
+// registryShard holds the registered connections whose hash falls into the shard.
+type registryShard struct {
+	mutex sync.RWMutex        // mutex protects access to users
+	users map[net.Conn]string // users maps network connections to user nicknames
+}
+
+// registryEntry is a registered connection and its nickname, as visited by each.
+type registryEntry struct {
+	conn     net.Conn
+	nickname string
+}
+
+// newUserRegistry creates an empty user registry.
+func newUserRegistry() *userRegistry {
+
+	registry := &userRegistry{byNickname: make(map[string]map[net.Conn]bool)}
+	for i := range registry.shards {
+		registry.shards[i].users = make(map[net.Conn]string)
+	}
+	return registry
+}
+
+// shard returns the shard a connection belongs to. Connections are hashed by identity, using
+// the address of the value behind the net.Conn.
+func (registry *userRegistry) shard(conn net.Conn) *registryShard {
+
+	// Fibonacci hashing spreads the aligned, and so evenly spaced, addresses across the shards by their top bits
+	hash := uint64(reflect.ValueOf(conn).Pointer()) * 0x9E3779B97F4A7C15
+	return &registry.shards[hash>>(64-registryShardBits)]
+}
+
+// lookup returns the nickname registered for a connection, and whether there is one.
+func (registry *userRegistry) lookup(conn net.Conn) (string, bool) {
+
+	shard := registry.shard(conn)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	nickname, registered := shard.users[conn]
+	return nickname, registered
+}
+
+// nickname returns the nickname registered for a connection, or "" if it has none.
+func (registry *userRegistry) nickname(conn net.Conn) string {
+
+	nickname, _ := registry.lookup(conn)
+	return nickname
+}
+
+// connections returns the connections registered under a nickname: one for most users, or one per device
+// for a user connected from several.
+func (registry *userRegistry) connections(nickname string) []net.Conn {
+
+	registry.nicknameMutex.RLock()
+	defer registry.nicknameMutex.RUnlock()
+
+	conns := make([]net.Conn, 0, len(registry.byNickname[nickname]))
+	for conn := range registry.byNickname[nickname] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// anyConnection returns one of the connections registered under a nickname, and whether there is one.
+func (registry *userRegistry) anyConnection(nickname string) (net.Conn, bool) {
+
+	registry.nicknameMutex.RLock()
+	defer registry.nicknameMutex.RUnlock()
+
+	for conn := range registry.byNickname[nickname] {
+		return conn, true
+	}
+	return nil, false
+}
+
+// set registers a connection under a nickname, replacing any nickname it had. It must run on the hub goroutine.
+func (registry *userRegistry) set(conn net.Conn, nickname string) {
+
+	shard := registry.shard(conn)
+	shard.mutex.Lock()
+	previous, renaming := shard.users[conn]
+	shard.users[conn] = nickname
+	shard.mutex.Unlock()
+
+	registry.nicknameMutex.Lock()
+	defer registry.nicknameMutex.Unlock()
+
+	if renaming {
+		registry.unindex(conn, previous)
+	}
+	if registry.byNickname[nickname] == nil {
+		registry.byNickname[nickname] = make(map[net.Conn]bool)
+	}
+	registry.byNickname[nickname][conn] = true
+}
+
+// remove forgets a connection's nickname. It must run on the hub goroutine.
+func (registry *userRegistry) remove(conn net.Conn) {
+
+	shard := registry.shard(conn)
+	shard.mutex.Lock()
+	nickname, registered := shard.users[conn]
+	delete(shard.users, conn)
+	shard.mutex.Unlock()
+
+	if registered {
+		registry.nicknameMutex.Lock()
+		registry.unindex(conn, nickname)
+		registry.nicknameMutex.Unlock()
+	}
+}
+
+// unindex drops a connection from the nickname index. The caller must hold registry.nicknameMutex.
+func (registry *userRegistry) unindex(conn net.Conn, nickname string) {
+
+	delete(registry.byNickname[nickname], conn)
+	if len(registry.byNickname[nickname]) == 0 {
+		delete(registry.byNickname, nickname)
+	}
+}
+
+// count returns the number of registered connections.
+func (registry *userRegistry) count() int {
+
+	total := 0
+	for i := range registry.shards {
+		shard := &registry.shards[i]
+		shard.mutex.RLock()
+		total += len(shard.users)
+		shard.mutex.RUnlock()
+	}
+	return total
+}
+
+// each calls visit for every registered connection and its nickname until visit returns false. Each shard is
+// copied under its read lock and visited once the lock is released, so visit may itself change the registry.
+func (registry *userRegistry) each(visit func(conn net.Conn, nickname string) bool) {
+
+	var entries []registryEntry
+	for i := range registry.shards {
+		shard := &registry.shards[i]
+		shard.mutex.RLock()
+		entries = entries[:0]
+		for conn, nickname := range shard.users {
+			entries = append(entries, registryEntry{conn, nickname})
+		}
+		shard.mutex.RUnlock()
+
+		for _, entry := range entries {
+			if !visit(entry.conn, entry.nickname) {
+				return
+			}
+		}
+	}
+}
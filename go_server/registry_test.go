@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+)
+
+// benchmarkUsers is the number of registered connections the registry benchmarks run against.
+const benchmarkUsers = 5000
+
+// lockedRegistry is the single-Mutex map the user registry replaced, kept as a baseline for the benchmarks.
+type lockedRegistry struct {
+	mutex sync.Mutex
+	users map[net.Conn]string
+}
+
+// lookup returns the nickname registered for a connection, and whether there is one.
+func (registry *lockedRegistry) lookup(conn net.Conn) (string, bool) {
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	nickname, registered := registry.users[conn]
+	return nickname, registered
+}
+
+// set registers a connection under a nickname.
+func (registry *lockedRegistry) set(conn net.Conn, nickname string) {
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.users[conn] = nickname
+}
+
+// benchmarkConns returns distinct connections to register. Pipes are used as they need no sockets or goroutines.
+func benchmarkConns(b *testing.B, count int) []net.Conn {
+
+	b.Helper()
+
+	conns := make([]net.Conn, count)
+	for i := range conns {
+		conns[i], _ = net.Pipe()
+	}
+	return conns
+}
+
+// benchmarkRegistry returns a user registry holding a nickname for each connection.
+func benchmarkRegistry(conns []net.Conn) *userRegistry {
+
+	registry := newUserRegistry()
+	for i, conn := range conns {
+		registry.set(conn, fmt.Sprintf("user%d", i))
+	}
+	return registry
+}
+
+// churn keeps renaming connections, as the hub goroutine does, until stop is closed.
+func churn(conns []net.Conn, set func(conn net.Conn, nickname string), stop chan struct{}, done *sync.WaitGroup) {
+
+	defer done.Done()
+	for i := 0; ; i++ {
+		select {
+
+			case <-stop:
+				return
+
+			default:
+				set(conns[i%len(conns)], fmt.Sprintf("renamed%d", i))
+		}
+	}
+}
+
+// BenchmarkRegistryLookup measures nickname lookups made in parallel while the hub goroutine keeps writing,
+// against the single-Mutex map the sharded registry replaced.
+func BenchmarkRegistryLookup(b *testing.B) {
+
+	conns := benchmarkConns(b, benchmarkUsers)
+
+	b.Run("sharded", func(b *testing.B) {
+		registry := benchmarkRegistry(conns)
+		stop := make(chan struct{})
+		var done sync.WaitGroup
+		done.Add(1)
+		go churn(conns, registry.set, stop, &done)
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				registry.lookup(conns[i%len(conns)])
+			}
+		})
+		b.StopTimer()
+		close(stop)
+		done.Wait()
+	})
+
+	b.Run("single-mutex", func(b *testing.B) {
+		registry := &lockedRegistry{users: make(map[net.Conn]string)}
+		for i, conn := range conns {
+			registry.set(conn, fmt.Sprintf("user%d", i))
+		}
+		stop := make(chan struct{})
+		var done sync.WaitGroup
+		done.Add(1)
+		go churn(conns, registry.set, stop, &done)
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				registry.lookup(conns[i%len(conns)])
+			}
+		})
+		b.StopTimer()
+		close(stop)
+		done.Wait()
+	})
+}
+
+// BenchmarkRegistryConnections measures finding a nickname's connections through the nickname index, as private messages do.
+func BenchmarkRegistryConnections(b *testing.B) {
+
+	registry := benchmarkRegistry(benchmarkConns(b, benchmarkUsers))
+	nicknames := make([]string, benchmarkUsers)
+	for i := range nicknames {
+		nicknames[i] = fmt.Sprintf("user%d", i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			registry.connections(nicknames[i%len(nicknames)])
+		}
+	})
+}
+
+// BenchmarkRegistryEach measures visiting every registered connection, as /WHO and broadcasts do.
+func BenchmarkRegistryEach(b *testing.B) {
+
+	registry := benchmarkRegistry(benchmarkConns(b, benchmarkUsers))
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			registry.each(func(net.Conn, string) bool { return true })
+		}
+	})
+}
+
+// BenchmarkRegistryCount measures counting the registered connections, as /STATS does.
+func BenchmarkRegistryCount(b *testing.B) {
+
+	registry := benchmarkRegistry(benchmarkConns(b, benchmarkUsers))
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			registry.count()
+		}
+	})
+}
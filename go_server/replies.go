@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+)
+
+// replyCode identifies a kind of reply to a client, by a number and a name, so that clients can branch on
+// the code while people still read the text. Replies are sent as "<number> <name> :<text>".
+type replyCode struct {
+	number int
+	name   string
+}
+
+// Replies reporting that a command succeeded, or carrying the information it asked for.
+var (
+	rplNickname        = replyCode{200, "RPL_NICKNAME"}         // rplNickname confirms the nickname a client registered or changed to
+	rplUsers           = replyCode{201, "RPL_USERS"}            // rplUsers lists the connected users
+	rplLoginRequired   = replyCode{202, "RPL_LOGIN_REQUIRED"}   // rplLoginRequired warns that a registered nickname must be logged in to
+	rplLoggedIn        = replyCode{203, "RPL_LOGGED_IN"}        // rplLoggedIn confirms a login, by password, certificate, single sign-on, or token
+	rplSessionToken    = replyCode{204, "RPL_SESSION_TOKEN"}    // rplSessionToken gives the token for resuming the session
+	rplResumed         = replyCode{205, "RPL_RESUMED"}          // rplResumed confirms a resumed session
+	rplSSOURL          = replyCode{206, "RPL_SSO_URL"}          // rplSSOURL gives the address to log in at with single sign-on
+	rplOperator        = replyCode{207, "RPL_OPERATOR"}         // rplOperator confirms an operator login
+	rplJoined          = replyCode{210, "RPL_JOINED"}           // rplJoined confirms joining a channel
+	rplParted          = replyCode{211, "RPL_PARTED"}           // rplParted confirms leaving a channel
+	rplTopic           = replyCode{212, "RPL_TOPIC"}            // rplTopic gives a channel's topic
+	rplNoTopic         = replyCode{213, "RPL_NO_TOPIC"}         // rplNoTopic reports that a channel has no topic
+	rplBacklog         = replyCode{214, "RPL_BACKLOG"}          // rplBacklog replays a recent channel message on joining it
+	rplChannelList     = replyCode{215, "RPL_CHANNEL_LIST"}     // rplChannelList lists the channels
+	rplNames           = replyCode{216, "RPL_NAMES"}            // rplNames lists the members of a channel
+	rplInviting        = replyCode{217, "RPL_INVITING"}         // rplInviting confirms an invitation to a channel
+	rplKnocked         = replyCode{218, "RPL_KNOCKED"}          // rplKnocked confirms a knock on a channel
+	rplBanList         = replyCode{219, "RPL_BAN_LIST"}         // rplBanList lists the bans of a channel or the server
+	rplWhois           = replyCode{230, "RPL_WHOIS"}            // rplWhois gives details of a connected user
+	rplWho             = replyCode{231, "RPL_WHO"}              // rplWho lists the users matching a pattern
+	rplWhowas          = replyCode{232, "RPL_WHOWAS"}           // rplWhowas gives details of a past connection
+	rplSeen            = replyCode{233, "RPL_SEEN"}             // rplSeen reports when a user was last seen
+	rplAway            = replyCode{234, "RPL_AWAY"}             // rplAway confirms a change of away status
+	rplIgnore          = replyCode{235, "RPL_IGNORE"}           // rplIgnore confirms ignoring or no longer ignoring a user
+	rplIgnoreList      = replyCode{236, "RPL_IGNORE_LIST"}      // rplIgnoreList lists the users being ignored
+	rplNotify          = replyCode{237, "RPL_NOTIFY"}           // rplNotify confirms a change to the notify list
+	rplNotifyList      = replyCode{238, "RPL_NOTIFY_LIST"}      // rplNotifyList lists the notify list
+	rplMessageHeld     = replyCode{240, "RPL_MESSAGE_HELD"}     // rplMessageHeld reports that a message is held for an offline user
+	rplOfflineStart    = replyCode{241, "RPL_OFFLINE_START"}    // rplOfflineStart begins the messages received while offline
+	rplOfflineMessage  = replyCode{242, "RPL_OFFLINE_MESSAGE"}  // rplOfflineMessage is a message received while offline
+	rplOfflineEnd      = replyCode{243, "RPL_OFFLINE_END"}      // rplOfflineEnd ends the messages received while offline
+	rplHistory         = replyCode{244, "RPL_HISTORY"}          // rplHistory is a stored message in a page of /HISTORY
+	rplMoreHistory     = replyCode{245, "RPL_MORE_HISTORY"}     // rplMoreHistory tells how to fetch the next page of /HISTORY
+	rplEndOfHistory    = replyCode{246, "RPL_END_OF_HISTORY"}   // rplEndOfHistory reports that there is no more history
+	rplSearchResult    = replyCode{247, "RPL_SEARCH_RESULT"}    // rplSearchResult is a stored message matching a /SEARCH
+	rplMoreResults     = replyCode{248, "RPL_MORE_RESULTS"}     // rplMoreResults tells how to fetch the next page of /SEARCH
+	rplEndOfSearch     = replyCode{249, "RPL_END_OF_SEARCH"}    // rplEndOfSearch reports that there are no more matches
+	rplTranscriptStart = replyCode{250, "RPL_TRANSCRIPT_START"} // rplTranscriptStart begins an /EXPORT transcript
+	rplTranscript      = replyCode{251, "RPL_TRANSCRIPT"}       // rplTranscript is a line of an /EXPORT transcript
+	rplTranscriptEnd   = replyCode{252, "RPL_TRANSCRIPT_END"}   // rplTranscriptEnd ends an /EXPORT transcript
+	rplArchived        = replyCode{253, "RPL_ARCHIVED"}         // rplArchived confirms an /ARCHIVE export
+	rplUptime          = replyCode{260, "RPL_UPTIME"}           // rplUptime reports how long the server has been up
+	rplVersion         = replyCode{261, "RPL_VERSION"}          // rplVersion reports the server's version and features
+	rplTime            = replyCode{262, "RPL_TIME"}             // rplTime reports the server's time
+	rplMotdStart       = replyCode{263, "RPL_MOTD_START"}       // rplMotdStart begins the message of the day
+	rplMotd            = replyCode{264, "RPL_MOTD"}             // rplMotd is a line of the message of the day
+	rplMotdEnd         = replyCode{265, "RPL_MOTD_END"}         // rplMotdEnd ends the message of the day
+	rplTop             = replyCode{266, "RPL_TOP"}              // rplTop lists the most active users
+	rplLag             = replyCode{267, "RPL_LAG"}              // rplLag reports the client's latency
+	rplHelp            = replyCode{268, "RPL_HELP"}             // rplHelp is a line of /HELP
+	rplBan             = replyCode{270, "RPL_BAN"}              // rplBan confirms a server ban or its removal
+	rplMute            = replyCode{271, "RPL_MUTE"}             // rplMute confirms a mute or its removal
+	rplShadowban       = replyCode{272, "RPL_SHADOWBAN"}        // rplShadowban confirms a shadow-ban or its removal
+	rplRole            = replyCode{273, "RPL_ROLE"}             // rplRole confirms a change of role
+	rplToken           = replyCode{274, "RPL_TOKEN"}            // rplToken confirms creating or revoking a bot token
+	rplTokenList       = replyCode{275, "RPL_TOKEN_LIST"}       // rplTokenList lists the bot tokens
+	rplCertificate     = replyCode{276, "RPL_CERTIFICATE"}      // rplCertificate confirms binding or unbinding a client certificate
+	rplCertificateList = replyCode{277, "RPL_CERTIFICATE_LIST"} // rplCertificateList lists the certificates bound to an account
+	rplStats           = replyCode{278, "RPL_STATS"}            // rplStats is a line of live server figures from /STATS
+)
+
+// Notices: 3xx when another user or the server does something that concerns the client, unprompted by its own command.
+var (
+	rplRoleChanged = replyCode{300, "RPL_ROLE_CHANGED"} // rplRoleChanged tells a user their role was changed
+	rplMuted       = replyCode{301, "RPL_MUTED"}        // rplMuted tells a user they were muted
+	rplUnmuted     = replyCode{302, "RPL_UNMUTED"}      // rplUnmuted tells a user their mute was lifted or ran out
+	rplLinkFlagged = replyCode{303, "RPL_LINK_FLAGGED"} // rplLinkFlagged tells moderators about a message linking to a blocked domain
+)
+
+// Errors: 4xx when a command can't be carried out as given, 5xx when the server failed to carry it out.
+var (
+	errUnknownCommand       = replyCode{401, "ERR_UNKNOWN_COMMAND"}        // errUnknownCommand refuses a command the server doesn't know
+	errUsage                = replyCode{402, "ERR_USAGE"}                  // errUsage refuses a command given the wrong arguments, showing its usage
+	errNeedMoreParams       = replyCode{403, "ERR_NEED_MORE_PARAMS"}       // errNeedMoreParams refuses a command missing an argument
+	errInvalidArgument      = replyCode{404, "ERR_INVALID_ARGUMENT"}       // errInvalidArgument refuses a command with an argument out of range or malformed
+	errLineTooLong          = replyCode{405, "ERR_LINE_TOO_LONG"}          // errLineTooLong refuses a line longer than the server accepts
+	errNotRegistered        = replyCode{406, "ERR_NOT_REGISTERED"}         // errNotRegistered refuses commands from clients without a nickname
+	errNotLoggedIn          = replyCode{407, "ERR_NOT_LOGGED_IN"}          // errNotLoggedIn refuses commands that need an account login
+	errInvalidNickname      = replyCode{408, "ERR_INVALID_NICKNAME"}       // errInvalidNickname refuses a malformed nickname
+	errNicknameInUse        = replyCode{409, "ERR_NICKNAME_IN_USE"}        // errNicknameInUse refuses a nickname someone else is using
+	errNicknameProtected    = replyCode{410, "ERR_NICKNAME_PROTECTED"}     // errNicknameProtected refuses a registered nickname without its password
+	errNoSuchNick           = replyCode{411, "ERR_NO_SUCH_NICK"}           // errNoSuchNick refuses a nickname that isn't connected
+	errWasNoSuchNick        = replyCode{412, "ERR_WAS_NO_SUCH_NICK"}       // errWasNoSuchNick reports that a nickname hasn't been seen
+	errNoSuchAccount        = replyCode{413, "ERR_NO_SUCH_ACCOUNT"}        // errNoSuchAccount refuses a nickname without an account
+	errPasswordMismatch     = replyCode{414, "ERR_PASSWORD_MISMATCH"}      // errPasswordMismatch refuses an incorrect password
+	errInvalidToken         = replyCode{415, "ERR_INVALID_TOKEN"}          // errInvalidToken refuses an unknown or expired token
+	errNoSuchToken          = replyCode{416, "ERR_NO_SUCH_TOKEN"}          // errNoSuchToken refuses a bot token name that doesn't exist
+	errAlreadyExists        = replyCode{417, "ERR_ALREADY_EXISTS"}         // errAlreadyExists refuses to create an account, token, or binding that exists
+	errNoChange             = replyCode{418, "ERR_NO_CHANGE"}              // errNoChange refuses to set a state that already holds, or clear one that doesn't
+	errInvalidTarget        = replyCode{419, "ERR_INVALID_TARGET"}         // errInvalidTarget refuses a command aimed at a user it can't apply to
+	errNoCertificate        = replyCode{420, "ERR_NO_CERTIFICATE"}         // errNoCertificate refuses certificate commands without a TLS client certificate
+	errSSOFailed            = replyCode{421, "ERR_SSO_FAILED"}             // errSSOFailed reports a failed single sign-on
+	errInvalidChannel       = replyCode{430, "ERR_INVALID_CHANNEL"}        // errInvalidChannel refuses a malformed channel name
+	errNoSuchChannel        = replyCode{431, "ERR_NO_SUCH_CHANNEL"}        // errNoSuchChannel refuses a channel that doesn't exist
+	errNotInChannel         = replyCode{432, "ERR_NOT_IN_CHANNEL"}         // errNotInChannel refuses commands for a channel the client isn't in
+	errAlreadyInChannel     = replyCode{433, "ERR_ALREADY_IN_CHANNEL"}     // errAlreadyInChannel refuses to join a channel the client is in
+	errUserNotInChannel     = replyCode{434, "ERR_USER_NOT_IN_CHANNEL"}    // errUserNotInChannel refuses commands for a user who isn't in the channel
+	errBannedFromChannel    = replyCode{435, "ERR_BANNED_FROM_CHANNEL"}    // errBannedFromChannel refuses a user banned from the channel
+	errInviteOnly           = replyCode{436, "ERR_INVITE_ONLY"}            // errInviteOnly refuses to join an invite-only channel without an invitation
+	errNotInviteOnly        = replyCode{437, "ERR_NOT_INVITE_ONLY"}        // errNotInviteOnly refuses to knock on a channel anyone can join
+	errBadChannelKey        = replyCode{438, "ERR_BAD_CHANNEL_KEY"}        // errBadChannelKey refuses to join with a wrong or missing key
+	errChannelFull          = replyCode{439, "ERR_CHANNEL_FULL"}           // errChannelFull refuses to join a channel at its member limit
+	errNotChannelOperator   = replyCode{440, "ERR_NOT_CHANNEL_OPERATOR"}   // errNotChannelOperator refuses commands for channel operators
+	errUnknownMode          = replyCode{441, "ERR_UNKNOWN_MODE"}           // errUnknownMode refuses a mode the server doesn't know
+	errChannelNotRegistered = replyCode{442, "ERR_CHANNEL_NOT_REGISTERED"} // errChannelNotRegistered refuses modes for registered channels only
+	errModerated            = replyCode{443, "ERR_MODERATED"}              // errModerated refuses messages to a moderated channel from members without voice
+	errSlowMode             = replyCode{444, "ERR_SLOW_MODE"}              // errSlowMode refuses messages sent before a channel's slow mode allows
+	errForbidden            = replyCode{450, "ERR_FORBIDDEN"}              // errForbidden refuses commands a bot token's scopes don't permit
+	errNoPrivileges         = replyCode{451, "ERR_NO_PRIVILEGES"}          // errNoPrivileges refuses commands the user's role doesn't permit
+	errMuted                = replyCode{452, "ERR_MUTED"}                  // errMuted refuses messages from muted users
+	errBlockedLink          = replyCode{453, "ERR_BLOCKED_LINK"}           // errBlockedLink refuses messages linking to a blocked domain
+	errRepeated             = replyCode{454, "ERR_REPEATED"}               // errRepeated refuses a message repeated too many times
+	errQueueFull            = replyCode{455, "ERR_QUEUE_FULL"}             // errQueueFull refuses messages to an offline user with too many waiting
+	errRateLimited          = replyCode{456, "ERR_RATE_LIMITED"}           // errRateLimited refuses a command repeated sooner than allowed
+	errThrottled            = replyCode{457, "ERR_THROTTLED"}              // errThrottled warns that messages are being delayed for flooding
+	errDisabled             = replyCode{458, "ERR_DISABLED"}               // errDisabled refuses a feature this server doesn't enable
+	errNoMotd               = replyCode{459, "ERR_NO_MOTD"}                // errNoMotd reports that there is no message of the day
+	errBanned               = replyCode{460, "ERR_BANNED"}                 // errBanned closes the connection of a banned client
+	errTooManyConnections   = replyCode{461, "ERR_TOO_MANY_CONNECTIONS"}   // errTooManyConnections closes a connection over the limit for its address
+	errFlooding             = replyCode{462, "ERR_FLOODING"}               // errFlooding closes the connection of a client sending too much
+	errTimeout              = replyCode{463, "ERR_TIMEOUT"}                // errTimeout reports a deadline the client missed
+	errServerFull           = replyCode{464, "ERR_SERVER_FULL"}            // errServerFull closes a connection the server has no room for
+	errKicked               = replyCode{465, "ERR_KICKED"}                 // errKicked closes the connection of a user kicked from the server
+	errGhosted              = replyCode{466, "ERR_GHOSTED"}                // errGhosted closes a session the nickname's owner disconnected
+	errUnavailable          = replyCode{500, "ERR_UNAVAILABLE"}            // errUnavailable reports a command the server failed to carry out
+)
+
+// replyPattern matches a numeric reply line, capturing its number, name, and text.
+var replyPattern = regexp.MustCompile(`^(\d{3}) ([A-Z_]+) :(.*)$`)
+
+// sendReply sends a client a numeric reply.
+func sendReply(conn net.Conn, code replyCode, text string) {
+
+	fmt.Fprintf(conn, "%03d %s :%s\n", code.number, code.name, text)
+}
+
+// sendReplyf sends a client a numeric reply, formatting its text.
+func sendReplyf(conn net.Conn, code replyCode, format string, arguments ...any) {
+
+	sendReply(conn, code, fmt.Sprintf(format, arguments...))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// RetentionPolicy limits how long, and how many, stored messages are kept. Zero fields set no limit.
+type RetentionPolicy struct {
+	Days     int `json:"days,omitempty"`     // Days is how many days messages are kept
+	Messages int `json:"messages,omitempty"` // Messages is the most messages kept per channel; it doesn't apply to direct messages or broadcasts
+}
+
+// Retention decides which stored messages are deleted: those outside the default policy,
+// or outside the override of the channel they were sent to.
+type Retention struct {
+	Default  RetentionPolicy
+	Channels map[string]RetentionPolicy // Channels maps channel names to policies that replace the default
+	Now      time.Time                  // Now is the time message ages are measured from
+}
+
+// isEmpty reports whether the retention deletes nothing.
+func (retention Retention) isEmpty() bool {
+
+	return retention.Default == RetentionPolicy{} && len(retention.Channels) == 0
+}
+
+// policy returns the policy applied to the messages of a channel, or to direct messages and broadcasts when channel is empty.
+func (retention Retention) policy(channel string) RetentionPolicy {
+
+	if policy, exists := retention.Channels[channel]; exists {
+		return policy
+	}
+	return retention.Default
+}
+
+// expired reports whether a stored message falls outside its policy, given how many newer messages its channel has.
+func (retention Retention) expired(message StoredMessage, newer int) bool {
+
+	policy := retention.policy(message.Channel)
+	if policy.Days > 0 && message.SentAt.Before(retention.Now.AddDate(0, 0, -policy.Days)) {
+		return true
+	}
+	return message.Channel != "" && policy.Messages > 0 && newer >= policy.Messages
+}
+
+// retention returns the server's retention, including the overrides of registered channels. It must run on the hub goroutine.
+func (server *ChatServer) retention() Retention {
+
+	retention := Retention{
+		Default:  RetentionPolicy{Days: server.config.RetentionDays, Messages: server.config.RetentionMessages},
+		Channels: make(map[string]RetentionPolicy),
+		Now:      time.Now(),
+	}
+	for name, channel := range server.channels {
+		if channel.registered && channel.retention != nil {
+			retention.Channels[name] = *channel.retention
+		}
+	}
+	return retention
+}
+
+// pruneMessages periodically deletes stored messages that fall outside the retention policies.
+// It runs for the lifetime of the server.
+func (server *ChatServer) pruneMessages(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var retention Retention
+		server.onHub(func() {
+			retention = server.retention()
+		})
+
+		if retention.isEmpty() {
+			continue
+		}
+
+		// Pruning is done off the hub goroutine, since the storage may have to scan a database
+		deleted, err := server.storage.PruneMessages(retention)
+		if err != nil {
+			slog.Error("Failed to prune stored messages", "err", err)
+			continue
+		}
+		if deleted > 0 {
+			slog.Info("Pruned stored messages", "count", deleted)
+		}
+	}
+}
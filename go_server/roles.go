@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+)
+
+// Role is a user's rank on the server. Higher roles hold every permission of the roles below them.
+type Role int
+
+const (
+	RoleGuest     Role = iota // RoleGuest is anyone not logged in to an account
+	RoleUser                  // RoleUser is the default role of logged-in account holders
+	RoleModerator             // RoleModerator can kick and mute users
+	RoleAdmin                 // RoleAdmin can also ban users, manage bot tokens, assign roles, and export archives
+	RoleOwner                 // RoleOwner holds every permission; server operators are owners
+)
+
+// roleNames maps each role to the name used in commands and the accounts file.
+var roleNames = map[Role]string{
+	RoleGuest:     "guest",
+	RoleUser:      "user",
+	RoleModerator: "moderator",
+	RoleAdmin:     "admin",
+	RoleOwner:     "owner",
+}
+
+// String returns the role's name.
+func (role Role) String() string {
+	return roleNames[role]
+}
+
+// parseRole looks up a role by name.
+func parseRole(name string) (Role, bool) {
+
+	for role, roleName := range roleNames {
+		if strings.EqualFold(name, roleName) {
+			return role, true
+		}
+	}
+	return RoleGuest, false
+}
+
+// Permission is something a role allows its holders to do.
+type Permission int
+
+const (
+	PermKick           Permission = iota // PermKick allows disconnecting users with /KICK
+	PermMute                             // PermMute allows /MUTE, /UNMUTE, /SHADOWBAN, and /UNSHADOWBAN
+	PermViewAddresses                    // PermViewAddresses reveals users' addresses and link filter reports
+	PermBan                              // PermBan allows /BAN and /UNBAN
+	PermManageTokens                     // PermManageTokens allows creating and revoking bot tokens with /TOKEN
+	PermAssignRoles                      // PermAssignRoles allows assigning lower roles with /ROLE
+	PermExportArchives                   // PermExportArchives allows exporting stored messages with /ARCHIVE
+	PermViewStats                        // PermViewStats allows viewing live server figures with /STATS
+)
+
+// rolePermissions is the permission matrix: the permissions each role grants.
+var rolePermissions = map[Role][]Permission{
+	RoleGuest:     {},
+	RoleUser:      {},
+	RoleModerator: {PermKick, PermMute, PermViewAddresses},
+	RoleAdmin:     {PermKick, PermMute, PermViewAddresses, PermBan, PermManageTokens, PermAssignRoles, PermExportArchives},
+	RoleOwner:     {PermKick, PermMute, PermViewAddresses, PermBan, PermManageTokens, PermAssignRoles, PermExportArchives, PermViewStats},
+}
+
+// roleOf returns a connection's role: server operators are owners, account holders have the role
+// stored with their account, and everyone else is a guest. It must run on the hub goroutine.
+func (server *ChatServer) roleOf(conn net.Conn) Role {
+
+	client, exists := server.clients[conn]
+	if !exists {
+		return RoleGuest
+	}
+	if client.operator {
+		return RoleOwner
+	}
+	if client.account == "" {
+		return RoleGuest
+	}
+
+	account, registered := server.accounts.Get(client.account)
+	if !registered {
+		return RoleGuest
+	}
+	return account.Role
+}
+
+// outranks reports whether a connection's role is above a target's, as moderating another user requires,
+// replying with why not if it isn't. It must run on the hub goroutine.
+func (server *ChatServer) outranks(conn net.Conn, target net.Conn, action string) bool {
+
+	role, targetRole := server.roleOf(conn), server.roleOf(target)
+	if targetRole < role {
+		return true
+	}
+	sendReplyf(conn, errNoPrivileges, "Your role (%s) cannot %s %s (%s)", role, action, server.users.nickname(target), targetRole)
+	return false
+}
+
+// hasPermission reports whether a connection's role grants a permission. It must run on the hub goroutine.
+func (server *ChatServer) hasPermission(conn net.Conn, permission Permission) bool {
+
+	return slices.Contains(rolePermissions[server.roleOf(conn)], permission)
+}
+
+// authorizeCommand checks a restricted command against the permission matrix, telling the client when it is refused.
+// Commands that aren't restricted are open to everyone.
+func (server *ChatServer) authorizeCommand(conn net.Conn, command *Command, args []string) bool {
+
+	// Channel kicks are governed by channel roles rather than server roles
+	if command.Name == KICK && isChannelName(args[0]) {
+		return true
+	}
+
+	if !command.Restricted {
+		return true
+	}
+
+	permitted := false
+	server.onHub(func() {
+		permitted = server.hasPermission(conn, command.Permission)
+		if !permitted {
+			sendReplyf(conn, errNoPrivileges, "Your role (%s) does not permit %s", server.roleOf(conn), command.Name)
+		}
+	})
+	return permitted
+}
+
+// handleRoleCommand assigns a role to a registered account. Users can only assign roles below their own,
+// to accounts whose current role is also below their own.
+func (server *ChatServer) handleRoleCommand(conn net.Conn, nickname string, roleName string) {
+
+	role, valid := parseRole(roleName)
+	if !valid || role == RoleGuest {
+		sendReplyf(conn, errInvalidArgument, "Invalid role %s: use user, moderator, admin, or owner", roleName)
+		return
+	}
+
+	server.onHub(func() {
+		account, registered := server.accounts.Get(nickname)
+		if !registered {
+			sendReplyf(conn, errNoSuchAccount, "%s is not a registered nickname", nickname)
+			return
+		}
+
+		assignerRole := server.roleOf(conn)
+		if role >= assignerRole || account.Role >= assignerRole {
+			sendReplyf(conn, errNoPrivileges, "Your role (%s) cannot change %s from %s to %s", assignerRole, nickname, account.Role, role)
+			return
+		}
+
+		if err := server.accounts.SetRole(nickname, role); err != nil {
+			slog.Error("Failed to set role", "account", nickname, "err", err)
+			sendReply(conn, errUnavailable, "Failed to change role")
+			return
+		}
+
+		sendReplyf(conn, rplRole, "%s is now %s", nickname, role)
+		server.clientLog(conn).Info("Changed role", "account", nickname, "from", account.Role, "to", role)
+
+		for userConn, client := range server.clients {
+			if client.account == nickname && userConn != conn {
+				sendReplyf(userConn, rplRoleChanged, "%s made you %s", server.users.nickname(conn), role)
+			}
+		}
+	})
+}
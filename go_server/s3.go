@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Timeout bounds each request to the object store, including transferring a backup.
+const s3Timeout = 5 * time.Minute
+
+// s3Client is a minimal client for S3-compatible object stores, covering what backups need: putting,
+// getting, listing, and deleting objects in one bucket. Requests use path-style URLs, which every
+// S3-compatible store accepts, and are signed with AWS Signature Version 4.
+type s3Client struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// s3ListResult is the part of a ListObjectsV2 response the client reads.
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// newS3Client creates a client for a bucket in the object store at endpoint.
+func newS3Client(endpoint string, bucket string, region string, accessKey string, secretKey string) (*s3Client, error) {
+
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("object store endpoint %q must be an http or https URL", endpoint)
+	}
+
+	return &s3Client{
+		endpoint:  parsed,
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: s3Timeout},
+	}, nil
+}
+
+// putObject uploads an object, replacing any object with the same key.
+func (client *s3Client) putObject(key string, body []byte) error {
+
+	response, err := client.do(http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// getObject downloads an object.
+func (client *s3Client) getObject(key string) ([]byte, error) {
+
+	response, err := client.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	return io.ReadAll(response.Body)
+}
+
+// deleteObject deletes an object. Deleting a missing object is not an error.
+func (client *s3Client) deleteObject(key string) error {
+
+	response, err := client.do(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// listObjects returns the keys of every object starting with prefix, in the store's order, which is by key.
+func (client *s3Client) listObjects(prefix string) ([]string, error) {
+
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+
+	for {
+		response, err := client.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// do sends a signed request for an object, or for the bucket when key is empty, returning an error
+// for any response other than a success.
+func (client *s3Client) do(method string, key string, query url.Values, body []byte) (*http.Response, error) {
+
+	path := "/" + s3Escape(client.bucket)
+	if key != "" {
+		path += "/" + s3Escape(key)
+	}
+
+	// The endpoint may have a path of its own, such as when the store is served behind a proxy
+	target := *client.endpoint
+	escapedBase := strings.TrimSuffix(target.EscapedPath(), "/")
+	target.Path = strings.TrimSuffix(target.Path, "/") + path
+	target.RawPath = escapedBase + path
+	target.RawQuery = s3Query(query)
+
+	request, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := sha256.Sum256(body)
+	client.sign(request, target.RawPath, hex.EncodeToString(payloadHash[:]), time.Now())
+
+	response, err := client.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		response.Body.Close()
+		return nil, fmt.Errorf("object store returned %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	return response, nil
+}
+
+// sign adds AWS Signature Version 4 headers to a request, signing the host and every header already set.
+func (client *s3Client) sign(request *http.Request, canonicalPath string, payloadHash string, now time.Time) {
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		canonicalPath,
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + client.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+client.secretKey), date)
+	key = hmacSHA256(key, client.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		client.accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key.
+func hmacSHA256(key []byte, data string) []byte {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes a bucket name or key for a URL path the way Signature Version 4 expects:
+// everything except unreserved characters and slashes.
+func s3Escape(value string) string {
+
+	var escaped strings.Builder
+	for _, b := range []byte(value) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') || strings.IndexByte("-_.~/", b) >= 0 {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// s3Query encodes a query string the way Signature Version 4 expects, sorted by name with spaces as %20.
+func s3Query(query url.Values) string {
+
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// searchPageSize is the number of matches in a /SEARCH page.
+const searchPageSize = 20
+
+// searchBeforePrefix marks the /SEARCH term giving the message ID a page of matches runs back from.
+const searchBeforePrefix = "before:"
+
+// containsTerms reports whether a message body contains every search term, ignoring case.
+func containsTerms(body string, terms []string) bool {
+
+	body = strings.ToLower(body)
+	for _, term := range terms {
+		if !strings.Contains(body, strings.ToLower(term)) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleSearchCommand sends a page of the stored messages of a channel the user has joined that contain every
+// word of a query, latest last. A "before:<id>" word pages back from the match before a given message ID.
+func (server *ChatServer) handleSearchCommand(conn net.Conn, channelName string, searchArgs string) {
+
+	var terms []string
+	var beforeID int64
+	for _, field := range strings.Fields(searchArgs) {
+		if !strings.HasPrefix(field, searchBeforePrefix) {
+			terms = append(terms, field)
+			continue
+		}
+
+		parsed, err := strconv.ParseInt(strings.TrimPrefix(field, searchBeforePrefix), 10, 64)
+		if err != nil || parsed < 1 {
+			sendReplyf(conn, errInvalidArgument, "Invalid message ID %s", strings.TrimPrefix(field, searchBeforePrefix))
+			return
+		}
+		beforeID = parsed
+	}
+
+	if !isChannelName(channelName) || len(terms) == 0 {
+		sendReplyf(conn, errUsage, "Usage: %s <channel> <words> [%s<id>]", SEARCH, searchBeforePrefix)
+		return
+	}
+
+	var nickname string
+	var registered, member bool
+	server.onHub(func() {
+		nickname, registered = server.users.lookup(conn)
+		channel, exists := server.channels[channelName]
+		member = exists && channel.isMember(conn)
+	})
+
+	if !registered {
+		sendReply(conn, errNotRegistered, "You must register a nickname before you can search")
+		return
+	}
+	if !member {
+		sendReplyf(conn, errNotInChannel, "You're not in %s", channelName)
+		return
+	}
+
+	// Searching is done off the hub goroutine, since the storage may have to query a database
+	messages, err := server.storage.SearchMessages(channelName, terms, beforeID, searchPageSize)
+	if err != nil {
+		slog.Error("Failed to search channel", "channel", channelName, "nickname", nickname, "err", err)
+		sendReply(conn, errUnavailable, "Search is unavailable right now; try again later")
+		return
+	}
+
+	query := strings.Join(terms, " ")
+	if len(messages) == 0 {
+		if beforeID != 0 {
+			sendReplyf(conn, rplEndOfSearch, "No more matches for %q in %s", query, channelName)
+		} else {
+			sendReplyf(conn, rplEndOfSearch, "No matches for %q in %s", query, channelName)
+		}
+		return
+	}
+
+	for _, message := range messages {
+		sendReplyf(conn, rplSearchResult, "%d [%s] %s", message.ID, message.SentAt.Format("Jan 2 15:04:05"), message.Body)
+	}
+
+	if len(messages) == searchPageSize {
+		sendReplyf(conn, rplMoreResults, "For earlier matches use %s %s %s %s%d", SEARCH, channelName, query, searchBeforePrefix, messages[0].ID)
+	}
+}
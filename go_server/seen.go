@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"time"
+)
+
+// seenQueueSize is the number of last-seen records waiting to be saved before new ones are dropped.
+const seenQueueSize = 1024
+
+// loadSeenIndex reads the last-seen index from the server's storage.
+func (server *ChatServer) loadSeenIndex() error {
+
+	users, err := server.storage.LoadUsers()
+	if err != nil {
+		return err
+	}
+
+	server.onHub(func() {
+		for _, user := range users {
+			server.seen[user.Nickname] = &user
+		}
+	})
+	return nil
+}
+
+// recordSeen marks a nickname as last seen now and queues its record to be saved. If saving has fallen
+// behind the save is dropped rather than holding up the hub. It must run on the hub goroutine.
+func (server *ChatServer) recordSeen(nickname string) {
+
+	record, exists := server.seen[nickname]
+	if !exists {
+		record = &UserRecord{Nickname: nickname}
+		server.seen[nickname] = record
+	}
+	record.LastSeen = time.Now()
+
+	select {
+
+		case server.seenSaves <- *record:
+
+		default:
+			slog.Warn("Last-seen save queue is full; dropped record", "nickname", nickname)
+	}
+}
+
+// saveSeenRecords saves the last-seen records queued by recordSeen, in the order they were queued.
+// It runs for the lifetime of the server.
+func (server *ChatServer) saveSeenRecords() {
+
+	for record := range server.seenSaves {
+		if err := server.storage.SaveUser(record); err != nil {
+			slog.Error("Failed to save last-seen record", "nickname", record.Nickname, "err", err)
+		}
+	}
+}
+
+// recordLastMessage remembers the last public message a nickname sent. Private messages are never recorded.
+// The index is persisted the next time the nickname is seen leaving. It must run on the hub goroutine.
+func (server *ChatServer) recordLastMessage(nickname string, text string) {
+
+	record, exists := server.seen[nickname]
+	if !exists {
+		record = &UserRecord{Nickname: nickname}
+		server.seen[nickname] = record
+	}
+	record.LastSeen = time.Now()
+	record.LastMessage = text
+}
+
+// handleSeenCommand reports when a nickname was last connected and what it last said publicly,
+// or that it is currently online.
+func (server *ChatServer) handleSeenCommand(conn net.Conn, nickname string) {
+
+	server.onHub(func() {
+		record, known := server.seen[nickname]
+
+		if _, online := server.findUserByNickname(nickname); online {
+			sendReplyf(conn, rplSeen, "%s is currently online", nickname)
+		} else if known {
+			sendReplyf(conn, rplSeen, "%s was last seen %s ago, at %s", nickname, time.Since(record.LastSeen).Round(time.Second), record.LastSeen.Format(time.RFC1123))
+		} else {
+			sendReplyf(conn, errWasNoSuchNick, "I haven't seen %s", nickname)
+			return
+		}
+
+		if known && record.LastMessage != "" {
+			sendReplyf(conn, rplSeen, "Last message: %s", record.LastMessage)
+		}
+	})
+}
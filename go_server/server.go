@@ -2,12 +2,19 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"math"
 	"net"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
 )
 
@@ -20,10 +27,67 @@ const (
 	UserLeavesServer
 )
 
+// MessageType represents the kind of message a user sends to other users
+type MessageType int
+
+const (
+	SpeechMessage MessageType = iota
+	ActionMessage
+)
+
 // ChatServer represents a server capable of handling chat messages between users.
+// The fields up to hub belong to the hub goroutine and are only touched by work run on it.
 type ChatServer struct {
-	users map[net.Conn]string // users maps network connections to user nicknames
-	mutex sync.Mutex          // mutex protects access to the users map
+	users            *userRegistry                // users maps network connections to user nicknames; it may also be read off the hub goroutine
+	clients          map[net.Conn]*Client         // clients maps every open connection to its metadata
+	channels         map[string]*Channel          // channels maps channel names to their channels
+	watchers         map[string]map[net.Conn]bool // watchers maps nicknames to the connections watching them with /NOTIFY
+	seen             map[string]*UserRecord       // seen maps nicknames to when they were last connected, for /SEEN
+	whowas           []whowasEntry                // whowas holds recently given up nicknames, oldest first
+	motd             []string                     // motd holds the lines of the message of the day
+	activity         map[string]map[string]int    // activity maps nicknames to their message counts per day, for /TOP
+	activityChanged  bool                         // activityChanged is set when activity has unsaved changes
+	connsPerIP       map[string]int               // connsPerIP counts open connections for each remote IP
+	bans             map[string]ipBan             // bans maps banned remote IPs to their bans
+	mutes            map[string]*mute             // mutes maps muted nicknames to their mutes
+	shadowBans       map[string]bool              // shadowBans holds the nicknames whose messages are silently dropped
+	failures         map[string][]time.Time       // failures records recent invalid commands and failed /OPER attempts per remote IP
+	blockedDomains   map[string]bool              // blockedDomains is the set of link domains the link filter rejects
+	tokens           map[string]*authToken        // tokens maps bot names to their authentication tokens
+	ssoLogins        map[string]*ssoLogin         // ssoLogins maps OAuth state values to pending /SSO logins
+	sessions         map[string]net.Conn          // sessions maps session tokens to the connection currently holding the session
+	offline          map[string][]offlineMessage  // offline maps registered nicknames to the direct messages held while they were offline
+	streams          map[*streamSubscriber]bool   // streams holds the open HTTP API message streams
+	startedAt        time.Time                    // startedAt is when the server began listening
+	totalConnections int                          // totalConnections counts every connection accepted since startup
+	openConnections  int                          // openConnections counts the connections currently open, for the connection cap
+	listeners        []net.Listener               // listeners are the bound TCP and TLS listeners
+	lastMessageID    int64                        // lastMessageID is the ID of the latest message accepted
+	dedupIDs         map[string]*dedupRecord      // dedupIDs maps senders' dedup IDs to the messages sent with them, for the dedup window
+	dedupOrder       []*dedupRecord               // dedupOrder holds the dedup records, oldest first, so expired ones can be dropped
+	messageRate      rateMeter                    // messageRate counts the messages accepted over the last minute, for /STATS
+	hub              chan func()                  // hub receives the work to run on the hub goroutine, which alone touches the fields above
+
+	transcripts         map[net.Conn][]string // transcripts holds the lines delivered to each connection
+	transcriptMutex     sync.Mutex            // transcriptMutex protects access to the transcripts map
+	fanOutPool          *fanOutPool           // fanOutPool writes broadcasts to large numbers of recipients in parallel
+	acceptThrottle      *acceptThrottle       // acceptThrottle limits the rate connections are accepted at, nil when unlimited
+	acceptLoops         atomic.Int32          // acceptLoops counts the listeners whose connections are being accepted
+	configuredListeners atomic.Int32          // configuredListeners is the number of listeners serve runs accept loops for, TCP and QUIC
+	failingAccepts      atomic.Int32          // failingAccepts counts the accept loops whose latest Accept failed
+
+	accounts        AccountStore    // accounts stores registered accounts and checks their passwords
+	oidc            *oidcProvider   // oidc is the identity provider used by /SSO, nil when single sign-on is disabled
+	storage         Storage         // storage persists messages and user records
+	seenSaves       chan UserRecord // seenSaves queues last-seen records for saveSeenRecords to save, off the hub goroutine
+	channelStore    ChannelStore    // channelStore persists registered channels in the storage backend; nil uses the channels file
+	presence        PresenceStore   // presence shares who is online with other servers, nil when presence is kept in-process only
+	presenceUpdates chan func()     // presenceUpdates queues the updates sharePresence makes to presence, off the hub goroutine
+	chatLog         *chatLogger     // chatLog writes public traffic to log files, nil when chat logging is disabled
+	backups         *s3Client       // backups is the object store backups are uploaded to, nil when backups are disabled
+	tlsConfig       *tls.Config     // tlsConfig configures the TLS listener, nil when TLS is disabled
+
+	config Config // config holds the server's runtime settings
 }
 
 const (
@@ -31,102 +95,479 @@ const (
 	PORT = "4000"
 	TYPE = "tcp"
 
-	LIST = "/LIST"
-	NICK = "/NICK"
-	MSG  = "/MSG"
+	LIST     = "/LIST"
+	NICK     = "/NICK"
+	MSG      = "/MSG"
+	EXPORT   = "/EXPORT"
+	JOIN     = "/JOIN"
+	PART     = "/PART"
+	TOPIC    = "/TOPIC"
+	MODE     = "/MODE"
+	INVITE   = "/INVITE"
+	KICK     = "/KICK"
+	VOICE    = "/VOICE"
+	DEVOICE  = "/DEVOICE"
+	CBAN     = "/CBAN"
+	CUNBAN   = "/CUNBAN"
+	KNOCK    = "/KNOCK"
+	WHOIS    = "/WHOIS"
+	ME       = "/ME"
+	PING     = "/PING"
+	PONG     = "/PONG"
+	LAG      = "/LAG"
+	WHO      = "/WHO"
+	AWAY     = "/AWAY"
+	IGNORE   = "/IGNORE"
+	UNIGNORE = "/UNIGNORE"
+	NOTIFY   = "/NOTIFY"
+	UNNOTIFY = "/UNNOTIFY"
+	NAMES    = "/NAMES"
+	SEEN     = "/SEEN"
+	WHOWAS   = "/WHOWAS"
+	UPTIME   = "/UPTIME"
+	TIME     = "/TIME"
+	VERSION  = "/VERSION"
+	MOTD     = "/MOTD"
+	TOP      = "/TOP"
+	BAN      = "/BAN"
+	UNBAN    = "/UNBAN"
+	MUTE     = "/MUTE"
+	UNMUTE   = "/UNMUTE"
+	OPER     = "/OPER"
+	REGISTER = "/REGISTER"
+	LOGIN    = "/LOGIN"
+	GHOST    = "/GHOST"
+	AUTH     = "/AUTH"
+	TOKEN    = "/TOKEN"
+	CERT     = "/CERT"
+	SSO      = "/SSO"
+	RESUME   = "/RESUME"
+	ROLE     = "/ROLE"
+	HISTORY  = "/HISTORY"
+	ARCHIVE  = "/ARCHIVE"
+	STATS    = "/STATS"
+	SEARCH   = "/SEARCH"
+
+	SHADOWBAN   = "/SHADOWBAN"
+	UNSHADOWBAN = "/UNSHADOWBAN"
+
+	CAP    = "/CAP"
+	TYPING = "/TYPING"
+	HELP   = "/HELP"
 )
 
 // RegExp defined as global variable, so it's compiled once when program starts
 var validNicknamePattern = regexp.MustCompile("^[a-zA-Z0-9_]+$")
 
-// start initiates the chat server, listening for incoming TCP connections on the predefined host and port.
-// New connections are handled concurrently in separate goroutines.
+// start initiates the chat server, listening for incoming TCP connections on the configured addresses,
+// and for TLS connections on the TLS port of the same hosts when TLS is configured. New connections are
+// handled concurrently in separate goroutines.
 func (chatServer *ChatServer) start() {
 
-	listen, err := net.Listen(TYPE, HOST+":"+PORT)
+	if err := chatServer.listen(); err != nil {
+		fatal("Failed to start server", "err", err)
+	}
+	chatServer.serve()
+}
+
+// listen binds the TCP and TLS listeners, or takes over the sockets systemd passed in when the server is
+// socket-activated. It is separate from serve so that a caller binding port 0, such as a test, can learn
+// the ports actually bound from listenAddrs before connecting.
+func (server *ChatServer) listen() error {
+
+	activated, err := systemdListeners()
 	if err != nil {
-		log.Fatalf("Failed to start server: %v\n", err)
+		return err
+	}
+	if len(activated) > 0 {
+		return server.listenActivated(activated)
+	}
+
+	addresses := server.config.ListenAddrs
+	if len(addresses) == 0 {
+		addresses = []string{net.JoinHostPort(HOST, PORT)}
+	}
+
+	var listeners []net.Listener
+	tlsAddresses := make(map[string]bool)
+	closeAll := func() {
+		for _, listen := range listeners {
+			listen.Close()
+		}
+	}
+
+	for _, address := range addresses {
+		if !server.config.TLSOnly {
+			listen, err := net.Listen(TYPE, address)
+			if err != nil {
+				closeAll()
+				return err
+			}
+
+			listeners = append(listeners, listen)
+			slog.Info("Server started", "addr", listen.Addr().String())
+		}
+
+		if server.tlsConfig != nil {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				closeAll()
+				return err
+			}
+
+			// Addresses on the same host share its TLS listener
+			tlsAddress := net.JoinHostPort(host, server.config.TLSPort)
+			if tlsAddresses[tlsAddress] {
+				continue
+			}
+			tlsAddresses[tlsAddress] = true
+
+			listen, err := tls.Listen(TYPE, tlsAddress, server.tlsConfig)
+			if err != nil {
+				closeAll()
+				return fmt.Errorf("TLS listener: %w", err)
+			}
+
+			listeners = append(listeners, listen)
+			slog.Info("Server accepting TLS connections", "addr", listen.Addr().String())
+		}
+	}
+
+	server.onHub(func() {
+		server.listeners = listeners
+	})
+	return nil
+}
+
+// listenActivated uses the sockets systemd passed in instead of binding the configured addresses. Sockets
+// named "tls" with FileDescriptorName= accept TLS connections; the rest accept plaintext connections.
+func (server *ChatServer) listenActivated(activated []activatedListener) error {
+
+	listeners := make([]net.Listener, 0, len(activated))
+	for _, socket := range activated {
+		if socket.name != systemdTLSName {
+			listeners = append(listeners, socket.listener)
+			slog.Info("Server started on a socket-activated listener", "addr", socket.listener.Addr().String())
+			continue
+		}
+
+		if server.tlsConfig == nil {
+			for _, socket := range activated {
+				socket.listener.Close()
+			}
+			return fmt.Errorf("socket-activated TLS socket %s requires -tls-cert and -tls-key", socket.listener.Addr())
+		}
+		listeners = append(listeners, tls.NewListener(socket.listener, server.tlsConfig))
+		slog.Info("Server accepting TLS connections on a socket-activated listener", "addr", socket.listener.Addr().String())
+	}
+
+	server.onHub(func() {
+		server.listeners = listeners
+	})
+	return nil
+}
+
+// listenAddrs returns the addresses the TCP and TLS listeners are bound to, with the actual ports
+// of listeners configured with port 0.
+func (server *ChatServer) listenAddrs() []net.Addr {
+
+	var addrs []net.Addr
+	server.onHub(func() {
+		addrs = make([]net.Addr, 0, len(server.listeners))
+		for _, listen := range server.listeners {
+			addrs = append(addrs, listen.Addr())
+		}
+	})
+	return addrs
+}
+
+// serve starts the server's background tasks and accepts connections on the listeners bound by listen.
+// It runs for the lifetime of the server.
+func (chatServer *ChatServer) serve() {
+
+	var listeners []net.Listener
+	chatServer.onHub(func() {
+		listeners = chatServer.listeners
+		chatServer.startedAt = time.Now()
+	})
+
+	if chatServer.config.MotdFile != "" {
+		go chatServer.reloadMotdOnSignal()
+	}
+
+	if chatServer.config.ActivityFile != "" {
+		go chatServer.flushActivity()
+	}
+
+	if chatServer.oidc != nil {
+		go chatServer.serveOIDCCallback()
+	}
+
+	if chatServer.config.ChannelSweepInterval > 0 {
+		go chatServer.sweepEmptyChannels(chatServer.config.ChannelSweepInterval)
+	}
+
+	if chatServer.config.RetentionInterval > 0 {
+		go chatServer.pruneMessages(chatServer.config.RetentionInterval)
+	}
+
+	if chatServer.config.SnapshotFile != "" {
+		go chatServer.snapshotState(chatServer.config.SnapshotInterval)
 	}
 
-	defer listen.Close()
+	if chatServer.backups != nil {
+		go chatServer.backUpPeriodically()
+	}
+
+	if chatServer.config.HTTPListen != "" {
+		go chatServer.serveHTTP()
+	}
+
+	if chatServer.config.PprofListen != "" {
+		go chatServer.servePprof()
+	}
 
-	log.Printf("Server started on %s:%s\n", HOST, PORT)
+	if chatServer.config.GRPCListen != "" {
+		go chatServer.serveGRPC()
+	}
+
+	configured := len(listeners)
+	if chatServer.config.QUICListen != "" {
+		configured++
+	}
+	chatServer.configuredListeners.Store(int32(configured))
+
+	if chatServer.config.QUICListen != "" {
+		go chatServer.serveQUIC()
+	}
 
+	for _, listen := range listeners[1:] {
+		go chatServer.acceptConnections(listen)
+	}
+	chatServer.acceptConnections(listeners[0])
+}
+
+// acceptConnections accepts connections from a listener, serving each in its own goroutine. Accepts are
+// limited to the configured rate, and a failed Accept is retried after a pause that grows while it keeps failing.
+func (chatServer *ChatServer) acceptConnections(listen net.Listener) {
+
+	chatServer.acceptLoops.Add(1)
+	defer chatServer.acceptLoops.Add(-1)
+
+	backoff := acceptBackoff{failing: &chatServer.failingAccepts}
 	for {
+		chatServer.acceptThrottle.wait()
+
 		conn, err := listen.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
-			log.Printf("There was a problem connecting: %v\n", err)
+			delay := backoff.failed()
+			slog.Error("Failed to accept connection", "err", err, "retry_in", delay)
+			time.Sleep(delay)
 			continue
 		}
-		go chatServer.handleClientConnection(conn)
+		backoff.succeeded()
+		go chatServer.serveConnection(conn)
+	}
+}
+
+// serveConnection handles a new client connection of any transport until it closes, refusing banned
+// addresses and addresses over their connection limit. The client's first line negotiates its wire format.
+func (server *ChatServer) serveConnection(conn net.Conn) {
+
+	conn = newWireConn(conn, server.config)
+
+	if server.isIPBanned(remoteIP(conn)) {
+		slog.Info("Refused connection: address is banned", "remote", conn.RemoteAddr().String())
+		sendReply(conn, errBanned, "You are banned from this server")
+		conn.Close()
+		return
 	}
+
+	switch server.admitConnection(conn) {
+
+		case refusedForIP:
+			slog.Info("Refused connection: too many connections from address", "remote", conn.RemoteAddr().String())
+			sendReply(conn, errTooManyConnections, "Too many connections from your address")
+			conn.Close()
+			return
+
+		case refusedServerFull:
+			slog.Info("Refused connection: server is full", "remote", conn.RemoteAddr().String())
+			sendReply(conn, errServerFull, "The server is full, try again later")
+			conn.Close()
+			return
+	}
+	server.handleClientConnection(conn)
 }
 
 // handleClientConnection manages a single client connection, reading commands and responding appropriately.
 // It ensures the connection is closed when the function returns and broadcasts a disconnect message if applicable.
+// Auxiliary goroutines for the connection share a context that is canceled before the function returns;
+// canceling that context from any of them also closes the connection.
 func (server *ChatServer) handleClientConnection(conn net.Conn) {
 
-	log.Printf("Client %s connected to server\n", conn.RemoteAddr().String())
+	slog.Info("Client connected", "remote", conn.RemoteAddr().String())
 
 	defer conn.Close()
+	defer server.releaseConnection(conn)
+
+	lifecycle := newConnLifecycle()
+	defer lifecycle.Stop()
+
+	// Closing the connection unblocks the scanner below when the lifecycle is canceled early
+	lifecycle.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		conn.Close()
+	})
+
+	server.addClient(conn, lifecycle)
+	server.identifyByCertificate(conn)
+
+	if server.config.GuestNicknames {
+		server.renameToGuest(conn, func() bool {
+			_, registered := server.users.lookup(conn)
+			return !registered
+		})
+	}
+
+	if server.config.PingInterval > 0 || server.config.IdleTimeout > 0 {
+		lifecycle.Go(func(ctx context.Context) {
+			server.keepAlive(ctx, conn, lifecycle.cancel)
+		})
+	}
+
+	server.transcriptMutex.Lock()
+	server.transcripts[conn] = nil
+	server.transcriptMutex.Unlock()
 
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
-		sanitizedUserCommand := strings.Trim(scanner.Text(), " ")
+	flood := newFloodGuard(server.config)
+	resumable := true
+
+	reader := bufio.NewReader(conn)
+	var readErr error
+	for {
+		line, tooLong, err := readLine(reader, server.config.MaxLineLength)
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		if flood != nil && !flood.admit(lifecycle.ctx, conn) {
+			resumable = false
+			break
+		}
+
+		server.touchClient(conn)
+		if tooLong {
+			sendReply(conn, errLineTooLong, lineTooLongMessage(server.config.MaxLineLength))
+			continue
+		}
+
+		sanitizedUserCommand := strings.Trim(string(line), " ")
 		server.handleUserCommands(sanitizedUserCommand, conn)
 	}
 
-	// Check if client has left server; if so, delete them from client list
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading from %s: %v", conn.RemoteAddr(), err)
+	// Check if client has left server; if so, delete them from client list.
+	// Read errors caused by the lifecycle closing the connection count as a disconnect.
+	readFailed := false
+	if readErr != nil && !errors.Is(readErr, io.EOF) && lifecycle.ctx.Err() == nil {
+		server.clientLog(conn).Warn("Error reading from client", "err", readErr)
+		readFailed = true
 
 	} else {
-		log.Printf("Client %s disconnected\n", conn.RemoteAddr())
-		server.broadcastMsg(UserLeavesServer, conn, server.users[conn])
+		server.clientLog(conn).Info("Client disconnected")
 	}
 
-	server.mutex.Lock()
-	delete(server.users, conn)
-	server.mutex.Unlock()
+	// Resumable sessions are held for the grace period rather than ended now
+	server.onHub(func() {
+		if resumable && server.detachSession(conn) {
+			return
+		}
+		server.endSession(conn, !readFailed)
+	})
 }
 
-// handleUserCommands interprets and processes commands received from a user.
-// Supported commands are /NICK for setting a nickname, /LIST for listing users, and /MSG for messaging.
-func (server *ChatServer) handleUserCommands(userCommand string, conn net.Conn) {
+// admitConnection records a new connection against its remote IP, refusing it if the IP already holds the
+// maximum number of connections allowed by the configuration, or if the server is full and can't make room.
+func (server *ChatServer) admitConnection(conn net.Conn) admission {
 
-	args := strings.SplitN(userCommand, " ", 3)
+	ip := remoteIP(conn)
 
-	switch {
+	outcome := admitted
+	server.onHub(func() {
+		if server.config.MaxConnsPerIP > 0 && server.connsPerIP[ip] >= server.config.MaxConnsPerIP {
+			outcome = refusedForIP
+			return
+		}
+		if !server.admitsMoreConnections() {
+			outcome = refusedServerFull
+			return
+		}
 
-		case len(args) >= 1 && args[0] == LIST:
-			server.handleListCommand(conn)
+		server.connsPerIP[ip]++
+		server.openConnections++
+		server.totalConnections++
+	})
+	return outcome
+}
 
-		case len(args) >= 2 && args[0] == NICK:
-			desiredNickname := args[1]
-			server.handleNicknameCommand(conn, desiredNickname)
+// releaseConnection removes a closed connection from its remote IP's connection count.
+func (server *ChatServer) releaseConnection(conn net.Conn) {
 
-		case len(args) >= 3 && args[0] == MSG:
-			recipients := args[1]
-			message := args[2]
-			server.handleMessageCommand(conn, recipients, message)
+	ip := remoteIP(conn)
 
-		default:
-			fmt.Fprintln(conn, "Invalid command")
-	}
+	server.onHub(func() {
+		server.openConnections--
+		server.connsPerIP[ip]--
+		if server.connsPerIP[ip] <= 0 {
+			delete(server.connsPerIP, ip)
+		}
+	})
 }
 
-// handleListCommand sends a list of currently connected users to the requesting client.
-func (server *ChatServer) handleListCommand(conn net.Conn) {
+// remoteIP extracts the IP portion of a connection's remote address.
+func remoteIP(conn net.Conn) string {
 
-	server.mutex.Lock()
-	defer server.mutex.Unlock()
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
 
-	fmt.Fprint(conn, "Current users: ")
+// removeUser forgets a connection's nickname and channel memberships, recording the nickname for /SEEN and /WHOWAS
+// unless the user is still connected from another device. It is safe to call more than once for the same connection.
+// It must run on the hub goroutine.
+func (server *ChatServer) removeUser(conn net.Conn) {
 
-	for _, nickname := range server.users {
-		fmt.Fprint(conn, nickname, " ")
+	if nickname, registered := server.users.lookup(conn); registered && len(server.otherDevices(conn)) == 0 {
+		server.notifyWatchers(nickname, false)
+		server.recordSeen(nickname)
+		server.recordWhowas(conn, nickname)
+		server.releaseNickname(conn, nickname)
 	}
-	fmt.Fprintln(conn)
+	server.removeWatcher(conn)
+	server.users.remove(conn)
+	server.removeFromAllChannels(conn)
+}
+
+// handleListCommand sends a list of currently connected users to the requesting client.
+// It reads the user registry directly rather than waiting for the hub goroutine.
+func (server *ChatServer) handleListCommand(conn net.Conn) {
+
+	var nicknames []string
+	listed := make(map[string]bool)
+	server.users.each(func(_ net.Conn, nickname string) bool {
+		if !listed[nickname] {
+			listed[nickname] = true
+			nicknames = append(nicknames, nickname)
+		}
+		return true
+	})
+	sendReplyf(conn, rplUsers, "Current users: %s", strings.Join(nicknames, " "))
 }
 
 // handleNicknameCommand processes a request from a client to set or change their nickname,
@@ -135,34 +576,103 @@ func (server *ChatServer) handleNicknameCommand(conn net.Conn, desiredNickname s
 
 	validNickname, msg := validateNickname(desiredNickname)
 	if !validNickname {
-		fmt.Fprintln(conn, msg)
+		sendReply(conn, errInvalidNickname, msg)
 		return
 	}
 
-	server.mutex.Lock()
-	defer server.mutex.Unlock()
+	claim := server.claimNickname(desiredNickname)
+	server.onHubWithClaim(claim, func() {
+		if wait := server.renameCooldown(conn); wait > 0 {
+			sendReplyf(conn, errRateLimited, "You must wait %d seconds before changing nickname again", int(math.Ceil(wait.Seconds())))
+			return
+		}
 
-	for userConn, userNickname := range server.users {
-		if userNickname == desiredNickname {
-			if userConn == conn {
-				fmt.Fprintf(conn, "You're already registered as %s\n", desiredNickname)
-			} else {
-				fmt.Fprintf(conn, "%s already registered\n", desiredNickname)
-			}
+		// Without a grace period, registered nicknames can only be taken by logging in
+		if server.isNicknameProtected(conn, desiredNickname) && server.config.NickGracePeriod <= 0 {
+			sendReplyf(conn, errNicknameProtected, "%s is a registered nickname; use %s %s <password> to log in", desiredNickname, LOGIN, desiredNickname)
 			return
 		}
+
+		// Only renames the user asks for count towards the cooldown, not forced ones
+		_, renaming := server.users.lookup(conn)
+		if server.setNickname(conn, claim) && renaming {
+			server.clients[conn].lastRenamed = time.Now()
+		}
+	})
+}
+
+// setNickname sets or changes a client's nickname to one claimed with claimNickname, announcing the change,
+// unless the nickname is already in use. It reports whether the nickname was set. It must run on the hub goroutine.
+func (server *ChatServer) setNickname(conn net.Conn, claim nicknameClaim) bool {
+
+	desiredNickname := claim.nickname
+
+	if holder, inUse := server.findUserByNickname(desiredNickname); inUse {
+		if holder == conn || server.users.nickname(conn) == desiredNickname {
+			sendReplyf(conn, errNoChange, "You're already registered as %s", desiredNickname)
+		} else {
+			sendReplyf(conn, errNicknameInUse, "%s already registered", desiredNickname)
+		}
+		return false
 	}
 
-	if currentNickname, exists := server.users[conn]; exists {
-		fmt.Fprintf(conn, "You changed your nickname from %s to %s\n", currentNickname, desiredNickname)
+	if claim.err != nil {
+		slog.Error("Failed to claim shared nickname", "nickname", desiredNickname, "err", claim.err)
+		sendReply(conn, errUnavailable, "Nicknames can't be registered right now; try again later")
+		return false
+	}
+	if !claim.claimed {
+		sendReplyf(conn, errNicknameInUse, "%s already registered on another server", desiredNickname)
+		return false
+	}
+
+	currentNickname, exists := server.users.lookup(conn)
+	if exists {
+		sendReplyf(conn, rplNickname, "You changed your nickname from %s to %s", currentNickname, desiredNickname)
 		server.broadcastMsg(UserChangesNickname, conn, currentNickname, desiredNickname)
 
 	} else {
-		fmt.Fprintf(conn, "Nickname registered as %s\n", desiredNickname)
+		sendReplyf(conn, rplNickname, "Nickname registered as %s", desiredNickname)
 		server.broadcastMsg(UserJoinsServer, conn, desiredNickname)
 	}
 
-	server.users[conn] = desiredNickname
+	// Every device the user is connected from follows the rename
+	for _, device := range server.otherDevices(conn) {
+		server.users.set(device, desiredNickname)
+	}
+	server.users.set(conn, desiredNickname)
+
+	if exists {
+		server.releaseNickname(conn, currentNickname)
+		for _, channelName := range server.channelsOf(conn) {
+			server.joinPresence(channelName, desiredNickname)
+		}
+		server.moveMute(currentNickname, desiredNickname)
+		server.moveShadowban(currentNickname, desiredNickname)
+		server.notifyWatchers(currentNickname, false)
+		server.recordSeen(currentNickname)
+		server.recordWhowas(conn, currentNickname)
+	}
+	server.notifyWatchers(desiredNickname, true)
+
+	if server.isNicknameProtected(conn, desiredNickname) {
+		server.enforceNickname(conn, desiredNickname)
+	}
+
+	if !exists {
+		server.issueSessionToken(conn)
+	}
+
+	if !exists && len(server.motd) > 0 {
+		server.sendMotd(conn)
+	}
+
+	// Newly registered users are placed in the default channel so they immediately see activity
+	if !exists && server.config.AutoJoin && server.config.DefaultChannel != "" {
+		server.joinChannel(conn, server.config.DefaultChannel, "")
+	}
+
+	return true
 }
 
 // validateNickname checks if the provided nickname is valid according to predefined rules.
@@ -187,56 +697,185 @@ func validateNickname(nickname string) (bool, string) {
 	return true, ""
 }
 
-// handleMessageCommand handles messaging commands, allowing a user to send a message to all users or specified users.
-func (server *ChatServer) handleMessageCommand(conn net.Conn, recipients string, message string) {
+// handleMessageCommand handles messaging commands, allowing a user to send a message to all users,
+// specified users, or the members of a channel they have joined (recipients starting with '#').
+// The message type determines how the message is presented to recipients. A message sent again with the dedup ID
+// of one already delivered is acknowledged again instead of being delivered twice.
+func (server *ChatServer) handleMessageCommand(conn net.Conn, recipients string, message string, messageType MessageType, dedupID string) {
 
 	parsedRecipients := strings.Split(recipients, ",")
-	senderNickname := server.users[conn]
+
+	var senderNickname string
+	server.onHub(func() {
+		senderNickname = server.users.nickname(conn)
+	})
 
 	if senderNickname == "" {
-		fmt.Fprintln(conn, "You must register a nickname before you can send a message")
+		sendReply(conn, errNotRegistered, "You must register a nickname before you can send a message")
+		refuseMessage(conn, nackNotRegistered, recipients)
+		return
+	}
+
+	if server.config.DedupWindow <= 0 {
+		dedupID = ""
+	}
+	if len(dedupID) > maxDedupIDLength {
+		sendReplyf(conn, errInvalidArgument, "Dedup IDs may be at most %d characters long", maxDedupIDLength)
+		refuseMessage(conn, nackInvalidDedupID, recipients)
+		return
+	}
+
+	var duplicateAcks []acknowledgment
+	var duplicate, maySend bool
+	var remainingMute time.Duration
+	server.onHub(func() {
+		if dedupID != "" {
+			duplicateAcks, duplicate = server.findDuplicate(senderNickname, dedupID)
+		}
+		remainingMute = server.muteRemaining(senderNickname)
+		maySend = server.hasScope(conn, ScopeSend)
+	})
+
+	if duplicate {
+		for _, ack := range duplicateAcks {
+			acknowledgeMessage(conn, ack.id, ack.target)
+		}
+		return
+	}
+
+	if !maySend {
+		sendReply(conn, errForbidden, "Your token does not permit sending messages")
+		refuseMessage(conn, nackForbidden, recipients)
+		return
+	}
+
+	if remainingMute > 0 {
+		sendReplyf(conn, errMuted, "You are muted for another %s", remainingMute.Round(time.Second))
+		refuseMessage(conn, nackMuted, recipients)
 		return
 	}
 
+	if !server.filterLinks(conn, recipients, message) {
+		refuseMessage(conn, nackBlockedLink, recipients)
+		return
+	}
+
+	if !server.allowRepeat(conn, message) {
+		refuseMessage(conn, nackRepeated, recipients)
+		return
+	}
+
+	server.recordActivity(senderNickname)
+
+	var acknowledged []acknowledgment
 	switch {
 
 		case len(parsedRecipients) == 1 && parsedRecipients[0] == "*":
-			server.sendToAllUsers(conn, senderNickname, message)
+			acknowledged = server.sendToAllUsers(conn, formatMessage(messageType, senderNickname, message))
 
 		default:
-			server.sendToSpecificUsers(conn, senderNickname, parsedRecipients, message)
+			acknowledged = server.sendToSpecificUsers(conn, parsedRecipients, formatMessage(messageType, senderNickname, message))
+	}
+
+	for _, ack := range acknowledged {
+		acknowledgeMessage(conn, ack.id, ack.target)
+	}
+	if dedupID != "" {
+		server.onHub(func() {
+			server.rememberDedupID(senderNickname, dedupID, acknowledged)
+		})
 	}
 }
 
-func (server *ChatServer) sendToAllUsers(conn net.Conn, senderNickname string, message string) {
+// formatMessage renders a user's message for delivery according to its message type.
+func formatMessage(messageType MessageType, senderNickname string, message string) string {
 
-	server.mutex.Lock()
-	defer server.mutex.Unlock()
+	switch messageType {
 
-	// Sender does not receive their own broadcast message
-	for connection := range server.users {
-		if connection != conn {
-			fmt.Fprintf(connection, "%s said: %s\n", senderNickname, message)
-		}
+		case ActionMessage:
+			return fmt.Sprintf("* %s %s", senderNickname, message)
+
+		default:
+			return fmt.Sprintf("%s said: %s", senderNickname, message)
 	}
 }
 
-func (server *ChatServer) sendToSpecificUsers(conn net.Conn, senderNickname string, recipients []string, message string) {
+// sendToAllUsers delivers a message to every user, returning the acknowledgment it was given.
+func (server *ChatServer) sendToAllUsers(conn net.Conn, text string) []acknowledgment {
 
-	server.mutex.Lock()
-	defer server.mutex.Unlock()
+	var id int64
+	server.onHub(func() {
+		senderNickname := server.users.nickname(conn)
 
-	for _, receiver := range recipients {
-		for receiverConnection, receiverNickname := range server.users {
+		var recipients []net.Conn
+		server.users.each(func(connection net.Conn, _ string) bool {
+			if server.canDeliver(conn, connection) {
+				recipients = append(recipients, connection)
+			}
+			return true
+		})
+		payload := newMessagePayload(text)
+		server.fanOut(recipients, payload)
+		payload.release()
+
+		// Shadow-banned users are given an ID like anyone else, so nothing tells them their message went nowhere
+		id = server.nextMessageID()
+		if !server.shadowBans[senderNickname] {
+			server.recordLastMessage(senderNickname, text)
+			server.storeMessage(StoredMessage{ID: id, Sender: senderNickname, Recipients: []string{"*"}, Body: text})
+		}
+	})
+	return []acknowledgment{{id, "*"}}
+}
+
+// sendToSpecificUsers delivers a message to channels and nicknames, refusing it for those it can't be sent to,
+// and returns the acknowledgments it was given: one for each channel and held message, and one for the online users.
+func (server *ChatServer) sendToSpecificUsers(conn net.Conn, recipients []string, text string) []acknowledgment {
+
+	var acknowledged []acknowledgment
+	server.onHub(func() {
+		var receivers []string
+		for _, receiver := range recipients {
+			if isChannelName(receiver) {
+				if id, code := server.sendToChannel(conn, receiver, text); code != "" {
+					refuseMessage(conn, code, receiver)
+				} else {
+					acknowledged = append(acknowledged, acknowledgment{id, receiver})
+				}
+				continue
+			}
 
-			// Sender cannot message themselves
-			if receiverNickname == receiver && conn != receiverConnection {
-				fmt.Fprintf(receiverConnection, "%s said: %s\n", senderNickname, message)
+			// The nickname index finds the receiver's connections without scanning every user
+			receiverConnections := server.users.connections(receiver)
+			for _, receiverConnection := range receiverConnections {
+				if server.canDeliver(conn, receiverConnection) {
+					server.deliver(receiverConnection, text)
+				}
+			}
+			if len(receiverConnections) > 0 {
+				receivers = append(receivers, receiver)
+			} else if code := server.holdOfflineMessage(conn, receiver, text); code != "" {
+				refuseMessage(conn, code, receiver)
+			} else {
+				acknowledged = append(acknowledged, acknowledgment{server.nextMessageID(), receiver})
 			}
 		}
-	}
+
+		if len(receivers) == 0 {
+			return
+		}
+
+		id := server.nextMessageID()
+		senderNickname := server.users.nickname(conn)
+		if !server.shadowBans[senderNickname] {
+			server.storeMessage(StoredMessage{ID: id, Sender: senderNickname, Recipients: receivers, Body: text})
+		}
+		acknowledged = append(acknowledged, acknowledgment{id, strings.Join(receivers, ",")})
+	})
+	return acknowledged
 }
 
+// broadcastMsg announces a user joining, leaving, or renaming to everyone else. It must run on the hub goroutine.
 func (server *ChatServer) broadcastMsg(broadcastType BroadcastType, excludeConn net.Conn, components ...string) {
 
 	var message string
@@ -253,23 +892,318 @@ func (server *ChatServer) broadcastMsg(broadcastType BroadcastType, excludeConn
 			message = fmt.Sprintf("%s changed nickname to %s", components[0], components[1])
 
 		default:
-			log.Println("Unknown broadcast type")
+			slog.Error("Unknown broadcast type")
 			return
 	}
 
 	// User doing action doesn't receive message
-	for conn := range server.users {
+	var recipients []net.Conn
+	server.users.each(func(conn net.Conn, _ string) bool {
 		if conn != excludeConn {
-			fmt.Fprintln(conn, message)
+			recipients = append(recipients, conn)
 		}
+		return true
+	})
+	payload := newMessagePayload(message)
+	server.fanOut(recipients, payload)
+	payload.release()
+}
+
+// deliver writes a message line to a connection and records it in that connection's transcript,
+// discarding the oldest lines once the configured transcript size is exceeded. Each message is numbered
+// with the session's next sequence number, and resumable sessions keep the latest messages for /RESUME
+// to send again; messages for a detached session are only kept. It must run on the hub goroutine.
+func (server *ChatServer) deliver(conn net.Conn, message string) {
+
+	payload := newMessagePayload(message)
+	defer payload.release()
+
+	server.deliverPayload(conn, payload)
+}
+
+// deliverPayload delivers a message formatted once for all of its recipients, the way deliver does.
+// It must run on the hub goroutine.
+func (server *ChatServer) deliverPayload(conn net.Conn, payload *messagePayload) {
+
+	if line := server.recordDelivery(conn, payload); line != nil {
+		writeOutbound(conn, line)
 	}
 }
 
-func main() {
+// recordDelivery numbers and records a message delivered to a connection, and returns the line to write
+// to it, held for the caller to release, or nil if its session is detached. Recipients whose capabilities add
+// no tags are given the payload's line as it is. It must run on the hub goroutine.
+func (server *ChatServer) recordDelivery(conn net.Conn, payload *messagePayload) *outboundBuffer {
+
+	message := payload.text
+	sequenced := sequencedMessage{sentAt: time.Now(), text: message}
+	client, exists := server.clients[conn]
+	if exists {
+		client.sequence++
+		sequenced.seq = client.sequence
+		if client.sessionToken != "" {
+			client.replay = append(client.replay, sequenced)
+			if len(client.replay) > server.config.ResumeBufferSize {
+				client.replay = client.replay[len(client.replay)-server.config.ResumeBufferSize:]
+			}
+		}
+	}
+
+	var line *outboundBuffer
+	if !exists || !client.detached {
+		if messageTagged(conn) {
+			line = newOutboundLine(tagMessage(conn, sequenced))
+		} else {
+			line = payload.line.retain()
+		}
+	}
+
+	server.transcriptMutex.Lock()
+	defer server.transcriptMutex.Unlock()
+
+	transcript, exists := server.transcripts[conn]
+	if !exists || server.config.TranscriptSize <= 0 {
+		return line
+	}
+
+	transcript = append(transcript, message)
+	if len(transcript) > server.config.TranscriptSize {
+		transcript = transcript[len(transcript)-server.config.TranscriptSize:]
+	}
+	server.transcripts[conn] = transcript
+	return line
+}
+
+// handleExportCommand sends the requesting client every message delivered to them this session,
+// framed by begin and end markers.
+func (server *ChatServer) handleExportCommand(conn net.Conn) {
+
+	server.transcriptMutex.Lock()
+	transcript := append([]string(nil), server.transcripts[conn]...)
+	server.transcriptMutex.Unlock()
 
-	chatServer := ChatServer{
-		users: make(map[net.Conn]string),
+	sendReply(conn, rplTranscriptStart, "--- transcript begin ---")
+	for _, line := range transcript {
+		sendReply(conn, rplTranscript, line)
 	}
+	sendReply(conn, rplTranscriptEnd, "--- transcript end ---")
+}
+
+// newChatServer creates a chat server using the given configuration, restoring persisted state from disk.
+func newChatServer(config Config) *ChatServer {
+
+	chatServer := &ChatServer{
+		users:          newUserRegistry(),
+		clients:        make(map[net.Conn]*Client),
+		channels:       make(map[string]*Channel),
+		watchers:       make(map[string]map[net.Conn]bool),
+		seen:           make(map[string]*UserRecord),
+		activity:       make(map[string]map[string]int),
+		connsPerIP:     make(map[string]int),
+		bans:           make(map[string]ipBan),
+		mutes:          make(map[string]*mute),
+		shadowBans:     make(map[string]bool),
+		failures:       make(map[string][]time.Time),
+		tokens:         make(map[string]*authToken),
+		ssoLogins:      make(map[string]*ssoLogin),
+		sessions:       make(map[string]net.Conn),
+		dedupIDs:       make(map[string]*dedupRecord),
+		offline:        make(map[string][]offlineMessage),
+		streams:        make(map[*streamSubscriber]bool),
+		transcripts:    make(map[net.Conn][]string),
+		hub:            make(chan func()),
+		seenSaves:      make(chan UserRecord, seenQueueSize),
+		fanOutPool:     newFanOutPool(config.FanOutWorkers),
+		acceptThrottle: newAcceptThrottle(config),
+		config:         config,
+	}
+	go chatServer.runHub()
+
+	if config.AutoJoin && config.DefaultChannel != "" {
+		if validName, msg := validateChannelName(config.DefaultChannel); !validName {
+			fatal("Invalid default channel", "channel", config.DefaultChannel, "reason", msg)
+		}
+	}
+
+	if config.BackupBucket != "" {
+		if config.BackupInterval <= 0 {
+			fatal("Backups require a positive -backup-interval")
+		}
+		backups, err := newBackupClient(config)
+		if err != nil {
+			fatal("Failed to set up backups", "err", err)
+		}
+		chatServer.backups = backups
+
+		// A backup is restored before anything is loaded, so the restored files are what the server starts with
+		if config.BackupRestore != "" {
+			if err := restoreBackup(backups, config, config.BackupRestore); err != nil {
+				fatal("Failed to restore backup", "err", err)
+			}
+		}
+	} else if config.BackupRestore != "" {
+		fatal("Restoring a backup requires -backup-bucket")
+	}
+
+	if config.MaxLineLength <= 0 {
+		fatal("-max-line-length must be positive")
+	}
+
+	if config.SendQueueSize <= 0 {
+		fatal("-send-queue-size must be positive")
+	}
+
+	if config.FanOutWorkers <= 0 {
+		fatal("-fanout-workers must be positive")
+	}
+
+	tlsConfig, err := serverTLSConfig(config)
+	if err != nil {
+		fatal("Failed to set up TLS", "err", err)
+	}
+	if config.TLSOnly && tlsConfig == nil {
+		fatal("-tls-only requires -tls-cert and -tls-key")
+	}
+	if config.QUICListen != "" && tlsConfig == nil {
+		fatal("-quic-listen requires -tls-cert and -tls-key")
+	}
+	if config.PprofListen != "" && config.PprofToken == "" {
+		fatal("-pprof-listen requires -pprof-token")
+	}
+	chatServer.tlsConfig = tlsConfig
+
+	var accounts AccountStore
+	switch {
+
+		case config.MessageDB != "" && config.BoltDB != "":
+			fatal("Use only one of -message-db and -bolt-db")
+
+		case config.BoltDB != "":
+			// Bolt keeps accounts and registered channels alongside the messages, in place of their files
+			storage, err := openBoltStorage(config.BoltDB)
+			if err != nil {
+				fatal("Failed to open database", "err", err)
+			}
+			chatServer.storage = storage
+			chatServer.channelStore = storage
+			accounts = storage
+
+		case config.MessageDB != "":
+			storage, err := openSQLiteStorage(config.MessageDB)
+			if err != nil {
+				fatal("Failed to open database", "err", err)
+			}
+			chatServer.storage = storage
+
+		default:
+			storage, err := newMemoryStorage(config.SeenFile)
+			if err != nil {
+				fatal("Failed to load last-seen index", "err", err)
+			}
+			chatServer.storage = storage
+	}
+
+	lastMessageID, err := chatServer.storage.LastMessageID()
+	if err != nil {
+		fatal("Failed to read the latest message ID", "err", err)
+	}
+	chatServer.lastMessageID = lastMessageID
+	go chatServer.saveSeenRecords()
+
+	if err := chatServer.loadRegisteredChannels(); err != nil {
+		fatal("Failed to load registered channels", "err", err)
+	}
+
+	if err := chatServer.loadSeenIndex(); err != nil {
+		fatal("Failed to load last-seen index", "err", err)
+	}
+
+	if accounts == nil {
+		fileAccounts, err := newFileAccountStore(config.AccountsFile)
+		if err != nil {
+			fatal("Failed to load accounts", "err", err)
+		}
+		accounts = fileAccounts
+	}
+	chatServer.accounts = accounts
+	if config.AuthWebhookURL != "" {
+		chatServer.accounts = newWebhookAccountStore(accounts, config.AuthWebhookURL, config.AuthWebhookSecret, config.AuthWebhookTimeout)
+	}
+
+	if config.ChatLogDir != "" {
+		chatLog, err := newChatLogger(config.ChatLogDir, config.ChatLogFormat, config.ChatLogMaxSize, config.ChatLogRotateInterval)
+		if err != nil {
+			fatal("Failed to set up chat logs", "err", err)
+		}
+		chatServer.chatLog = chatLog
+	}
+
+	if config.RedisAddr != "" {
+		presence, err := newRedisPresence(config.RedisAddr, config.RedisPassword, config.RedisPrefix, chatServer.nicknameClaimLost)
+		if err != nil {
+			fatal("Failed to connect to Redis", "err", err)
+		}
+		chatServer.presence = presence
+		chatServer.presenceUpdates = make(chan func(), presenceQueueSize)
+		go chatServer.sharePresence()
+	}
+
+	if config.OIDCIssuer != "" {
+		if config.OIDCClientID == "" {
+			fatal("Single sign-on requires -oidc-client-id")
+		}
+		chatServer.oidc = newOIDCProvider(config.OIDCIssuer)
+	}
+
+	if err := chatServer.loadTokens(); err != nil {
+		fatal("Failed to load tokens", "err", err)
+	}
+
+	if err := chatServer.loadOfflineMessages(); err != nil {
+		fatal("Failed to load offline messages", "err", err)
+	}
+
+	if err := chatServer.loadBans(); err != nil {
+		fatal("Failed to load ban list", "err", err)
+	}
+
+	if config.SnapshotFile != "" {
+		if config.SnapshotInterval <= 0 {
+			fatal("State snapshots require a positive -snapshot-interval")
+		}
+		if err := chatServer.restoreSnapshot(); err != nil {
+			fatal("Failed to restore state snapshot", "err", err)
+		}
+	}
+
+	if err := chatServer.loadActivity(); err != nil {
+		fatal("Failed to load activity counters", "err", err)
+	}
+
+	if err := chatServer.loadMotd(); err != nil {
+		fatal("Failed to load message of the day", "err", err)
+	}
+
+	if config.FullPolicy != FullPolicyRefuse && config.FullPolicy != FullPolicyEvict {
+		fatal("Invalid -max-conns-policy; must be "+FullPolicyRefuse+" or "+FullPolicyEvict, "policy", config.FullPolicy)
+	}
+
+	if config.LinkFilterAction != LinkFilterBlock && config.LinkFilterAction != LinkFilterFlag {
+		fatal("Invalid link filter action; must be "+LinkFilterBlock+" or "+LinkFilterFlag, "action", config.LinkFilterAction)
+	}
+
+	if err := chatServer.loadLinkBlocklist(); err != nil {
+		fatal("Failed to load link blocklist", "err", err)
+	}
+
+	return chatServer
+}
+
+func main() {
+
+	config := parseConfig()
+	setUpLogging(config)
 
+	chatServer := newChatServer(config)
 	chatServer.start()
 }
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testReadTimeout is how long a test client waits for an expected line before failing.
+const testReadTimeout = 5 * time.Second
+
+func TestMain(m *testing.M) {
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newTestServer creates a server with no persisted state, configured by flags as on the command line,
+// without binding its listeners.
+func newTestServer(t testing.TB, args ...string) *ChatServer {
+
+	t.Helper()
+
+	flags := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	config := defineConfigFlags(flags)
+	defaults := []string{
+		"-listen", "127.0.0.1:0",
+		"-channels-file", "", "-seen-file", "", "-motd-file", "", "-activity-file", "", "-bans-file", "",
+		"-accounts-file", "", "-tokens-file", "", "-offline-file", "",
+	}
+	if err := flags.Parse(append(defaults, args...)); err != nil {
+		t.Fatalf("Invalid server flags: %v", err)
+	}
+	return newChatServer(*config)
+}
+
+// startTestServer starts a server on a free loopback port with no persisted state, configured by flags as on
+// the command line, and returns the address it accepts connections on. The server runs until the test binary exits.
+func startTestServer(t testing.TB, args ...string) string {
+
+	t.Helper()
+
+	chatServer := newTestServer(t, args...)
+	if err := chatServer.listen(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	go chatServer.serve()
+
+	return chatServer.listenAddrs()[0].String()
+}
+
+// testClient is a connection to a test server that reads the server's replies line by line.
+type testClient struct {
+	t      testing.TB
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialTestClient connects a client to a test server.
+func dialTestClient(t testing.TB, addr string) *testClient {
+
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// send writes a command line to the server.
+func (client *testClient) send(format string, args ...any) {
+
+	client.t.Helper()
+
+	if _, err := fmt.Fprintf(client.conn, format+"\n", args...); err != nil {
+		client.t.Fatalf("Failed to send %q: %v", fmt.Sprintf(format, args...), err)
+	}
+}
+
+// readLine returns the next line from the server, failing the test if none arrives in time.
+func (client *testClient) readLine() (string, error) {
+
+	client.conn.SetReadDeadline(time.Now().Add(testReadTimeout))
+	line, err := client.reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// expect reads lines until one contains want, failing the test if the connection ends or none arrives in time.
+// It returns the matching line.
+func (client *testClient) expect(want string) string {
+
+	client.t.Helper()
+
+	for {
+		line, err := client.readLine()
+		if err != nil {
+			client.t.Fatalf("Expected a line containing %q: %v", want, err)
+		}
+		if strings.Contains(line, want) {
+			return line
+		}
+	}
+}
+
+// register takes a nickname and waits until the server has confirmed it.
+func (client *testClient) register(nickname string) {
+
+	client.t.Helper()
+
+	client.send("/NICK %s", nickname)
+	client.expect("RPL_JOINED")
+}
+
+func TestConnectionCapPerIP(t *testing.T) {
+
+	addr := startTestServer(t, "-max-conns-per-ip", "2")
+
+	for i := range 2 {
+		dialTestClient(t, addr).register(fmt.Sprintf("user%d", i))
+	}
+
+	extra := dialTestClient(t, addr)
+	extra.expect("Too many connections from your address")
+	if line, err := extra.readLine(); err != io.EOF {
+		t.Fatalf("Expected the refused connection to be closed, got %q, %v", line, err)
+	}
+}
+
+func TestExportTranscriptOrder(t *testing.T) {
+
+	addr := startTestServer(t, "-flood-rate", "0")
+
+	alice := dialTestClient(t, addr)
+	alice.register("alice")
+	bob := dialTestClient(t, addr)
+	bob.register("bob")
+
+	var sent []string
+	for i := range 5 {
+		message := fmt.Sprintf("message %d", i)
+		bob.send("/MSG alice %s", message)
+		alice.expect(message)
+		sent = append(sent, message)
+	}
+
+	alice.send("/EXPORT")
+	alice.expect("--- transcript begin ---")
+
+	var exported []string
+	for {
+		line, err := alice.readLine()
+		if err != nil {
+			t.Fatalf("Transcript ended without its end marker: %v", err)
+		}
+		if strings.Contains(line, "--- transcript end ---") {
+			break
+		}
+		if _, message, found := strings.Cut(line, "bob said: "); found {
+			exported = append(exported, message)
+		}
+	}
+
+	if strings.Join(exported, "\n") != strings.Join(sent, "\n") {
+		t.Fatalf("Expected the transcript to hold %q in order, got %q", sent, exported)
+	}
+}
+
+func TestDisconnectedClientsLeaveNoGoroutines(t *testing.T) {
+
+	addr := startTestServer(t)
+
+	// Let the server's own background goroutines start before taking the baseline
+	dialTestClient(t, addr).register("warmup")
+	time.Sleep(100 * time.Millisecond)
+	baseline := runtime.NumGoroutine()
+
+	for i := range 50 {
+		client := dialTestClient(t, addr)
+		client.register(fmt.Sprintf("leak%d", i))
+		client.send("/JOIN #leaks")
+		client.expect("#leaks")
+		client.conn.Close()
+	}
+
+	deadline := time.Now().Add(testReadTimeout)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected goroutines to return to %d after clients disconnected, %d are running", baseline, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
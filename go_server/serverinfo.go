@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	serverVersion    = "1.1.0" // serverVersion is the release version of the chat server
+	protocolRevision = 2       // protocolRevision increases whenever the command protocol changes
+)
+
+// handleUptimeCommand reports when the server started, how long it has been running,
+// and how many connections it has served.
+func (server *ChatServer) handleUptimeCommand(conn net.Conn) {
+
+	var startedAt time.Time
+	var totalConnections int
+	server.onHub(func() {
+		startedAt = server.startedAt
+		totalConnections = server.totalConnections
+	})
+
+	sendReplyf(conn, rplUptime, "Server started at %s", startedAt.Format(time.RFC1123))
+	sendReplyf(conn, rplUptime, "Up for %s, %d connections served", time.Since(startedAt).Round(time.Second), totalConnections)
+}
+
+// handleVersionCommand reports the server version, protocol revision, and enabled optional features,
+// so clients and bots can adapt their behavior.
+func (server *ChatServer) handleVersionCommand(conn net.Conn) {
+
+	sendReplyf(conn, rplVersion, "Server version %s, protocol revision %d", serverVersion, protocolRevision)
+	sendReplyf(conn, rplVersion, "Features: %s", strings.Join(server.enabledFeatures(), " "))
+}
+
+// enabledFeatures lists the optional features turned on in the server's configuration.
+func (server *ChatServer) enabledFeatures() []string {
+
+	features := []string{"channels"}
+
+	if server.tlsConfig != nil {
+		features = append(features, "tls")
+	}
+	if server.config.HTTPListen != "" {
+		features = append(features, "websocket", "http-api", "web-client")
+	}
+	if server.config.GRPCListen != "" {
+		features = append(features, "grpc")
+	}
+	if server.config.QUICListen != "" {
+		features = append(features, "quic")
+	}
+
+	if server.config.ChannelHistory > 0 {
+		features = append(features, "history")
+	}
+	if server.config.ChannelsFile != "" || server.channelStore != nil {
+		features = append(features, "registered-channels")
+	}
+	if server.config.AutoJoin && server.config.DefaultChannel != "" {
+		features = append(features, "auto-join")
+	}
+	if server.config.PingInterval > 0 {
+		features = append(features, "keepalive")
+	}
+	if server.config.TranscriptSize > 0 {
+		features = append(features, "export")
+	}
+	if server.config.SeenFile != "" {
+		features = append(features, "seen")
+	}
+	if server.config.MotdFile != "" {
+		features = append(features, "motd")
+	}
+	if server.config.WhowasSize > 0 {
+		features = append(features, "whowas")
+	}
+	if server.config.BackupBucket != "" {
+		features = append(features, "backups")
+	}
+	if server.config.SnapshotFile != "" {
+		features = append(features, "snapshots")
+	}
+	if server.config.MessageDB != "" || server.config.BoltDB != "" {
+		features = append(features, "database")
+	}
+	if server.config.LinkBlocklistFile != "" {
+		features = append(features, "link-filter")
+	}
+	if server.config.OIDCIssuer != "" {
+		features = append(features, "sso")
+	}
+	if server.config.ChatLogDir != "" {
+		features = append(features, "chat-logs")
+	}
+	if server.config.RedisAddr != "" {
+		features = append(features, "shared-presence")
+	}
+	if server.config.AuthWebhookURL != "" {
+		features = append(features, "auth-webhook")
+	}
+	if server.config.FloodRate > 0 {
+		features = append(features, "flood-protection")
+	}
+
+	return features
+}
+
+// handleTimeCommand reports the server's current time.
+func (server *ChatServer) handleTimeCommand(conn net.Conn) {
+
+	sendReplyf(conn, rplTime, "Server time is %s", time.Now().Format(time.RFC1123))
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+)
+
+// sequencedMessage is a message delivered to a session, numbered so that a client resuming the session
+// can say which message it received last.
+type sequencedMessage struct {
+	seq    uint64    // seq is the message's sequence number, counting from 1 for each session
+	sentAt time.Time // sentAt is when the message was delivered
+	text   string
+}
+
+// issueSessionToken gives a newly registered client a token it can use with /RESUME to take its
+// session over from a new connection after a disconnect. It must run on the hub goroutine.
+func (server *ChatServer) issueSessionToken(conn net.Conn) {
+
+	if server.config.ResumeGracePeriod <= 0 {
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		slog.Error("Failed to generate session token", "err", err)
+		return
+	}
+
+	server.clients[conn].sessionToken = token
+	server.sessions[token] = conn
+
+	sendReplyf(conn, rplSessionToken, "Your session token is %s; if you are disconnected, reconnect within %s and send %s %s [last seq] to resume",
+		token, server.config.ResumeGracePeriod, RESUME, token)
+}
+
+// detachSession keeps a disconnected client's session alive for the resume grace period, buffering
+// messages sent to it, and reports whether it did so. Sessions the server ended itself are not kept.
+// It must run on the hub goroutine.
+func (server *ChatServer) detachSession(conn net.Conn) bool {
+
+	client, exists := server.clients[conn]
+	if !exists || client.sessionToken == "" || client.quietDisconnect || server.config.ResumeGracePeriod <= 0 {
+		return false
+	}
+
+	client.detached = true
+	client.detachedSequence = client.sequence
+	client.resumeTimer = time.AfterFunc(server.config.ResumeGracePeriod, func() {
+		server.onHub(func() {
+			// The session may have been resumed on another connection in the meantime
+			if server.clients[conn] != client {
+				return
+			}
+
+			server.clientLog(conn).Info("Session expired")
+			server.endSession(conn, true)
+		})
+	})
+
+	server.clientLog(conn).Info("Holding session", "grace", server.config.ResumeGracePeriod)
+	return true
+}
+
+// endSession removes all state for a connection, announcing that the user left unless announce is false
+// or the server ended the connection itself. It must run on the hub goroutine.
+func (server *ChatServer) endSession(conn net.Conn, announce bool) {
+
+	client := server.clients[conn]
+
+	// Clients disconnected by the server, such as kicked users, are announced separately
+	// Users still connected from another device have not left
+	if nickname, registered := server.users.lookup(conn); registered && announce && !client.quietDisconnect && len(server.otherDevices(conn)) == 0 {
+		server.broadcastMsg(UserLeavesServer, conn, nickname)
+	}
+
+	server.removeUser(conn)
+	delete(server.sessions, client.sessionToken)
+	delete(server.clients, conn)
+
+	server.transcriptMutex.Lock()
+	delete(server.transcripts, conn)
+	server.transcriptMutex.Unlock()
+}
+
+// handleResumeCommand takes over a detached session from a new connection, restoring its nickname and
+// channel memberships, and sending again the messages delivered after the sequence number lastSequence.
+// Without a sequence number, the messages delivered while the session was disconnected are sent.
+func (server *ChatServer) handleResumeCommand(conn net.Conn, token string, lastSequence string) {
+
+	server.onHub(func() {
+		oldConn, exists := server.sessions[token]
+		if !exists || oldConn == conn || !server.clients[oldConn].detached {
+			sendReply(conn, errInvalidToken, "Invalid or expired session token")
+			server.recordFailure(conn, "a failed /RESUME attempt")
+			return
+		}
+
+		client := server.clients[oldConn]
+		after := client.detachedSequence
+		if lastSequence != "" {
+			sequence, err := strconv.ParseUint(lastSequence, 10, 64)
+			if err != nil || sequence > client.sequence {
+				sendReplyf(conn, errInvalidArgument, "Invalid sequence number %s: the session's latest message is %d", lastSequence, client.sequence)
+				return
+			}
+			after = sequence
+		}
+
+		// A nickname this connection already has, such as a guest nickname, gives way to the resumed one
+		if nickname, registered := server.users.lookup(conn); registered {
+			server.broadcastMsg(UserLeavesServer, conn, nickname)
+			server.removeUser(conn)
+		}
+
+		server.resumeSession(oldConn, conn, after)
+	})
+}
+
+// resumeSession moves a detached session's state from its old connection to a new one and sends again the
+// kept messages with sequence numbers above after, with their original sequence numbers and times.
+// It must run on the hub goroutine.
+func (server *ChatServer) resumeSession(oldConn net.Conn, conn net.Conn, after uint64) {
+
+	client := server.clients[oldConn]
+	fresh := server.clients[conn]
+
+	client.resumeTimer.Stop()
+	client.detached = false
+	client.conn = conn
+	client.lifecycle = fresh.lifecycle
+	client.lastActive = fresh.lastActive
+	client.operator = client.operator || fresh.operator
+	client.pingToken = ""
+
+	server.clients[conn] = client
+	delete(server.clients, oldConn)
+	server.sessions[client.sessionToken] = conn
+
+	// A token the new connection was issued before resuming, such as for a guest nickname, is no longer valid
+	delete(server.sessions, fresh.sessionToken)
+
+	nickname := server.users.nickname(oldConn)
+	server.users.set(conn, nickname)
+	server.users.remove(oldConn)
+
+	for _, channel := range server.channels {
+		if role, isMember := channel.members[oldConn]; isMember {
+			channel.members[conn] = role
+			delete(channel.members, oldConn)
+		}
+	}
+
+	for _, watchers := range server.watchers {
+		if watchers[oldConn] {
+			watchers[conn] = true
+			delete(watchers, oldConn)
+		}
+	}
+
+	server.transcriptMutex.Lock()
+	server.transcripts[conn] = server.transcripts[oldConn]
+	delete(server.transcripts, oldConn)
+	server.transcriptMutex.Unlock()
+
+	var missed []sequencedMessage
+	for _, message := range client.replay {
+		if message.seq > after {
+			missed = append(missed, message)
+		}
+	}
+
+	// Messages that fell out of the replay buffer can't be sent again, but the client is told how many there were
+	lost := client.sequence - after - uint64(len(missed))
+
+	slog.Info("Resumed session", "remote", conn.RemoteAddr().String(), "nickname", nickname)
+	if lost > 0 {
+		sendReplyf(conn, rplResumed, "Resumed session as %s with %d missed messages; %d earlier messages are no longer available", nickname, len(missed), lost)
+	} else {
+		sendReplyf(conn, rplResumed, "Resumed session as %s with %d missed messages", nickname, len(missed))
+	}
+
+	for _, message := range missed {
+		fmt.Fprintln(conn, tagMessage(conn, message))
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+)
+
+// handleShadowbanCommand lets a moderator shadow-ban a user. A shadow-banned user's messages
+// appear to send normally from their side but are never delivered to anyone else.
+func (server *ChatServer) handleShadowbanCommand(conn net.Conn, nickname string) {
+
+	server.onHub(func() {
+		targetConn, found := server.findUserByNickname(nickname)
+		if !found {
+			sendReplyf(conn, errNoSuchNick, "No such user %s", nickname)
+			return
+		}
+		if !server.outranks(conn, targetConn, "shadow-ban") {
+			return
+		}
+
+		if server.shadowBans[nickname] {
+			sendReplyf(conn, errNoChange, "%s is already shadow-banned", nickname)
+			return
+		}
+
+		server.shadowBans[nickname] = true
+
+		sendReplyf(conn, rplShadowban, "Shadow-banned %s", nickname)
+		server.clientLog(conn).Info("Shadow-banned user", "target", nickname)
+	})
+}
+
+// handleUnshadowbanCommand lets a moderator lift a user's shadow-ban.
+func (server *ChatServer) handleUnshadowbanCommand(conn net.Conn, nickname string) {
+
+	server.onHub(func() {
+		if !server.shadowBans[nickname] {
+			sendReplyf(conn, errNoChange, "%s is not shadow-banned", nickname)
+			return
+		}
+
+		delete(server.shadowBans, nickname)
+
+		sendReplyf(conn, rplShadowban, "Lifted shadow-ban on %s", nickname)
+		server.clientLog(conn).Info("Lifted shadow-ban", "target", nickname)
+	})
+}
+
+// moveShadowban carries a shadow-ban over when a shadow-banned user changes nickname.
+// It must run on the hub goroutine.
+func (server *ChatServer) moveShadowban(oldNickname string, newNickname string) {
+
+	if server.shadowBans[oldNickname] {
+		delete(server.shadowBans, oldNickname)
+		server.shadowBans[newNickname] = true
+	}
+}
+
+// canDeliver is the delivery filter for user messages: it reports whether a message sent by sender
+// should reach receiver. Senders never receive their own messages, messages from shadow-banned users
+// reach no one else, and users ignoring the sender or whose token lacks the read scope are skipped.
+// It must run on the hub goroutine.
+func (server *ChatServer) canDeliver(sender net.Conn, receiver net.Conn) bool {
+
+	return sender != receiver && server.canDeliverFrom(server.users.nickname(sender), receiver)
+}
+
+// canDeliverFrom is canDeliver for a sender known only by nickname, such as a message posted through
+// the HTTP API. It must run on the hub goroutine.
+func (server *ChatServer) canDeliverFrom(senderNickname string, receiver net.Conn) bool {
+
+	if !server.hasScope(receiver, ScopeRead) || server.shadowBans[senderNickname] {
+		return false
+	}
+	return !server.isIgnoring(receiver, senderNickname)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshotVersion is the version of the state snapshot format written by this server.
+const snapshotVersion = 1
+
+// snapshotMigrations upgrade snapshots written by older servers one version at a time: the migration
+// for version v turns a version v snapshot, decoded as a generic JSON object, into a version v+1 snapshot.
+// Version 1 is the first format, so there are none yet.
+var snapshotMigrations = map[int]func(snapshot map[string]any) error{}
+
+// stateSnapshot is the on-disk representation of the server state kept in a snapshot.
+type stateSnapshot struct {
+	Version  int                         `json:"version"`
+	TakenAt  time.Time                   `json:"taken_at"`
+	Accounts []accountRecord             `json:"accounts,omitempty"` // Accounts are omitted when accounts are kept in a database
+	Channels []ChannelRecord             `json:"channels,omitempty"`
+	Bans     []ipBan                     `json:"bans,omitempty"`
+	Offline  map[string][]offlineMessage `json:"offline,omitempty"`
+}
+
+// fileAccounts returns the file account store behind the server's accounts, if that is where they are kept.
+func (server *ChatServer) fileAccounts() (*fileAccountStore, bool) {
+
+	accounts := server.accounts
+	if webhook, wrapped := accounts.(*webhookAccountStore); wrapped {
+		accounts = webhook.AccountStore
+	}
+
+	store, isFile := accounts.(*fileAccountStore)
+	return store, isFile
+}
+
+// takeSnapshot writes the registered accounts, registered channels, IP bans, and held offline messages
+// to the configured snapshot file, replacing it atomically.
+func (server *ChatServer) takeSnapshot() error {
+
+	snapshot := stateSnapshot{Version: snapshotVersion, TakenAt: time.Now()}
+	if store, isFile := server.fileAccounts(); isFile {
+		snapshot.Accounts = store.snapshot()
+	}
+
+	var data []byte
+	var err error
+	server.onHub(func() {
+		snapshot.Channels = server.registeredChannelRecords()
+		for _, ban := range server.bans {
+			if !ban.expired() {
+				snapshot.Bans = append(snapshot.Bans, ban)
+			}
+		}
+		sort.Slice(snapshot.Bans, func(i, j int) bool { return snapshot.Bans[i].IP < snapshot.Bans[j].IP })
+		snapshot.Offline = server.offline
+
+		// The snapshot is encoded on the hub goroutine, since it shares the offline message queues
+		data, err = json.MarshalIndent(snapshot, "", "  ")
+	})
+
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(server.config.SnapshotFile, data)
+}
+
+// snapshotState periodically takes a snapshot of the server state. It runs for the lifetime of the server.
+func (server *ChatServer) snapshotState(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := server.takeSnapshot(); err != nil {
+			slog.Error("Failed to take state snapshot", "err", err)
+		}
+	}
+}
+
+// loadSnapshot reads a snapshot file, migrating it from an older format if needed.
+func loadSnapshot(path string) (stateSnapshot, error) {
+
+	var snapshot stateSnapshot
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snapshot, err
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return snapshot, err
+	}
+
+	version, valid := raw["version"].(float64)
+	if !valid || version < 1 {
+		return snapshot, errors.New("snapshot has no valid version")
+	}
+	if int(version) > snapshotVersion {
+		return snapshot, fmt.Errorf("snapshot version %d is newer than the supported version %d", int(version), snapshotVersion)
+	}
+
+	for from := int(version); from < snapshotVersion; from++ {
+		migrate, exists := snapshotMigrations[from]
+		if !exists {
+			return snapshot, fmt.Errorf("no migration from snapshot version %d", from)
+		}
+		if err := migrate(raw); err != nil {
+			return snapshot, fmt.Errorf("migrating snapshot from version %d: %w", from, err)
+		}
+		raw["version"] = float64(from + 1)
+	}
+
+	if data, err = json.Marshal(raw); err != nil {
+		return snapshot, err
+	}
+	err = json.Unmarshal(data, &snapshot)
+	return snapshot, err
+}
+
+// stateFileMissing reports whether a piece of state has no file to load it from, because its file is
+// disabled or does not exist, such as after it was lost in a crash.
+func stateFileMissing(path string) bool {
+
+	if path == "" {
+		return true
+	}
+	_, err := os.Stat(path)
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// restoreSnapshot recovers state from the configured snapshot file. State that is saved to its own file
+// on every change is fresher than the snapshot, so each part is restored only when its file is missing;
+// state kept in a database is never restored. A missing snapshot is not an error.
+func (server *ChatServer) restoreSnapshot() error {
+
+	snapshot, err := loadSnapshot(server.config.SnapshotFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var restored []string
+
+	if store, isFile := server.fileAccounts(); isFile && len(snapshot.Accounts) > 0 && stateFileMissing(server.config.AccountsFile) {
+		if err := store.restore(snapshot.Accounts); err != nil {
+			return err
+		}
+		restored = append(restored, fmt.Sprintf("%d accounts", len(snapshot.Accounts)))
+	}
+
+	server.onHub(func() {
+		if server.channelStore == nil && len(snapshot.Channels) > 0 && stateFileMissing(server.config.ChannelsFile) {
+			for _, record := range snapshot.Channels {
+				if _, exists := server.channels[record.Name]; !exists {
+					server.restoreChannel(record)
+				}
+			}
+			server.saveRegisteredChannels()
+			restored = append(restored, fmt.Sprintf("%d channels", len(snapshot.Channels)))
+		}
+
+		if len(snapshot.Bans) > 0 && stateFileMissing(server.config.BansFile) {
+			for _, ban := range snapshot.Bans {
+				if _, exists := server.bans[ban.IP]; !exists && !ban.expired() {
+					server.bans[ban.IP] = ban
+				}
+			}
+			server.saveBans()
+			restored = append(restored, fmt.Sprintf("%d bans", len(snapshot.Bans)))
+		}
+
+		if len(snapshot.Offline) > 0 && stateFileMissing(server.config.OfflineFile) {
+			for nickname, queue := range snapshot.Offline {
+				if _, exists := server.offline[nickname]; !exists {
+					server.offline[nickname] = queue
+				}
+			}
+			server.saveOfflineMessages()
+			restored = append(restored, fmt.Sprintf("offline messages for %d users", len(snapshot.Offline)))
+		}
+	})
+
+	if len(restored) > 0 {
+		slog.Info("Restored state snapshot", "restored", strings.Join(restored, ", "), "taken_at", snapshot.TakenAt.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the tables of persisted messages and user records, and the full-text index of message bodies
+// used by /SEARCH. Broadcasts have no channel and "*" as recipients; direct messages have no channel and
+// a comma-separated list of recipient nicknames.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	sender     TEXT    NOT NULL,
+	recipients TEXT    NOT NULL DEFAULT '',
+	channel    TEXT    NOT NULL DEFAULT '',
+	sent_at    INTEGER NOT NULL,
+	body       TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_channel ON messages (channel, sent_at);
+CREATE INDEX IF NOT EXISTS messages_sent_at ON messages (sent_at);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5 (body, content = 'messages', content_rowid = 'id');
+CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+	INSERT INTO messages_fts (rowid, body) VALUES (new.id, new.body);
+END;
+CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+	INSERT INTO messages_fts (messages_fts, rowid, body) VALUES ('delete', old.id, old.body);
+END;
+
+CREATE TABLE IF NOT EXISTS users (
+	nickname     TEXT    PRIMARY KEY,
+	last_seen    INTEGER NOT NULL,
+	last_message TEXT    NOT NULL DEFAULT ''
+);
+`
+
+// sqliteStorage is a Storage backed by an SQLite database. Messages are queued and written in batches
+// by a background goroutine, so saving a message never blocks delivery.
+type sqliteStorage struct {
+	*batchWriter
+	db *sql.DB
+}
+
+// openSQLiteStorage opens, creating if necessary, the SQLite database at path and starts its message writer.
+func openSQLiteStorage(path string) (*sqliteStorage, error) {
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows only one writer at a time
+	db.SetMaxOpenConns(1)
+
+	// Databases created before the full-text index existed have their messages indexed once
+	var indexed int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = 'messages_fts'").Scan(&indexed); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if indexed == 0 {
+		if _, err := db.Exec("INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')"); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	storage := &sqliteStorage{db: db}
+	storage.batchWriter = newBatchWriter(storage.insert)
+
+	return storage, nil
+}
+
+// insert writes a batch of messages in a single transaction.
+func (storage *sqliteStorage) insert(batch []StoredMessage) error {
+
+	tx, err := storage.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statement, err := tx.Prepare("INSERT INTO messages (id, sender, recipients, channel, sent_at, body) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	for _, message := range batch {
+		// A NULL id has SQLite assign the next one
+		var id any
+		if message.ID != 0 {
+			id = message.ID
+		}
+
+		_, err := statement.Exec(id, message.Sender, strings.Join(message.Recipients, ","), message.Channel, message.SentAt.UnixNano(), message.Body)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LastMessageID implements Storage. Messages are written asynchronously, so pending messages are written first.
+func (storage *sqliteStorage) LastMessageID() (int64, error) {
+
+	storage.flush()
+
+	// AUTOINCREMENT keeps the highest ID ever used in sqlite_sequence, even after those messages are deleted
+	var id int64
+	err := storage.db.QueryRow("SELECT seq FROM sqlite_sequence WHERE name = 'messages'").Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// LoadHistory implements Storage.
+func (storage *sqliteStorage) LoadHistory(channel string, beforeID int64, limit int) ([]StoredMessage, error) {
+
+	return storage.query("channel = ?", beforeID, limit, channel)
+}
+
+// LoadConversation implements Storage.
+func (storage *sqliteStorage) LoadConversation(nickname string, other string, beforeID int64, limit int) ([]StoredMessage, error) {
+
+	// Recipients are stored comma-separated, so they are matched with commas around them
+	return storage.query(
+		"channel = '' AND ((sender = ? AND instr(',' || recipients || ',', ?) > 0) OR (sender = ? AND instr(',' || recipients || ',', ?) > 0))",
+		beforeID, limit, nickname, ","+other+",", other, ","+nickname+",")
+}
+
+// SearchMessages implements Storage with the full-text index, matching terms as whole words.
+func (storage *sqliteStorage) SearchMessages(channel string, terms []string, beforeID int64, limit int) ([]StoredMessage, error) {
+
+	// Each term is quoted so that it is matched literally rather than as query syntax
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted = append(quoted, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+
+	return storage.query("channel = ? AND id IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?)",
+		beforeID, limit, channel, strings.Join(quoted, " "))
+}
+
+// LoadMessages implements Storage.
+func (storage *sqliteStorage) LoadMessages(query MessageQuery) ([]StoredMessage, error) {
+
+	conditions := []string{"1 = 1"}
+	var args []any
+
+	if query.Channel != "" {
+		conditions = append(conditions, "channel = ?")
+		args = append(args, query.Channel)
+	}
+	if query.Nickname != "" {
+		conditions = append(conditions, "(sender = ? OR instr(',' || recipients || ',', ?) > 0)")
+		args = append(args, query.Nickname, ","+query.Nickname+",")
+	}
+	if !query.From.IsZero() {
+		conditions = append(conditions, "sent_at >= ?")
+		args = append(args, query.From.UnixNano())
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "sent_at < ?")
+		args = append(args, query.To.UnixNano())
+	}
+
+	// A negative limit means no limit in SQLite
+	return storage.query(strings.Join(conditions, " AND "), 0, -1, args...)
+}
+
+// query returns up to limit of the latest messages with IDs below beforeID that match a WHERE condition, oldest first.
+// Queued messages are written first so that they are included.
+func (storage *sqliteStorage) query(condition string, beforeID int64, limit int, args ...any) ([]StoredMessage, error) {
+
+	storage.flush()
+
+	if beforeID == 0 {
+		beforeID = math.MaxInt64
+	}
+	args = append(args, beforeID, limit)
+
+	rows, err := storage.db.Query(
+		"SELECT id, sender, recipients, channel, sent_at, body FROM messages WHERE "+condition+" AND id < ? ORDER BY id DESC LIMIT ?",
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []StoredMessage
+	for rows.Next() {
+		var message StoredMessage
+		var recipients string
+		var sentAt int64
+		if err := rows.Scan(&message.ID, &message.Sender, &recipients, &message.Channel, &sentAt, &message.Body); err != nil {
+			return nil, err
+		}
+		if recipients != "" {
+			message.Recipients = strings.Split(recipients, ",")
+		}
+		message.SentAt = time.Unix(0, sentAt)
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(messages)
+	return messages, nil
+}
+
+// PruneMessages implements Storage, deleting each channel's expired messages in a single transaction.
+func (storage *sqliteStorage) PruneMessages(retention Retention) (int, error) {
+
+	storage.flush()
+
+	tx, err := storage.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Direct messages and broadcasts are stored with an empty channel, so they are pruned along with the channels
+	rows, err := tx.Query("SELECT DISTINCT channel FROM messages")
+	if err != nil {
+		return 0, err
+	}
+	var channels []string
+	for rows.Next() {
+		var channel string
+		if err := rows.Scan(&channel); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		channels = append(channels, channel)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, channel := range channels {
+		policy := retention.policy(channel)
+
+		if policy.Days > 0 {
+			result, err := tx.Exec("DELETE FROM messages WHERE channel = ? AND sent_at < ?",
+				channel, retention.Now.AddDate(0, 0, -policy.Days).UnixNano())
+			if err != nil {
+				return 0, err
+			}
+			count, _ := result.RowsAffected()
+			deleted += count
+		}
+
+		if channel != "" && policy.Messages > 0 {
+			result, err := tx.Exec("DELETE FROM messages WHERE channel = ? AND id NOT IN (SELECT id FROM messages WHERE channel = ? ORDER BY id DESC LIMIT ?)",
+				channel, channel, policy.Messages)
+			if err != nil {
+				return 0, err
+			}
+			count, _ := result.RowsAffected()
+			deleted += count
+		}
+	}
+
+	return int(deleted), tx.Commit()
+}
+
+// SaveUser implements Storage.
+func (storage *sqliteStorage) SaveUser(user UserRecord) error {
+
+	_, err := storage.db.Exec(
+		"INSERT INTO users (nickname, last_seen, last_message) VALUES (?, ?, ?) "+
+			"ON CONFLICT (nickname) DO UPDATE SET last_seen = excluded.last_seen, last_message = excluded.last_message",
+		user.Nickname, user.LastSeen.UnixNano(), user.LastMessage)
+	return err
+}
+
+// LoadUsers implements Storage.
+func (storage *sqliteStorage) LoadUsers() ([]UserRecord, error) {
+
+	rows, err := storage.db.Query("SELECT nickname, last_seen, last_message FROM users")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []UserRecord
+	for rows.Next() {
+		var user UserRecord
+		var lastSeen int64
+		if err := rows.Scan(&user.Nickname, &lastSeen, &user.LastMessage); err != nil {
+			return nil, err
+		}
+		user.LastSeen = time.Unix(0, lastSeen)
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// copyDatabase implements databaseBackup.
+func (storage *sqliteStorage) copyDatabase(dir string) (string, error) {
+
+	storage.flush()
+
+	_, err := storage.db.Exec("VACUUM INTO ?", filepath.Join(dir, backupSQLiteName))
+	return backupSQLiteName, err
+}
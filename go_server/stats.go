@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// messageRateWindow is the number of seconds the message rate reported by /STATS is averaged over.
+const messageRateWindow = 60
+
+// statsChannelLimit is the number of largest channels /STATS lists by name.
+const statsChannelLimit = 10
+
+// rateMeter counts events in one-second buckets over a sliding window of messageRateWindow seconds.
+type rateMeter struct {
+	counts  [messageRateWindow]int   // counts holds the number of events in each bucket
+	seconds [messageRateWindow]int64 // seconds holds the Unix second each bucket counts events for
+}
+
+// record counts an event at the given time.
+func (meter *rateMeter) record(now time.Time) {
+
+	second := now.Unix()
+	bucket := second % messageRateWindow
+	if meter.seconds[bucket] != second {
+		meter.seconds[bucket] = second
+		meter.counts[bucket] = 0
+	}
+	meter.counts[bucket]++
+}
+
+// perSecond returns the average number of events per second over the window's complete seconds up to now.
+func (meter *rateMeter) perSecond(now time.Time) float64 {
+
+	second := now.Unix()
+	total := 0
+	for bucket, count := range meter.counts {
+		if age := second - meter.seconds[bucket]; age > 0 && age <= messageRateWindow {
+			total += count
+		}
+	}
+	return float64(total) / messageRateWindow
+}
+
+// handleStatsCommand sends an operator live figures on the server: connections, channel sizes, message rate,
+// memory use, goroutines, broadcast fan-out, uptime, and the day's most active users.
+func (server *ChatServer) handleStatsCommand(conn net.Conn) {
+
+	type channelSize struct {
+		name    string
+		members int
+	}
+
+	var startedAt time.Time
+	var open, served, detached int
+	var channels []channelSize
+	var messageRate float64
+	var talkers []userActivity
+	server.onHub(func() {
+		startedAt = server.startedAt
+		open, served = server.openConnections, server.totalConnections
+		for _, client := range server.clients {
+			if client.detached {
+				detached++
+			}
+		}
+		for name, channel := range server.channels {
+			channels = append(channels, channelSize{name, len(channel.members)})
+		}
+		messageRate = server.messageRate.perSecond(time.Now())
+		talkers = server.mostActive(1, 5)
+	})
+
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	fanOut := server.fanOutPool.stats()
+
+	sendReplyf(conn, rplStats, "Uptime: %s since %s", time.Since(startedAt).Round(time.Second), startedAt.Format(time.RFC1123))
+	sendReplyf(conn, rplStats, "Connections: %d open, %d users, %d sessions held for resumption, %d served since startup",
+		open, server.users.count(), detached, served)
+	sendReplyf(conn, rplStats, "Messages: %.2f per second over the last minute", messageRate)
+	sendReplyf(conn, rplStats, "Memory: %s heap in use, %s obtained from the system, %d garbage collections",
+		formatBytes(memory.HeapInuse), formatBytes(memory.Sys), memory.NumGC)
+	sendReplyf(conn, rplStats, "Goroutines: %d", runtime.NumGoroutine())
+	if fanOut.Broadcasts > 0 {
+		sendReplyf(conn, rplStats, "Fan-out: %d large broadcasts, %d writes, %d failed, %s on average",
+			fanOut.Broadcasts, fanOut.Writes, fanOut.Failures, (fanOut.Busy / time.Duration(fanOut.Broadcasts)).Round(time.Microsecond))
+	}
+
+	sort.Slice(channels, func(i, j int) bool {
+		if channels[i].members != channels[j].members {
+			return channels[i].members > channels[j].members
+		}
+		return channels[i].name < channels[j].name
+	})
+	sendReplyf(conn, rplStats, "Channels: %d", len(channels))
+	for _, channel := range channels[:min(len(channels), statsChannelLimit)] {
+		sendReplyf(conn, rplStats, "  %s: %d members", channel.name, channel.members)
+	}
+	if len(channels) > statsChannelLimit {
+		sendReplyf(conn, rplStats, "  and %d smaller channels", len(channels)-statsChannelLimit)
+	}
+
+	if len(talkers) == 0 {
+		sendReply(conn, rplStats, "Top talkers today: none")
+		return
+	}
+	entries := make([]string, len(talkers))
+	for i, talker := range talkers {
+		entries[i] = fmt.Sprintf("%s (%d)", talker.nickname, talker.messages)
+	}
+	sendReplyf(conn, rplStats, "Top talkers today: %s", strings.Join(entries, ", "))
+}
+
+// formatBytes formats a byte count in mebibytes.
+func formatBytes(bytes uint64) string {
+
+	return fmt.Sprintf("%.1f MiB", float64(bytes)/(1<<20))
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// memoryStorageLimit is the number of messages kept by the in-memory storage before the oldest are discarded.
+const memoryStorageLimit = 10000
+
+// StoredMessage is a channel message, direct message, or broadcast kept in Storage.
+type StoredMessage struct {
+	ID         int64 // ID is assigned by the server, or by the storage if zero, increasing with each message saved
+	Sender     string
+	Recipients []string // Recipients are the nicknames of a direct message, or "*" for a broadcast
+	Channel    string   // Channel is the channel a message was sent to; empty for direct messages and broadcasts
+	SentAt     time.Time
+	Body       string
+}
+
+// MessageQuery selects stored messages by channel or nickname and time range. Empty fields match every message.
+type MessageQuery struct {
+	Channel  string    // Channel matches messages sent to a channel
+	Nickname string    // Nickname matches messages sent by a nickname or directly to it
+	From     time.Time // From matches messages sent at or after a time
+	To       time.Time // To matches messages sent before a time
+}
+
+// matches reports whether a stored message is selected by the query.
+func (query MessageQuery) matches(message StoredMessage) bool {
+
+	if query.Channel != "" && message.Channel != query.Channel {
+		return false
+	}
+	if query.Nickname != "" && message.Sender != query.Nickname && !slices.Contains(message.Recipients, query.Nickname) {
+		return false
+	}
+	if !query.From.IsZero() && message.SentAt.Before(query.From) {
+		return false
+	}
+	if !query.To.IsZero() && !message.SentAt.Before(query.To) {
+		return false
+	}
+	return true
+}
+
+// UserRecord is what the server remembers about a nickname between connections, for /SEEN.
+type UserRecord struct {
+	Nickname    string    `json:"-"`
+	LastSeen    time.Time `json:"last_seen"`
+	LastMessage string    `json:"last_message,omitempty"` // LastMessage is the last public message the nickname sent
+}
+
+// Storage persists messages and user records, so that backends such as SQLite, Postgres, or Bolt
+// can replace the default in-memory storage. Implementations must be safe for concurrent use.
+type Storage interface {
+	// SaveMessage stores a sent message. Implementations may write it asynchronously.
+	// A message with a nonzero ID is stored under that ID; otherwise the storage assigns the next one.
+	SaveMessage(message StoredMessage) error
+
+	// LastMessageID returns the highest message ID the storage has assigned or been given, including those
+	// of messages since pruned, so that IDs are never reused.
+	LastMessageID() (int64, error)
+
+	// LoadHistory returns up to limit of the latest messages sent to a channel with IDs below beforeID,
+	// oldest first. A beforeID of 0 starts from the latest message.
+	LoadHistory(channel string, beforeID int64, limit int) ([]StoredMessage, error)
+
+	// LoadConversation returns up to limit of the latest direct messages between two nicknames with IDs
+	// below beforeID, oldest first. A beforeID of 0 starts from the latest message.
+	LoadConversation(nickname string, other string, beforeID int64, limit int) ([]StoredMessage, error)
+
+	// SearchMessages returns up to limit of the latest messages sent to a channel with IDs below beforeID that
+	// contain every search term, oldest first. Implementations may match terms as whole words or as substrings.
+	SearchMessages(channel string, terms []string, beforeID int64, limit int) ([]StoredMessage, error)
+
+	// LoadMessages returns every message matching a query, oldest first.
+	LoadMessages(query MessageQuery) ([]StoredMessage, error)
+
+	// PruneMessages deletes the messages that fall outside a retention, returning how many it deleted.
+	PruneMessages(retention Retention) (int, error)
+
+	// SaveUser stores a user record, replacing any earlier record for the same nickname.
+	SaveUser(user UserRecord) error
+
+	// LoadUsers returns every stored user record.
+	LoadUsers() ([]UserRecord, error)
+}
+
+// memoryStorage is the default Storage. It keeps recent messages in memory only, and user records in memory
+// and, when a path is given, in a JSON file.
+type memoryStorage struct {
+	usersFile string
+
+	mutex    sync.Mutex            // mutex protects access to messages, lastID, and users
+	messages []StoredMessage       // messages holds the most recent messages, oldest first
+	lastID   int64                 // lastID is the ID of the latest message saved
+	users    map[string]UserRecord // users maps nicknames to their records
+}
+
+// newMemoryStorage creates an in-memory storage, loading any user records already saved to usersFile.
+// A missing file is not an error.
+func newMemoryStorage(usersFile string) (*memoryStorage, error) {
+
+	storage := &memoryStorage{usersFile: usersFile, users: make(map[string]UserRecord)}
+	if usersFile == "" {
+		return storage, nil
+	}
+
+	data, err := os.ReadFile(usersFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return storage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &storage.users); err != nil {
+		return nil, err
+	}
+	for nickname, user := range storage.users {
+		user.Nickname = nickname
+		storage.users[nickname] = user
+	}
+	return storage, nil
+}
+
+// SaveMessage implements Storage.
+func (storage *memoryStorage) SaveMessage(message StoredMessage) error {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	if message.ID == 0 {
+		message.ID = storage.lastID + 1
+	}
+	storage.lastID = max(storage.lastID, message.ID)
+	storage.messages = append(storage.messages, message)
+	if len(storage.messages) > memoryStorageLimit {
+		storage.messages = storage.messages[len(storage.messages)-memoryStorageLimit:]
+	}
+	return nil
+}
+
+// LastMessageID implements Storage.
+func (storage *memoryStorage) LastMessageID() (int64, error) {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	return storage.lastID, nil
+}
+
+// LoadHistory implements Storage.
+func (storage *memoryStorage) LoadHistory(channel string, beforeID int64, limit int) ([]StoredMessage, error) {
+
+	return storage.find(beforeID, limit, func(message StoredMessage) bool {
+		return message.Channel == channel
+	}), nil
+}
+
+// LoadConversation implements Storage.
+func (storage *memoryStorage) LoadConversation(nickname string, other string, beforeID int64, limit int) ([]StoredMessage, error) {
+
+	return storage.find(beforeID, limit, func(message StoredMessage) bool {
+		return message.Channel == "" && isConversation(message, nickname, other)
+	}), nil
+}
+
+// SearchMessages implements Storage, matching terms as substrings.
+func (storage *memoryStorage) SearchMessages(channel string, terms []string, beforeID int64, limit int) ([]StoredMessage, error) {
+
+	return storage.find(beforeID, limit, func(message StoredMessage) bool {
+		return message.Channel == channel && containsTerms(message.Body, terms)
+	}), nil
+}
+
+// LoadMessages implements Storage.
+func (storage *memoryStorage) LoadMessages(query MessageQuery) ([]StoredMessage, error) {
+
+	return storage.find(0, math.MaxInt, query.matches), nil
+}
+
+// find returns up to limit of the latest messages with IDs below beforeID that match, oldest first.
+func (storage *memoryStorage) find(beforeID int64, limit int, matches func(StoredMessage) bool) []StoredMessage {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	var found []StoredMessage
+	for i := len(storage.messages) - 1; i >= 0 && len(found) < limit; i-- {
+		message := storage.messages[i]
+		if (beforeID == 0 || message.ID < beforeID) && matches(message) {
+			found = append(found, message)
+		}
+	}
+
+	slices.Reverse(found)
+	return found
+}
+
+// PruneMessages implements Storage.
+func (storage *memoryStorage) PruneMessages(retention Retention) (int, error) {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	// Messages are counted from the newest, so each channel's latest messages are the ones kept
+	newer := make(map[string]int)
+	kept := make([]StoredMessage, 0, len(storage.messages))
+	for i := len(storage.messages) - 1; i >= 0; i-- {
+		message := storage.messages[i]
+		if !retention.expired(message, newer[message.Channel]) {
+			kept = append(kept, message)
+		}
+		newer[message.Channel]++
+	}
+
+	slices.Reverse(kept)
+	deleted := len(storage.messages) - len(kept)
+	storage.messages = kept
+	return deleted, nil
+}
+
+// isConversation reports whether a message was sent directly from one of two nicknames to the other.
+func isConversation(message StoredMessage, nickname string, other string) bool {
+
+	return (message.Sender == nickname && slices.Contains(message.Recipients, other)) ||
+		(message.Sender == other && slices.Contains(message.Recipients, nickname))
+}
+
+// SaveUser implements Storage.
+func (storage *memoryStorage) SaveUser(user UserRecord) error {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	storage.users[user.Nickname] = user
+	if storage.usersFile == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(storage.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(storage.usersFile, data)
+}
+
+// LoadUsers implements Storage.
+func (storage *memoryStorage) LoadUsers() ([]UserRecord, error) {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	users := make([]UserRecord, 0, len(storage.users))
+	for _, user := range storage.users {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// storeMessage saves a sent message to the server's storage and streams it to the HTTP API streams
+// subscribed to its channel, returning its ID. A message without an ID is given the next one.
+// It must run on the hub goroutine.
+func (server *ChatServer) storeMessage(message StoredMessage) int64 {
+
+	if message.ID == 0 {
+		message.ID = server.nextMessageID()
+	}
+	message.SentAt = time.Now()
+	if err := server.storage.SaveMessage(message); err != nil {
+		slog.Error("Failed to save message", "sender", message.Sender, "err", err)
+	}
+	server.logMessage(message)
+	server.publishToStreams(message)
+	return message.ID
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiStreamPath is the HTTP API endpoint streaming channel messages as Server-Sent Events.
+const apiStreamPath = "/api/stream"
+
+// streamBufferSize is the number of messages buffered for a stream; messages are dropped while a stream's buffer is full.
+const streamBufferSize = 256
+
+// streamKeepaliveInterval is how often an idle stream sends a comment, so proxies do not close it.
+const streamKeepaliveInterval = 30 * time.Second
+
+// streamEvent is the data of a message event sent on a stream.
+type streamEvent struct {
+	Channel string    `json:"channel"`
+	Sender  string    `json:"sender"`
+	Text    string    `json:"text"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// streamSubscriber is a read-only consumer of the messages sent to a set of channels.
+type streamSubscriber struct {
+	name     string          // name is the name of the bot token the stream authenticated with
+	channels map[string]bool // channels is the set of channels whose messages are streamed
+	events   chan streamEvent
+}
+
+// publishToStreams sends a stored channel message to the streams subscribed to its channel, dropping it
+// for streams that are too far behind. It must run on the hub goroutine.
+func (server *ChatServer) publishToStreams(message StoredMessage) {
+
+	if message.Channel == "" {
+		return
+	}
+
+	channel, exists := server.channels[message.Channel]
+	if !exists {
+		return
+	}
+
+	// Access is checked again for every message, so a stream stops receiving a channel its token can no longer read
+	event := streamEvent{Channel: message.Channel, Sender: message.Sender, Text: message.Body, SentAt: message.SentAt}
+	for subscriber := range server.streams {
+		if !subscriber.channels[message.Channel] {
+			continue
+		}
+		if _, err := server.apiChannelRole(subscriber.name, channel); err != nil {
+			continue
+		}
+
+		select {
+
+			case subscriber.events <- event:
+
+			default:
+				slog.Warn("Dropped a message for a stream that is falling behind", "channel", message.Channel, "stream", subscriber.name)
+		}
+	}
+}
+
+// handleStream streams the messages sent to one or more channels, given as a comma-separated channel
+// query parameter, as Server-Sent Events. Requests authenticate with a bot token that has the read scope,
+// sent as a bearer token or, for browsers' EventSource, which cannot set headers, as a token query parameter.
+// A token may only stream channels its nickname could read, as for posting through the HTTP API.
+func (server *ChatServer) handleStream(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != http.MethodGet {
+		writer.Header().Set("Allow", http.MethodGet)
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if token := request.URL.Query().Get("token"); token != "" && request.Header.Get("Authorization") == "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	token, status, reason := server.authenticateAPIRequest(request, ScopeRead)
+	if token == nil {
+		writer.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(writer, reason, status)
+		return
+	}
+
+	subscriber := &streamSubscriber{name: token.Name, channels: make(map[string]bool), events: make(chan streamEvent, streamBufferSize)}
+	for _, channelName := range strings.Split(request.URL.Query().Get("channel"), ",") {
+		if !isChannelName(channelName) {
+			http.Error(writer, "channel must be one or more comma-separated channel names", http.StatusBadRequest)
+			return
+		}
+		subscriber.channels[channelName] = true
+	}
+
+	flusher, canFlush := writer.(http.Flusher)
+	if !canFlush {
+		http.Error(writer, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	status, reason = http.StatusOK, ""
+	server.onHub(func() {
+		for channelName := range subscriber.channels {
+			channel, exists := server.channels[channelName]
+			if !exists {
+				status, reason = http.StatusNotFound, fmt.Sprintf("no such channel %s", channelName)
+				return
+			}
+			if _, err := server.apiChannelRole(token.Name, channel); err != nil {
+				status, reason = http.StatusForbidden, err.Error()
+				return
+			}
+		}
+		server.streams[subscriber] = true
+	})
+	if status != http.StatusOK {
+		http.Error(writer, reason, status)
+		return
+	}
+
+	defer server.onHub(func() {
+		delete(server.streams, subscriber)
+	})
+
+	slog.Info("Opened message stream", "token", token.Name, "channels", request.URL.Query().Get("channel"), "remote", request.RemoteAddr)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(streamKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+
+			case <-request.Context().Done():
+				slog.Info("Closed message stream", "token", token.Name, "remote", request.RemoteAddr)
+				return
+
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(writer, ": keepalive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+
+			case event := <-subscriber.events:
+				data, err := json.Marshal(event)
+				if err != nil {
+					slog.Error("Failed to encode stream event", "err", err)
+					continue
+				}
+
+				if _, err := fmt.Fprintf(writer, "event: message\ndata: %s\n\n", data); err != nil {
+					return
+				}
+				flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemdFirstFD is the first file descriptor systemd passes to a socket-activated service.
+const systemdFirstFD = 3
+
+// systemdTLSName is the FileDescriptorName of a socket-activated socket that accepts TLS connections.
+const systemdTLSName = "tls"
+
+// activatedListener is a listening socket passed in by systemd socket activation.
+type activatedListener struct {
+	listener net.Listener
+	name     string // name is the socket's FileDescriptorName, if its unit sets one
+}
+
+// systemdListeners returns the listening sockets systemd passed to the server with socket activation,
+// or none when the server was not socket-activated. The activation variables are cleared, so processes
+// the server starts do not take the sockets to be theirs.
+func systemdListeners() ([]activatedListener, error) {
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]activatedListener, 0, count)
+	for i := 0; i < count; i++ {
+		file := os.NewFile(uintptr(systemdFirstFD+i), "LISTEN_FD_"+strconv.Itoa(systemdFirstFD+i))
+
+		// The listener holds its own duplicate of the socket, so the passed descriptor is closed
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, activated := range listeners {
+				activated.listener.Close()
+			}
+			return nil, fmt.Errorf("socket-activated descriptor %d: %w", systemdFirstFD+i, err)
+		}
+
+		activated := activatedListener{listener: listener}
+		if i < len(names) {
+			activated.name = names[i]
+		}
+		listeners = append(listeners, activated)
+	}
+	return listeners, nil
+}
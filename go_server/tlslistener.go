@@ -0,0 +1,33 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+)
+
+// serverTLSConfig returns the configuration of the TLS listener, or nil when TLS is disabled. A configuration
+// given by an embedding program is used as is; otherwise one is built from the certificate and key files.
+func serverTLSConfig(config Config) (*tls.Config, error) {
+
+	if config.TLSConfig != nil {
+		return config.TLSConfig, nil
+	}
+	if config.TLSCert == "" && config.TLSKey == "" {
+		return nil, nil
+	}
+	if config.TLSCert == "" || config.TLSKey == "" {
+		return nil, errors.New("TLS requires both -tls-cert and -tls-key")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Client certificates are requested but not verified, since /CERT binds them to accounts by fingerprint
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequestClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
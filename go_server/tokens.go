@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Token scopes, which limit what a client authenticated with /AUTH may do.
+const (
+	ScopeRead = "read" // ScopeRead allows receiving messages
+	ScopeSend = "send" // ScopeSend allows sending messages
+)
+
+// authToken is a bot credential created by an admin. Only a hash of the token is stored.
+type authToken struct {
+	Name      string    `json:"name"`       // Name is the nickname the bot takes when it authenticates
+	Hash      string    `json:"hash"`       // Hash is the hex-encoded SHA-256 hash of the token
+	Scopes    []string  `json:"scopes"`     // Scopes lists what the bot is permitted to do
+	CreatedBy string    `json:"created_by"` // CreatedBy is the nickname of the operator who created the token
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// loadTokens reads the bot tokens from the configured file. A missing file is not an error.
+func (server *ChatServer) loadTokens() error {
+
+	if server.config.TokensFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(server.config.TokensFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var tokens []*authToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return err
+	}
+
+	server.onHub(func() {
+		for _, token := range tokens {
+			server.tokens[token.Name] = token
+		}
+	})
+	return nil
+}
+
+// saveTokens writes the bot tokens to the configured file. It must run on the hub goroutine.
+func (server *ChatServer) saveTokens() {
+
+	if server.config.TokensFile == "" {
+		return
+	}
+
+	tokens := make([]*authToken, 0, len(server.tokens))
+	for _, token := range server.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		slog.Error("Failed to encode tokens", "err", err)
+		return
+	}
+
+	if err := writeFileAtomic(server.config.TokensFile, data); err != nil {
+		slog.Error("Failed to save tokens", "err", err)
+	}
+}
+
+// hashToken returns the hex-encoded SHA-256 hash under which a token is stored.
+// Tokens are long random strings, so a fast hash is sufficient.
+func hashToken(token string) string {
+
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseScopes validates a comma-separated scope list such as "read,send".
+func parseScopes(scopeList string) ([]string, bool) {
+
+	var scopes []string
+	for _, scope := range strings.Split(strings.ToLower(scopeList), ",") {
+		if scope != ScopeRead && scope != ScopeSend {
+			return nil, false
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes, true
+}
+
+// handleTokenCommand lets an admin manage bot tokens with /TOKEN CREATE <name> <scopes>,
+// /TOKEN REVOKE <name>, and /TOKEN LIST. A new token is shown only once, when it is created.
+func (server *ChatServer) handleTokenCommand(conn net.Conn, tokenArgs string) {
+
+	server.onHub(func() {
+		fields := strings.Fields(tokenArgs)
+
+		switch {
+
+			case len(fields) == 3 && fields[0] == "CREATE":
+				server.createToken(conn, fields[1], fields[2])
+
+			case len(fields) == 2 && fields[0] == "REVOKE":
+				if _, exists := server.tokens[fields[1]]; !exists {
+					sendReplyf(conn, errNoSuchToken, "No token named %s", fields[1])
+					return
+				}
+				delete(server.tokens, fields[1])
+				server.saveTokens()
+
+				sendReplyf(conn, rplToken, "Revoked token %s", fields[1])
+				server.clientLog(conn).Info("Revoked token", "token", fields[1])
+
+			case len(fields) == 1 && fields[0] == "LIST":
+				if len(server.tokens) == 0 {
+					sendReply(conn, rplTokenList, "No tokens")
+					return
+				}
+
+				names := make([]string, 0, len(server.tokens))
+				for name := range server.tokens {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+
+				for _, name := range names {
+					token := server.tokens[name]
+					sendReplyf(conn, rplTokenList, "%s (%s) created by %s on %s", name, strings.Join(token.Scopes, ","), token.CreatedBy, token.CreatedAt.Format(time.RFC1123))
+				}
+
+			default:
+				sendReplyf(conn, errUsage, "Usage: %s CREATE <name> <read,send> | %s REVOKE <name> | %s LIST", TOKEN, TOKEN, TOKEN)
+		}
+	})
+}
+
+// createToken generates a new bot token and shows it to the operator. It must run on the hub goroutine.
+func (server *ChatServer) createToken(conn net.Conn, name string, scopeList string) {
+
+	if validName, msg := validateNickname(name); !validName {
+		sendReply(conn, errInvalidNickname, msg)
+		return
+	}
+
+	scopes, valid := parseScopes(scopeList)
+	if !valid {
+		sendReplyf(conn, errInvalidArgument, "Invalid scopes %s: use %s, %s, or %s,%s", scopeList, ScopeRead, ScopeSend, ScopeRead, ScopeSend)
+		return
+	}
+
+	if _, exists := server.tokens[name]; exists {
+		sendReplyf(conn, errAlreadyExists, "A token named %s already exists", name)
+		return
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		slog.Error("Failed to generate token", "err", err)
+		sendReply(conn, errUnavailable, "Failed to generate token")
+		return
+	}
+	token := hex.EncodeToString(secret)
+
+	server.tokens[name] = &authToken{
+		Name:      name,
+		Hash:      hashToken(token),
+		Scopes:    scopes,
+		CreatedBy: server.users.nickname(conn),
+		CreatedAt: time.Now(),
+	}
+	server.saveTokens()
+
+	sendReplyf(conn, rplToken, "Created token %s (%s): %s", name, strings.Join(scopes, ","), token)
+	sendReply(conn, rplToken, "Store it now; it will not be shown again")
+	server.clientLog(conn).Info("Created token", "token", name, "scopes", strings.Join(scopes, ","))
+}
+
+// handleAuthCommand authenticates an automated client with a bot token, giving it the token's
+// nickname and restricting it to the token's scopes.
+func (server *ChatServer) handleAuthCommand(conn net.Conn, token string) {
+
+	var name string
+	server.onHub(func() {
+		client, exists := server.clients[conn]
+		if !exists {
+			return
+		}
+
+		matched := server.findToken(token)
+		if matched == nil {
+			server.clientLog(conn).Warn("Failed /AUTH attempt")
+			sendReply(conn, errInvalidToken, "Invalid token")
+			server.recordFailure(conn, "a failed /AUTH attempt")
+			return
+		}
+
+		client.scopes = matched.Scopes
+		server.clientLog(conn).Info("Authenticated with token", "token", matched.Name)
+		sendReplyf(conn, rplLoggedIn, "Authenticated as %s (%s)", matched.Name, strings.Join(matched.Scopes, ","))
+
+		if server.users.nickname(conn) != matched.Name {
+			name = matched.Name
+		}
+	})
+	if name == "" {
+		return
+	}
+
+	claim := server.claimNickname(name)
+	server.onHubWithClaim(claim, func() {
+		if _, exists := server.clients[conn]; exists && server.users.nickname(conn) != name {
+			server.setNickname(conn, claim)
+		}
+	})
+}
+
+// findToken returns the bot token matching a presented token, or nil if there is none.
+// It must run on the hub goroutine.
+func (server *ChatServer) findToken(token string) *authToken {
+
+	hash := hashToken(token)
+
+	var matched *authToken
+	for _, candidate := range server.tokens {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(candidate.Hash)) == 1 {
+			matched = candidate
+		}
+	}
+	return matched
+}
+
+// hasScope reports whether a client may perform a scoped action. Clients that did not authenticate
+// with a token are unrestricted. It must run on the hub goroutine.
+func (server *ChatServer) hasScope(conn net.Conn, scope string) bool {
+
+	client, exists := server.clients[conn]
+	return !exists || client.scopes == nil || slices.Contains(client.scopes, scope)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// whowasEntry records a nickname that was recently given up, either by disconnecting or renaming.
+type whowasEntry struct {
+	nickname       string    // nickname is the nickname that was given up
+	connectedAt    time.Time // connectedAt is when the client holding the nickname connected
+	disconnectedAt time.Time // disconnectedAt is when the nickname was given up
+	remoteHost     string    // remoteHost is the IP address the client connected from
+}
+
+// recordWhowas adds a nickname given up by a connection to the bounded /WHOWAS history,
+// discarding the oldest entries once the configured size is exceeded. It must run on the hub goroutine.
+func (server *ChatServer) recordWhowas(conn net.Conn, nickname string) {
+
+	if server.config.WhowasSize <= 0 {
+		return
+	}
+
+	entry := whowasEntry{
+		nickname:       nickname,
+		disconnectedAt: time.Now(),
+		remoteHost:     remoteIP(conn),
+	}
+	if client, exists := server.clients[conn]; exists {
+		entry.connectedAt = client.connectedAt
+	}
+
+	server.whowas = append(server.whowas, entry)
+	if len(server.whowas) > server.config.WhowasSize {
+		server.whowas = server.whowas[len(server.whowas)-server.config.WhowasSize:]
+	}
+}
+
+// handleWhowasCommand sends the requesting client the recorded history of a nickname, newest first.
+// Remote hosts are only shown to moderators and above.
+func (server *ChatServer) handleWhowasCommand(conn net.Conn, nickname string) {
+
+	server.onHub(func() {
+		found := false
+		for i := len(server.whowas) - 1; i >= 0; i-- {
+			entry := server.whowas[i]
+			if entry.nickname != nickname {
+				continue
+			}
+
+			found = true
+			if server.hasPermission(conn, PermViewAddresses) {
+				sendReplyf(conn, rplWhowas, "%s was connected from %s between %s and %s", nickname, entry.remoteHost,
+					entry.connectedAt.Format(time.RFC1123), entry.disconnectedAt.Format(time.RFC1123))
+			} else {
+				sendReplyf(conn, rplWhowas, "%s was connected between %s and %s", nickname,
+					entry.connectedAt.Format(time.RFC1123), entry.disconnectedAt.Format(time.RFC1123))
+			}
+		}
+
+		if !found {
+			sendReplyf(conn, errWasNoSuchNick, "There was no such nickname %s", nickname)
+		}
+	})
+}
+
+// handleWhoisCommand sends the requesting client details about a user: nickname, connection time,
+// idle time, and joined channels. The remote address is only shown to moderators and above and to
+// users looking up themselves.
+func (server *ChatServer) handleWhoisCommand(conn net.Conn, nickname string) {
+
+	server.onHub(func() {
+		targetConn, found := server.findUserByNickname(nickname)
+		if !found {
+			sendReplyf(conn, errNoSuchNick, "No such user %s", nickname)
+			return
+		}
+		target := server.clients[targetConn]
+		channelNames := server.channelsOf(targetConn)
+
+		sendReplyf(conn, rplWhois, "%s has been connected since %s", nickname, target.connectedAt.Format(time.RFC1123))
+		sendReplyf(conn, rplWhois, "%s has been idle for %s", nickname, time.Since(target.lastActive).Round(time.Second))
+
+		if len(channelNames) == 0 {
+			sendReplyf(conn, rplWhois, "%s is not in any channels", nickname)
+		} else {
+			sendReplyf(conn, rplWhois, "%s is in %s", nickname, strings.Join(channelNames, " "))
+		}
+
+		if target.awayMessage != "" {
+			sendReplyf(conn, rplWhois, "%s is away: %s", nickname, target.awayMessage)
+		}
+
+		devices := server.devicesOf(nickname)
+		if server.users.nickname(conn) == nickname || server.hasPermission(conn, PermViewAddresses) {
+			for _, device := range devices {
+				sendReplyf(conn, rplWhois, "%s is connected from %s", nickname, device.RemoteAddr())
+			}
+		} else if len(devices) > 1 {
+			sendReplyf(conn, rplWhois, "%s is connected from %d devices", nickname, len(devices))
+		}
+
+		if target.account != "" {
+			sendReplyf(conn, rplWhois, "%s is logged in as %s", nickname, target.account)
+		} else if _, registered := server.accounts.Get(nickname); registered {
+			sendReplyf(conn, rplWhois, "%s is a registered nickname but its user has not logged in", nickname)
+		}
+
+		if target.ssoIdentity != "" {
+			sendReplyf(conn, rplWhois, "%s is authenticated via single sign-on as %s", nickname, target.ssoIdentity)
+		}
+
+		if target.scopes != nil {
+			sendReplyf(conn, rplWhois, "%s is a bot with scopes %s", nickname, strings.Join(target.scopes, ","))
+		}
+
+		if role := server.roleOf(targetConn); role > RoleUser {
+			sendReplyf(conn, rplWhois, "%s has the %s role", nickname, role)
+		}
+	})
+}
+
+// handleWhoCommand sends the requesting client every user whose nickname matches a shell-style pattern
+// such as "ali*", along with the channels they have joined and their away status.
+func (server *ChatServer) handleWhoCommand(conn net.Conn, pattern string) {
+
+	// The pattern is matched against the user registry off the hub goroutine, which only looks up the matches
+	var nicknames []string
+	matches := make(map[string]net.Conn)
+	server.users.each(func(userConn net.Conn, nickname string) bool {
+		if _, listed := matches[nickname]; !listed && globMatch(pattern, nickname) {
+			matches[nickname] = userConn
+			nicknames = append(nicknames, nickname)
+		}
+		return true
+	})
+	sort.Strings(nicknames)
+
+	if len(nicknames) == 0 {
+		sendReplyf(conn, rplWho, "No users match %s", pattern)
+		return
+	}
+
+	server.onHub(func() {
+		for _, nickname := range nicknames {
+			userConn := matches[nickname]
+			client, connected := server.clients[userConn]
+			if !connected {
+				continue
+			}
+
+			entry := nickname
+			if awayMessage := client.awayMessage; awayMessage != "" {
+				entry += " (away: " + awayMessage + ")"
+			}
+			if channelNames := server.channelsOf(userConn); len(channelNames) > 0 {
+				entry += " " + strings.Join(channelNames, " ")
+			}
+
+			sendReply(conn, rplWho, entry)
+		}
+	})
+}
+
+// handleAwayCommand marks the user as away with the given message, or as back when the message is empty.
+func (server *ChatServer) handleAwayCommand(conn net.Conn, awayMessage string) {
+
+	server.onHub(func() {
+		client, exists := server.clients[conn]
+		if !exists {
+			return
+		}
+
+		client.awayMessage = strings.Trim(awayMessage, " ")
+		if client.awayMessage == "" {
+			sendReply(conn, rplAway, "You are no longer marked as away")
+		} else {
+			sendReplyf(conn, rplAway, "You are marked as away: %s", client.awayMessage)
+		}
+
+		if nickname, registered := server.users.lookup(conn); registered {
+			server.awayPresence(nickname, client.awayMessage)
+		}
+	})
+}
+
+// channelsOf returns the sorted names of the channels a connection has joined. It must run on the hub goroutine.
+func (server *ChatServer) channelsOf(conn net.Conn) []string {
+
+	var channelNames []string
+	for name, channel := range server.channels {
+		if channel.isMember(conn) {
+			channelNames = append(channelNames, name)
+		}
+	}
+	sort.Strings(channelNames)
+
+	return channelNames
+}
@@ -0,0 +1,165 @@
+// A minimal browser client for the chat server. It speaks the same line protocol as TCP clients over
+// a WebSocket: each command is sent as one message, and the server's lines are sorted into conversations.
+"use strict";
+
+const SERVER = "Server";
+
+const conversations = new Map(); // conversations maps channel names, nicknames, and SERVER to their lines
+let current = SERVER;
+let nickname = "";
+let socket = null;
+
+const $ = (id) => document.getElementById(id);
+
+function conversation(name) {
+  if (!conversations.has(name)) {
+    conversations.set(name, { lines: [], unread: false });
+    renderNav();
+  }
+  return conversations.get(name);
+}
+
+function append(name, text) {
+  const entry = conversation(name);
+  entry.lines.push({ time: new Date(), text });
+  if (name === current) {
+    renderLine(entry.lines[entry.lines.length - 1]);
+  } else {
+    entry.unread = true;
+    renderNav();
+  }
+}
+
+function select(name) {
+  current = name;
+  const entry = conversation(name);
+  entry.unread = false;
+
+  $("title").textContent = name;
+  $("leave").hidden = !name.startsWith("#");
+  $("log").replaceChildren();
+  entry.lines.forEach(renderLine);
+  renderNav();
+  $("text").focus();
+}
+
+function renderLine(line) {
+  const item = document.createElement("li");
+  const time = document.createElement("span");
+  time.className = "time";
+  time.textContent = line.time.toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
+  item.append(time, line.text);
+  $("log").append(item);
+  item.scrollIntoView({ block: "end" });
+}
+
+function renderNav() {
+  const lists = { channels: [], directs: [], server: [] };
+  for (const [name, entry] of conversations) {
+    const item = document.createElement("li");
+    item.textContent = name;
+    item.classList.toggle("active", name === current);
+    item.classList.toggle("unread", entry.unread);
+    item.onclick = () => select(name);
+    const list = name === SERVER ? "server" : name.startsWith("#") ? "channels" : "directs";
+    lists[list].push(item);
+  }
+  for (const [id, items] of Object.entries(lists)) {
+    $(id).replaceChildren(...items);
+  }
+}
+
+// replies lists the numeric replies routed by their text; the rest are shown with the server's lines.
+const replies = new Set(["RPL_JOINED", "RPL_PARTED", "RPL_NICKNAME"]);
+
+// route sorts a line from the server into the conversation it belongs to.
+function route(line) {
+  let match;
+  if ((match = line.match(/^\d{3} ([A-Z_]+) :(.*)$/))) {
+    if (replies.has(match[1])) route(match[2]);
+    else append(SERVER, match[2]);
+  } else if ((match = line.match(/^\[(#\S+)\] (.*)$/))) {
+    append(match[1], match[2]);
+  } else if ((match = line.match(/^You joined (#\S+)/))) {
+    conversation(match[1]);
+    append(match[1], line);
+    if (!line.includes("from another device")) select(match[1]);
+  } else if ((match = line.match(/^You left (#\S+)/))) {
+    conversations.delete(match[1]);
+    if (current === match[1]) select(SERVER);
+    append(SERVER, line);
+  } else if ((match = line.match(/^(\S+) (joined|left) (#\S+)$/))) {
+    append(match[3], line);
+  } else if ((match = line.match(/^(?:Nickname registered as|You changed your nickname from \S+ to|Connected as) (\S+)(?: alongside|$)/))) {
+    nickname = match[1];
+    append(SERVER, line);
+  } else if ((match = line.match(/^(\S+) said: /)) || (match = line.match(/^\* (\S+) /))) {
+    append(match[1], line);
+  } else {
+    append(SERVER, line);
+  }
+}
+
+function send(line) {
+  if (socket && socket.readyState === WebSocket.OPEN) socket.send(line);
+}
+
+function connect(event) {
+  event.preventDefault();
+  const scheme = location.protocol === "https:" ? "wss:" : "ws:";
+  socket = new WebSocket(`${scheme}//${location.host}/ws`);
+  $("status").textContent = "Connecting…";
+
+  socket.onopen = () => {
+    $("connect").hidden = true;
+    $("chat").hidden = false;
+    select(SERVER);
+    send(`/NICK ${$("nickname").value}`);
+  };
+  socket.onmessage = (message) => {
+    for (const line of message.data.split("\n")) {
+      if (line !== "") route(line);
+    }
+  };
+  socket.onclose = () => {
+    $("connect").hidden = false;
+    $("chat").hidden = true;
+    $("status").textContent = "Disconnected from the server.";
+    conversations.clear();
+  };
+}
+
+$("connect").onsubmit = connect;
+
+$("send").onsubmit = (event) => {
+  event.preventDefault();
+  const text = $("text").value.trim();
+  $("text").value = "";
+  if (text === "") return;
+
+  if (text.startsWith("/")) {
+    send(text);
+  } else if (current === SERVER) {
+    append(SERVER, "Pick a channel or direct message to talk in, or type a /COMMAND");
+  } else {
+    send(`/MSG ${current} ${text}`);
+    append(current, `${nickname} said: ${text}`);
+  }
+};
+
+$("join").onsubmit = (event) => {
+  event.preventDefault();
+  let name = $("join-name").value.trim();
+  if (!name.startsWith("#")) name = `#${name}`;
+  $("join-name").value = "";
+  send(`/JOIN ${name}`);
+};
+
+$("direct").onsubmit = (event) => {
+  event.preventDefault();
+  const name = $("direct-name").value.trim();
+  $("direct-name").value = "";
+  if (name !== "") select(name);
+};
+
+$("leave").onclick = () => send(`/PART ${current}`);
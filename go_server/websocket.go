@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to a client's handshake key to derive the accept key, as defined by RFC 6455.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketCompressed is the RSV1 bit of a frame header, set on the first frame of a message compressed with permessage-deflate.
+const websocketCompressed = 0x40
+
+// websocketDeflateTail ends every compressed message. Senders leave it off, as defined by RFC 7692.
+var websocketDeflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// WebSocket frame opcodes.
+const (
+	websocketContinuation = 0x0
+	websocketText         = 0x1
+	websocketBinary       = 0x2
+	websocketClose        = 0x8
+	websocketPing         = 0x9
+	websocketPong         = 0xA
+)
+
+// websocketConn adapts a WebSocket connection to net.Conn, so WebSocket clients are handled exactly like
+// TCP clients. Each message received reads as one line of the command protocol, and each write is sent
+// as one text message holding the same lines a TCP client would receive.
+type websocketConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	pending    []byte // pending is the unread remainder of the last message received
+	maxMessage int    // maxMessage is the largest message the client may send, the same as the longest line for TCP clients
+
+	writeMutex sync.Mutex // writeMutex keeps frames written from different goroutines from interleaving, and guards compressor
+	closeOnce  sync.Once
+
+	// With permessage-deflate, messages sent share one compression context, so repeated text such as a busy channel's
+	// name compresses well, while each message the client sends is compressed on its own and decompressed alone.
+	deflate    bool          // deflate is set when the client negotiated permessage-deflate
+	compressor *flate.Writer // compressor compresses messages sent, writing into compressed
+	compressed bytes.Buffer
+}
+
+// upgradeWebSocket completes the WebSocket opening handshake of a request and takes over its connection.
+// Errors are returned before the connection is taken over, so the caller can still reply over HTTP.
+func upgradeWebSocket(writer http.ResponseWriter, request *http.Request, maxMessage int) (*websocketConn, error) {
+
+	if request.Method != http.MethodGet {
+		return nil, errors.New("WebSocket handshakes must use GET")
+	}
+	if !headerContainsToken(request.Header, "Connection", "upgrade") || !headerContainsToken(request.Header, "Upgrade", "websocket") {
+		return nil, errors.New("not a WebSocket handshake")
+	}
+	if request.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("unsupported WebSocket version")
+	}
+	key := request.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing WebSocket key")
+	}
+
+	hijacker, canHijack := writer.(http.Hijacker)
+	if !canHijack {
+		return nil, errors.New("connection can't be upgraded")
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	// The HTTP server may have left a deadline from reading the request on the connection
+	conn.SetDeadline(time.Time{})
+
+	websocket := &websocketConn{Conn: conn, reader: buffered.Reader, maxMessage: maxMessage}
+
+	var extensions string
+	if acceptsPermessageDeflate(request.Header) {
+		websocket.deflate = true
+		websocket.compressor, _ = flate.NewWriter(&websocket.compressed, compressionLevel)
+		extensions = "Sec-WebSocket-Extensions: permessage-deflate; client_no_context_takeover\r\n"
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(buffered, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n%s\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]), extensions)
+	if err := buffered.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return websocket, nil
+}
+
+// acceptsPermessageDeflate reports whether a handshake offers permessage-deflate with parameters the server can
+// accept. Go's compressor always uses the largest window, so offers limiting the server's window are declined.
+func acceptsPermessageDeflate(header http.Header) bool {
+
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for _, offer := range strings.Split(value, ",") {
+			parameters := strings.Split(offer, ";")
+			if !strings.EqualFold(strings.TrimSpace(parameters[0]), "permessage-deflate") {
+				continue
+			}
+
+			acceptable := true
+			for _, parameter := range parameters[1:] {
+				name, value, _ := strings.Cut(strings.TrimSpace(parameter), "=")
+				if strings.EqualFold(name, "server_max_window_bits") && strings.Trim(value, `"`) != "15" {
+					acceptable = false
+				}
+			}
+			if acceptable {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headerContainsToken reports whether a comma-separated header contains a token, ignoring case.
+func headerContainsToken(header http.Header, name string, token string) bool {
+
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Read implements net.Conn, returning the received messages as newline-terminated lines.
+func (conn *websocketConn) Read(p []byte) (int, error) {
+
+	for len(conn.pending) == 0 {
+		message, err := conn.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		if !strings.HasSuffix(string(message), "\n") {
+			message = append(message, '\n')
+		}
+		conn.pending = message
+	}
+
+	n := copy(p, conn.pending)
+	conn.pending = conn.pending[n:]
+	return n, nil
+}
+
+// readMessage reads the next complete data message, joining fragmented messages and answering control frames.
+func (conn *websocketConn) readMessage() ([]byte, error) {
+
+	var message []byte
+	compressed := false
+	for {
+		final, opcode, payload, err := conn.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		// Only the first frame of a message says whether it is compressed
+		if opcode&websocketCompressed != 0 {
+			if !conn.deflate || opcode&0x0F == websocketContinuation || opcode&0x08 != 0 {
+				conn.closeWithStatus(1002)
+				return nil, errors.New("unexpected compressed WebSocket frame")
+			}
+			compressed = true
+			opcode &^= websocketCompressed
+		}
+
+		switch opcode {
+
+			case websocketPing:
+				if err := conn.writeFrame(websocketPong, payload); err != nil {
+					return nil, err
+				}
+				continue
+
+			case websocketPong:
+				continue
+
+			case websocketClose:
+				// The client's close frame is echoed back, and the closed WebSocket reads like a closed TCP connection
+				conn.closeWithPayload(payload)
+				return nil, io.EOF
+
+			case websocketText, websocketBinary, websocketContinuation:
+				if len(message)+len(payload) > conn.maxMessage {
+					conn.closeWithStatus(1009)
+					return nil, errors.New("WebSocket message too large")
+				}
+				message = append(message, payload...)
+
+			default:
+				conn.closeWithStatus(1002)
+				return nil, fmt.Errorf("unknown WebSocket opcode %d", opcode)
+		}
+
+		if final && compressed {
+			return conn.decompress(message)
+		}
+		if final {
+			return message, nil
+		}
+	}
+}
+
+// decompress inflates a message the client compressed with permessage-deflate, which must not grow past the size limit.
+func (conn *websocketConn) decompress(message []byte) ([]byte, error) {
+
+	reader := flate.NewReader(io.MultiReader(bytes.NewReader(message), bytes.NewReader(websocketDeflateTail)))
+	defer reader.Close()
+
+	// A message ends with a flush rather than the end of a stream, so running out of input is the expected end
+	inflated, err := io.ReadAll(io.LimitReader(reader, int64(conn.maxMessage)+1))
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		conn.closeWithStatus(1007)
+		return nil, err
+	}
+	if len(inflated) > conn.maxMessage {
+		conn.closeWithStatus(1009)
+		return nil, errors.New("WebSocket message too large")
+	}
+	return inflated, nil
+}
+
+// readFrame reads one frame, unmasking its payload. Clients must mask every frame they send.
+// The opcode returned keeps the frame's compressed bit.
+func (conn *websocketConn) readFrame() (bool, byte, []byte, error) {
+
+	var header [2]byte
+	if _, err := io.ReadFull(conn.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	final := header[0]&0x80 != 0
+	opcode := header[0] & (websocketCompressed | 0x0F)
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+
+		case 126:
+			var extended [2]byte
+			if _, err := io.ReadFull(conn.reader, extended[:]); err != nil {
+				return false, 0, nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(extended[:]))
+
+		case 127:
+			var extended [8]byte
+			if _, err := io.ReadFull(conn.reader, extended[:]); err != nil {
+				return false, 0, nil, err
+			}
+			length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	if !masked {
+		conn.closeWithStatus(1002)
+		return false, 0, nil, errors.New("unmasked WebSocket frame")
+	}
+	if length > uint64(conn.maxMessage) {
+		conn.closeWithStatus(1009)
+		return false, 0, nil, errors.New("WebSocket frame too large")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(conn.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return final, opcode, payload, nil
+}
+
+// Write implements net.Conn, sending p as one text message, compressed if the client negotiated permessage-deflate.
+func (conn *websocketConn) Write(p []byte) (int, error) {
+
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	opcode, payload := byte(websocketText), p
+	if conn.deflate {
+		conn.compressed.Reset()
+		conn.compressor.Write(p)
+		conn.compressor.Flush()
+
+		opcode |= websocketCompressed
+		payload = bytes.TrimSuffix(conn.compressed.Bytes(), websocketDeflateTail)
+	}
+
+	// A frame cut short can't be resumed, and the compression context has already moved past it,
+	// so a message that fails to send ends the connection rather than being retried
+	if err := conn.sendFrame(opcode, payload); err != nil {
+		conn.Conn.Close()
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame sends a single unfragmented frame.
+func (conn *websocketConn) writeFrame(opcode byte, payload []byte) error {
+
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	return conn.sendFrame(opcode, payload)
+}
+
+// sendFrame sends a single unfragmented frame. Frames sent by the server are not masked.
+// The caller must hold conn.writeMutex.
+func (conn *websocketConn) sendFrame(opcode byte, payload []byte) error {
+
+	frame := []byte{0x80 | opcode}
+	switch {
+
+		case len(payload) < 126:
+			frame = append(frame, byte(len(payload)))
+
+		case len(payload) <= 0xFFFF:
+			frame = append(frame, 126)
+			frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+
+		default:
+			frame = append(frame, 127)
+			frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	_, err := conn.Conn.Write(frame)
+	return err
+}
+
+// closeWithStatus sends a close frame with a status code and closes the connection.
+func (conn *websocketConn) closeWithStatus(status uint16) {
+
+	conn.closeWithPayload(binary.BigEndian.AppendUint16(nil, status))
+}
+
+// closeWithPayload sends a close frame and closes the connection, unless it is already closed.
+func (conn *websocketConn) closeWithPayload(payload []byte) {
+
+	conn.closeOnce.Do(func() {
+		conn.writeFrame(websocketClose, payload)
+		conn.Conn.Close()
+	})
+}
+
+// Close implements net.Conn, telling the client the connection is closing normally.
+func (conn *websocketConn) Close() error {
+
+	conn.closeWithStatus(1000)
+	return nil
+}
+
+// handleWebSocket upgrades a request to a WebSocket and serves it as a chat client. Any origin may connect,
+// since clients authenticate within the chat protocol rather than with cookies a browser would send for them.
+func (server *ChatServer) handleWebSocket(writer http.ResponseWriter, request *http.Request) {
+
+	conn, err := upgradeWebSocket(writer, request, server.config.MaxLineLength)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	server.serveConnection(conn)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFiles holds the browser chat client, which connects back to the server over WebSocket.
+//
+//go:embed web
+var webFiles embed.FS
+
+// webUIHandler serves the browser chat client from the root of the HTTP endpoint.
+func webUIHandler() http.Handler {
+
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		fatal("Failed to load the web client", "err", err)
+	}
+	return http.FileServer(http.FS(files))
+}
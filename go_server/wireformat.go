@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+
+	"server/chatpb"
+)
+
+// wireFormat is the encoding of the lines exchanged with a client.
+type wireFormat int
+
+const (
+	textFormat     wireFormat = iota // textFormat exchanges plain text lines, the default for telnet and netcat users
+	jsonFormat                       // jsonFormat exchanges one JSON object per line
+	protobufFormat                   // protobufFormat exchanges length-prefixed protobuf messages, the same as the gRPC API's
+)
+
+// protobufPreamble starts the connection of a client using the protobuf wire format. Its first byte is
+// one no text or JSON client sends, so it is recognized without waiting for a whole line.
+const protobufPreamble = "\x00CHATPB1"
+
+// compressionLevel is the flate level of compressed streams and WebSocket messages. Each line is flushed as soon as it is
+// written, and at lower levels the compressor finds no matches in such small writes, storing every line uncompressed.
+const compressionLevel = flate.BestCompression
+
+// jsonCommand is a command from a client in JSON mode, such as {"type":"msg","to":["bob"],"body":"hi"}.
+type jsonCommand struct {
+	Type    string   `json:"type"`              // Type is hello, nick, join, part, msg, action, list, typing, or command
+	To      []string `json:"to,omitempty"`      // To lists the channels and nicknames of a msg or action
+	Body    string   `json:"body,omitempty"`    // Body is the text of a msg or action
+	Nick    string   `json:"nick,omitempty"`    // Nick is the nickname of a nick command
+	Channel string   `json:"channel,omitempty"` // Channel is the channel of a join or part
+	Key     string   `json:"key,omitempty"`     // Key is the key of a join to a channel protected with a key
+	State   string   `json:"state,omitempty"`   // State is active, paused, or done for a typing notification
+	Line    string   `json:"line,omitempty"`    // Line is any other command of the text protocol, for a command
+	Dedup   string   `json:"dedup,omitempty"`   // Dedup is the dedup ID of a msg or action, so that a retry of it is not delivered twice
+}
+
+// jsonEvent is a line sent to a client in JSON mode: a delivered message, the outcome of a message
+// the client sent, a numeric reply or error, or a notice holding any other line.
+type jsonEvent struct {
+	Type    string `json:"type"` // Type is msg, action, ack, nack, typing, reply, notice, or error
+	Channel string `json:"channel,omitempty"`
+	From    string `json:"from,omitempty"`
+	Body    string `json:"body,omitempty"`
+	Text    string `json:"text,omitempty"`    // Text is the line of a notice, or the text of a reply or error
+	ID      int64  `json:"id,omitempty"`      // ID is the ID of an acknowledged message
+	Code    string `json:"code,omitempty"`    // Code is the NACK code of a refused message, or the name of a numeric reply or error
+	To      string `json:"to,omitempty"`      // To is the target of an acknowledged or refused message, or of a typing notification
+	State   string `json:"state,omitempty"`   // State is the state of a typing notification
+	Time    string `json:"time,omitempty"`    // Time is when a message was sent, for clients with the server-time capability
+	Seq     uint64 `json:"seq,omitempty"`     // Seq is the sequence number of a message, for clients with the seq capability
+	Numeric int    `json:"numeric,omitempty"` // Numeric is the number of a numeric reply or error
+}
+
+// deliveredMessagePattern matches the lines delivering a message, with an optional channel prefix.
+var deliveredMessagePattern = regexp.MustCompile(`^(?:\[(#\S+)\] )?(?:([a-zA-Z0-9_]+) said: |\* ([a-zA-Z0-9_]+) )(.*)$`)
+
+// acknowledgmentPattern matches the ACK and NACK lines telling a client the outcome of a message it sent.
+var acknowledgmentPattern = regexp.MustCompile(`^(ACK|NACK) (\d+|[A-Z_]+) (\S+)$`)
+
+// typingPattern matches the lines relaying a typing notification.
+var typingPattern = regexp.MustCompile(`^TYPING ([a-zA-Z0-9_]+) (\S+) (\S+)$`)
+
+// messageTagsPattern matches the tags of a line sent to a client with the seq or server-time capability.
+var messageTagsPattern = regexp.MustCompile(`^@(\S+) `)
+
+// wireConn negotiates a client's wire format from the start of what it sends and translates between that
+// format and the text lines the command handlers read and write. A first line that is a JSON object
+// switches the connection to JSON mode in both directions, and the protobuf preamble switches it to
+// length-prefixed protobuf messages, each a chatpb.ClientMessage from the client and a chatpb.ServerMessage
+// from the server, varint length first. Anything else keeps the text protocol.
+type wireConn struct {
+	net.Conn
+	reader     *bufio.Reader // reader is replaced when compression starts, by setCapabilities on the reading goroutine
+	negotiated bool          // negotiated is set once the first line has decided the format; only Read uses it
+	maxLine    int           // maxLine is the longest JSON line or protobuf message the client may send, the same as for text lines
+	pending    []byte        // pending is the unread remainder of the last command translated
+
+	writeMutex   sync.Mutex // writeMutex guards format, partial, and capabilities, and keeps encoded lines from interleaving
+	format       wireFormat
+	partial      []byte               // partial is a line written in pieces whose end has not been written yet
+	capabilities map[string]bool      // capabilities is the set of capabilities enabled for the connection
+	compressor   *flate.Writer        // compressor compresses everything written once the deflate capability is enabled
+	compressed   bytes.Buffer         // compressed holds the compressor's output until it is queued
+	outbound     chan *outboundBuffer // outbound queues the encoded bytes for the writer goroutine; it is closed when the connection is
+	closed       bool                 // closed is set once outbound is closed
+	drained      chan struct{}        // drained is closed once the writer goroutine has finished
+}
+
+// newWireConn wraps a client connection to negotiate its wire format, and starts the goroutine writing to it
+// from its send queue, with the limits in the configuration. gRPC sessions structure every line already,
+// so they start with message IDs enabled like the structured wire formats.
+func newWireConn(conn net.Conn, config Config) *wireConn {
+
+	wire := &wireConn{Conn: conn, reader: bufio.NewReader(conn), maxLine: config.MaxLineLength, capabilities: make(map[string]bool)}
+	if _, isGRPC := conn.(*grpcConn); isGRPC {
+		wire.capabilities[capMessageIDs] = true
+	}
+	wire.startWriter(config.SendQueueSize, config.WriteTimeout)
+	return wire
+}
+
+// Read implements net.Conn, returning the client's commands as text lines whatever their wire format.
+func (conn *wireConn) Read(p []byte) (int, error) {
+
+	if !conn.negotiated {
+		first, err := conn.reader.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		conn.negotiated = true
+
+		format := textFormat
+		switch first[0] {
+
+			case '{':
+				format = jsonFormat
+
+			case protobufPreamble[0]:
+				preamble := make([]byte, len(protobufPreamble))
+				if _, err := io.ReadFull(conn.reader, preamble); err != nil {
+					return 0, err
+				}
+				if string(preamble) != protobufPreamble {
+					return 0, errors.New("unsupported protobuf wire format version")
+				}
+				format = protobufFormat
+		}
+
+		conn.writeMutex.Lock()
+		conn.format = format
+		if format != textFormat {
+			conn.capabilities[capMessageIDs] = true
+		}
+		if format == jsonFormat {
+			conn.capabilities[capJSON] = true
+		}
+		conn.writeMutex.Unlock()
+	}
+
+	conn.writeMutex.Lock()
+	format := conn.format
+	conn.writeMutex.Unlock()
+
+	if format == textFormat {
+		return conn.reader.Read(p)
+	}
+
+	for len(conn.pending) == 0 && format == protobufFormat {
+		// An oversize message is skipped using its length, but a malformed one leaves no way to find the start
+		// of the next, so it ends the connection
+		var message chatpb.ClientMessage
+		var tooLarge *protodelim.SizeTooLargeError
+		err := (protodelim.UnmarshalOptions{MaxSize: int64(conn.maxLine)}).UnmarshalFrom(conn.reader, &message)
+		if errors.As(err, &tooLarge) {
+			if _, err := conn.reader.Discard(int(tooLarge.Size)); err != nil {
+				return 0, err
+			}
+			sendReply(conn, errLineTooLong, lineTooLongMessage(conn.maxLine))
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if command, valid := grpcCommandLine(&message); valid {
+			command, _, _ = strings.Cut(command, "\n")
+			conn.pending = []byte(command + "\n")
+		}
+	}
+
+	for len(conn.pending) == 0 {
+		line, tooLong, err := readLine(conn.reader, conn.maxLine)
+		if err != nil {
+			return 0, err
+		}
+		if tooLong {
+			sendReply(conn, errLineTooLong, lineTooLongMessage(conn.maxLine))
+			continue
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			if command, valid := conn.translateCommand(line); valid {
+				conn.pending = []byte(command + "\n")
+			}
+		}
+	}
+
+	n := copy(p, conn.pending)
+	conn.pending = conn.pending[n:]
+	return n, nil
+}
+
+// setCapabilities applies the changes of a granted /CAP REQ, each a capability to enable or, with a leading '-',
+// to disable. Enabling JSON switches the connection to the JSON wire format for the lines read and written from
+// then on, with message IDs as when JSON is negotiated; lines the client sent before it was granted are still read as text.
+// Enabling deflate compresses the stream in both directions from then on, so the client must send nothing between
+// its request and the acknowledgment. It must be called on the goroutine reading from the connection.
+func (conn *wireConn) setCapabilities(changes []string) {
+
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	for _, change := range changes {
+		capability, disable := strings.CutPrefix(change, "-")
+		if capability == capJSON && !disable && conn.format != jsonFormat {
+			conn.format = jsonFormat
+			conn.capabilities[capMessageIDs] = true
+		}
+		if capability == capDeflate && !disable && conn.compressor == nil {
+			conn.compressor, _ = flate.NewWriter(&conn.compressed, compressionLevel)
+			conn.reader = bufio.NewReader(flate.NewReader(conn.reader))
+		}
+		conn.capabilities[capability] = !disable
+	}
+}
+
+// translateCommand returns the text protocol command equivalent to a JSON command, replying with an
+// error event for commands it can't translate. A hello command only negotiates the format, so it has none.
+func (conn *wireConn) translateCommand(line []byte) (string, bool) {
+
+	var command jsonCommand
+	if err := json.Unmarshal(line, &command); err != nil {
+		conn.writeEvent(jsonEvent{Type: "error", Text: "invalid JSON: " + err.Error()})
+		return "", false
+	}
+
+	var text string
+	switch command.Type {
+
+		case "hello":
+			return "", false
+
+		case "nick":
+			text = NICK + " " + command.Nick
+
+		case "join":
+			text = strings.TrimSpace(JOIN + " " + command.Channel + " " + command.Key)
+
+		case "part":
+			text = PART + " " + command.Channel
+
+		case "msg":
+			text = withDedupID(command.Dedup, MSG+" "+strings.Join(command.To, ",")+" "+command.Body)
+
+		case "action":
+			text = withDedupID(command.Dedup, ME+" "+strings.Join(command.To, ",")+" "+command.Body)
+
+		case "list":
+			text = LIST
+
+		case "typing":
+			text = strings.TrimSpace(TYPING + " " + strings.Join(command.To, ",") + " " + command.State)
+
+		case "command":
+			text = command.Line
+
+		default:
+			conn.writeEvent(jsonEvent{Type: "error", Text: "unknown command type " + command.Type})
+			return "", false
+	}
+
+	// A command can't carry further commands on lines of its own
+	text, _, _ = strings.Cut(text, "\n")
+	return text, true
+}
+
+// Write implements net.Conn, encoding each complete line written in the client's wire format.
+func (conn *wireConn) Write(p []byte) (int, error) {
+
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	if conn.format == textFormat {
+		if err := conn.writeStream(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	conn.partial = append(conn.partial, p...)
+	var encoded bytes.Buffer
+	for {
+		line, rest, complete := bytes.Cut(conn.partial, []byte("\n"))
+		if !complete {
+			break
+		}
+		event := parseServerLine(string(line))
+		if conn.format == protobufFormat {
+			protodelim.MarshalTo(&encoded, event.serverMessage(string(line)))
+		} else {
+			encoded.Write(encodeJSONEvent(event))
+		}
+		conn.partial = rest
+	}
+
+	if err := conn.writeStream(encoded.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeEvent sends a single event to a client in JSON mode.
+func (conn *wireConn) writeEvent(event jsonEvent) {
+
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	conn.writeStream(encodeJSONEvent(event))
+}
+
+// writeStream queues encoded bytes to be written to the underlying connection, compressing and flushing them
+// when the deflate capability is enabled. The caller must hold conn.writeMutex.
+func (conn *wireConn) writeStream(p []byte) error {
+
+	if conn.compressor == nil {
+		return conn.enqueue(p)
+	}
+
+	// Each write is flushed so that the client can decompress every line as soon as it arrives
+	if _, err := conn.compressor.Write(p); err != nil {
+		return err
+	}
+	if err := conn.compressor.Flush(); err != nil {
+		return err
+	}
+	defer conn.compressed.Reset()
+	return conn.enqueue(conn.compressed.Bytes())
+}
+
+// parseServerLine structures a line the server sends: lines delivering messages become msg and action
+// events, ACK, NACK, and TYPING lines become ack, nack, and typing events, and every other line becomes a notice.
+// The seq and time tags of a line are moved to the event's seq and time.
+func parseServerLine(line string) jsonEvent {
+
+	if match := messageTagsPattern.FindStringSubmatch(line); match != nil {
+		event := parseServerLine(line[len(match[0]):])
+		for _, tag := range strings.Split(match[1], ";") {
+			name, value, _ := strings.Cut(tag, "=")
+			switch name {
+
+				case "seq":
+					event.Seq, _ = strconv.ParseUint(value, 10, 64)
+
+				case "time":
+					event.Time = value
+			}
+		}
+		return event
+	}
+
+	if match := typingPattern.FindStringSubmatch(line); match != nil {
+		return jsonEvent{Type: "typing", From: match[1], To: match[2], State: match[3]}
+	}
+
+	if match := replyPattern.FindStringSubmatch(line); match != nil {
+		numeric, _ := strconv.Atoi(match[1])
+		if numeric >= 400 {
+			return jsonEvent{Type: "error", Numeric: numeric, Code: match[2], Text: match[3]}
+		}
+		return jsonEvent{Type: "reply", Numeric: numeric, Code: match[2], Text: match[3]}
+	}
+
+	if match := acknowledgmentPattern.FindStringSubmatch(line); match != nil {
+		if match[1] == "NACK" {
+			return jsonEvent{Type: "nack", Code: match[2], To: match[3]}
+		}
+		id, _ := strconv.ParseInt(match[2], 10, 64)
+		return jsonEvent{Type: "ack", ID: id, To: match[3]}
+	}
+
+	match := deliveredMessagePattern.FindStringSubmatch(line)
+	switch {
+
+		case match == nil:
+			return jsonEvent{Type: "notice", Text: line}
+
+		case match[2] != "":
+			return jsonEvent{Type: "msg", Channel: match[1], From: match[2], Body: match[4]}
+
+		default:
+			return jsonEvent{Type: "action", Channel: match[1], From: match[3], Body: match[4]}
+	}
+}
+
+// serverMessage returns the protobuf message sending an event, whose line as a text client would receive it is line.
+func (event jsonEvent) serverMessage(line string) *chatpb.ServerMessage {
+
+	kinds := map[string]chatpb.ServerMessage_Kind{
+		"msg":    chatpb.ServerMessage_KIND_MESSAGE,
+		"action": chatpb.ServerMessage_KIND_ACTION,
+		"error":  chatpb.ServerMessage_KIND_ERROR,
+		"ack":    chatpb.ServerMessage_KIND_ACK,
+		"nack":   chatpb.ServerMessage_KIND_NACK,
+		"typing": chatpb.ServerMessage_KIND_TYPING,
+		"reply":  chatpb.ServerMessage_KIND_REPLY,
+	}
+	body := event.Body
+	if event.Numeric != 0 {
+		body = event.Text
+	}
+	return &chatpb.ServerMessage{
+		Text:    line,
+		Kind:    kinds[event.Type],
+		Channel: event.Channel,
+		From:    event.From,
+		Body:    body,
+		Id:      event.ID,
+		Code:    event.Code,
+		To:      event.To,
+		State:   event.State,
+		Time:    event.Time,
+		Seq:     event.Seq,
+		Numeric: int32(event.Numeric),
+	}
+}
+
+// encodeJSONEvent encodes an event as a line of JSON.
+func encodeJSONEvent(event jsonEvent) []byte {
+
+	data, _ := json.Marshal(event)
+	return append(data, '\n')
+}
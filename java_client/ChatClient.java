@@ -16,6 +16,11 @@ public class ChatClient {
             String response;
             try {
                 while ((response = input.readLine()) != null) {
+                    // Answer server keepalive pings without showing them to the user
+                    if (response.startsWith("PING ")) {
+                        output.println("/PONG " + response.substring(5));
+                        continue;
+                    }
                     System.out.println(response);
                     System.out.print("> "); // Prompt after displaying the message
                 }